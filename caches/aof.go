@@ -0,0 +1,510 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/11/21 16:08:52
+
+package caches
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	// FsyncAlways means syncing the append log to disk after every write.
+	FsyncAlways = "always"
+
+	// FsyncEverySecond means syncing the append log to disk every second.
+	FsyncEverySecond = "everysec"
+
+	// FsyncNever means never syncing the append log and leaving it to the operating system.
+	FsyncNever = "no"
+)
+
+const (
+	// setOp is the op of set record.
+	// Its data starts with expiration, atime, max ttl, version and type of value.
+	// The rest is the data of a string, the hash encoded by encodeHash or the list encoded by encodeList.
+	setOp = byte(1)
+
+	// deleteOp is the op of delete record.
+	deleteOp = byte(2)
+
+	// hashSetOp is the op of record setting fields of a hash.
+	// Its data is the version of value after setting, followed by the fields encoded by encodeHash.
+	// Only changed fields are logged, so changing a field of a large hash doesn't log the whole hash.
+	hashSetOp = byte(3)
+
+	// hashDeleteOp is the op of record deleting fields of a hash.
+	// Its data is the version of value after deleting, followed by the fields encoded by encodeHash without data.
+	hashDeleteOp = byte(4)
+
+	// listPushOp is the op of record pushing items to a list.
+	// Its data is the version of value after pushing, a byte which is 1 if items are pushed to the head,
+	// followed by the items encoded by encodeList.
+	listPushOp = byte(5)
+
+	// listPopOp is the op of record popping an item from a list.
+	// Its data is the version of value after popping, and a byte which is 1 if the head is popped.
+	listPopOp = byte(6)
+
+	// versionSize is the size of version at the start of data in records changing a value in place, such as hashSetOp.
+	versionSize = 8
+
+	// setHeaderSize is the size of expiration, atime, max ttl, version and type in data of set record.
+	setHeaderSize = 1 + 8 + 8 + 8 + 1
+
+	// recordHeaderSize is the size of length and crc of one record.
+	recordHeaderSize = 8
+
+	// recordFixedSize is the size of op, seq, ttl, ctime and key length in payload.
+	recordFixedSize = 1 + 8 + 8 + 8 + 4
+)
+
+var (
+	// corruptedRecordErr means the record in append log is corrupted.
+	corruptedRecordErr = errors.New("the record in append log is corrupted")
+
+	// appendLogClosedErr means the append log is closed.
+	appendLogClosedErr = errors.New("the append log is closed")
+)
+
+// logRecord is one record of the append log.
+type logRecord struct {
+
+	// op is the operation of record, such as setOp and deleteOp.
+	op byte
+
+	// seq is the sequence number of record.
+	seq uint64
+
+	// key is the key of entry.
+	key string
+
+	// value is the value of entry in set record, and it's nil in other records.
+	value *value
+
+	// version is the version of value after applying a record changing it in place, such as hashSetOp.
+	version uint64
+
+	// fields are the fields set by hashSetOp or deleted by hashDeleteOp.
+	fields map[string][]byte
+
+	// items are the items pushed by listPushOp.
+	items [][]byte
+
+	// left is true if listPushOp or listPopOp works on the head of list.
+	left bool
+}
+
+// encode returns the bytes of record including its length and crc.
+func (lr *logRecord) encode() []byte {
+	var data []byte
+	var ttl, ctime int64
+	switch lr.op {
+	case setOp:
+		ttl, ctime = lr.value.Ttl, lr.value.Ctime
+		data = encodeSetData(lr.value)
+	case hashSetOp, hashDeleteOp:
+		data = make([]byte, versionSize)
+		binary.BigEndian.PutUint64(data, lr.version)
+		data = append(data, encodeHash(lr.fields)...)
+	case listPushOp, listPopOp:
+		data = make([]byte, versionSize+1)
+		binary.BigEndian.PutUint64(data, lr.version)
+		if lr.left {
+			data[versionSize] = 1
+		}
+
+		if lr.op == listPushOp {
+			data = append(data, encodeList(lr.items)...)
+		}
+	}
+
+	payloadSize := recordFixedSize + len(lr.key) + len(data)
+	record := make([]byte, recordHeaderSize+payloadSize)
+	payload := record[recordHeaderSize:]
+	payload[0] = lr.op
+	binary.BigEndian.PutUint64(payload[1:], lr.seq)
+	binary.BigEndian.PutUint64(payload[9:], uint64(ttl))
+	binary.BigEndian.PutUint64(payload[17:], uint64(ctime))
+	binary.BigEndian.PutUint32(payload[25:], uint32(len(lr.key)))
+	copy(payload[recordFixedSize:], lr.key)
+	copy(payload[recordFixedSize+len(lr.key):], data)
+
+	binary.BigEndian.PutUint32(record, uint32(payloadSize))
+	binary.BigEndian.PutUint32(record[4:], crc32.ChecksumIEEE(payload))
+	return record
+}
+
+// encodeSetData returns the data of set record with value.
+func encodeSetData(value *value) []byte {
+	typ := value.typ()
+	data := make([]byte, setHeaderSize, setHeaderSize+len(value.Data))
+	data[0] = byte(value.Expiration)
+	binary.BigEndian.PutUint64(data[1:], uint64(value.Atime))
+	binary.BigEndian.PutUint64(data[9:], uint64(value.MaxTtl))
+	binary.BigEndian.PutUint64(data[17:], value.Version)
+	data[25] = byte(typ)
+
+	switch typ {
+	case hashType:
+		return append(data, encodeHash(value.Hash)...)
+	case listType:
+		return append(data, encodeList(value.List)...)
+	default:
+		return append(data, value.Data...)
+	}
+}
+
+// decodeRecord returns the record decoded from payload.
+func decodeRecord(payload []byte) (*logRecord, error) {
+	if len(payload) < recordFixedSize {
+		return nil, corruptedRecordErr
+	}
+
+	keySize := int(binary.BigEndian.Uint32(payload[25:]))
+	if len(payload) < recordFixedSize+keySize {
+		return nil, corruptedRecordErr
+	}
+
+	record := &logRecord{
+		op:  payload[0],
+		seq: binary.BigEndian.Uint64(payload[1:]),
+		key: string(payload[recordFixedSize : recordFixedSize+keySize]),
+	}
+
+	ok := true
+	data := payload[recordFixedSize+keySize:]
+	switch record.op {
+	case deleteOp:
+	case setOp:
+		ttl := int64(binary.BigEndian.Uint64(payload[9:]))
+		ctime := int64(binary.BigEndian.Uint64(payload[17:]))
+		record.value, ok = decodeSetData(data, ttl, ctime)
+	case hashSetOp, hashDeleteOp:
+		if len(data) < versionSize {
+			return nil, corruptedRecordErr
+		}
+
+		record.version = binary.BigEndian.Uint64(data)
+		record.fields, ok = decodeHash(data[versionSize:])
+	case listPushOp, listPopOp:
+		if len(data) < versionSize+1 {
+			return nil, corruptedRecordErr
+		}
+
+		record.version = binary.BigEndian.Uint64(data)
+		record.left = data[versionSize] == 1
+		if record.op == listPushOp {
+			record.items, ok = decodeList(data[versionSize+1:])
+		}
+	default:
+		ok = false
+	}
+
+	if !ok {
+		return nil, corruptedRecordErr
+	}
+	return record, nil
+}
+
+// decodeSetData returns the value of set record decoded from data with ttl and ctime, and false if failed.
+func decodeSetData(data []byte, ttl int64, ctime int64) (*value, bool) {
+	if len(data) < setHeaderSize {
+		return nil, false
+	}
+
+	value := &value{
+		Ttl:        ttl,
+		Ctime:      ctime,
+		Expiration: ExpirationMode(data[0]),
+		Atime:      int64(binary.BigEndian.Uint64(data[1:])),
+		MaxTtl:     int64(binary.BigEndian.Uint64(data[9:])),
+		Version:    binary.BigEndian.Uint64(data[17:]),
+	}
+
+	ok := true
+	typ := valueType(data[25])
+	data = data[setHeaderSize:]
+	switch typ {
+	case stringType:
+		value.Data = data
+	case hashType:
+		value.Hash, ok = decodeHash(data)
+	case listType:
+		value.List, ok = decodeList(data)
+	default:
+		ok = false
+	}
+
+	value.countFieldsSize()
+	return value, ok
+}
+
+// readRecords reads all records in appendFile and calls apply for each of them.
+// A broken record at the tail is treated as an incomplete write, so it stops reading there.
+// Returns the offset of the end of the last good record and the max seq of records.
+// Returns an error if a record with the right checksum can't be decoded, such as an unknown op.
+func readRecords(appendFile string, apply func(record *logRecord)) (int64, uint64, error) {
+
+	file, err := os.Open(appendFile)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	offset := int64(0)
+	maxSeq := uint64(0)
+	reader := bufio.NewReader(file)
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err = io.ReadFull(reader, header); err != nil {
+			return offset, maxSeq, nil
+		}
+
+		payloadSize := int64(binary.BigEndian.Uint32(header))
+		if offset+recordHeaderSize+payloadSize > fileInfo.Size() {
+			return offset, maxSeq, nil
+		}
+
+		payload := make([]byte, payloadSize)
+		if _, err = io.ReadFull(reader, payload); err != nil {
+			return offset, maxSeq, nil
+		}
+
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+			return offset, maxSeq, nil
+		}
+
+		// The checksum is right, so the record isn't broken by a crash but can't be understood.
+		// Refuse to start instead of dropping it and all records after it.
+		record, err := decodeRecord(payload)
+		if err != nil {
+			return offset, maxSeq, fmt.Errorf("decode record at offset %d failed: %w", offset, err)
+		}
+
+		apply(record)
+		offset += int64(recordHeaderSize + len(payload))
+		if record.seq > maxSeq {
+			maxSeq = record.seq
+		}
+	}
+}
+
+// appendLog records every write operation of cache in an append-only file.
+type appendLog struct {
+
+	// path is the path of append file.
+	path string
+
+	// file is the append file opened.
+	file *os.File
+
+	// fsync is the policy of syncing file to disk.
+	fsync string
+
+	// size is the size of append file.
+	size int64
+
+	// seq is the sequence number of the last record.
+	seq uint64
+
+	// closed is true after closing, and the log refuses all work then.
+	// Background tasks may still be running when cache.Close gives up waiting for them.
+	closed bool
+
+	// lock is for concurrency.
+	lock *sync.Mutex
+}
+
+// openAppendLog opens the append log of path and returns an error if failed.
+// The file will be truncated to size, so broken records at the tail will be dropped.
+func openAppendLog(path string, fsync string, size int64, seq uint64) (*appendLog, error) {
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = file.Truncate(size); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if _, err = file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &appendLog{
+		path:  path,
+		file:  file,
+		fsync: fsync,
+		size:  size,
+		seq:   seq,
+		lock:  &sync.Mutex{},
+	}, nil
+}
+
+// append writes record to log with the next seq and returns an error if failed.
+func (al *appendLog) append(record *logRecord) error {
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	if al.closed {
+		return appendLogClosedErr
+	}
+
+	record.seq = al.seq + 1
+	encoded := record.encode()
+	if _, err := al.file.Write(encoded); err != nil {
+		// Drop the broken record, so the records appended later can be read.
+		al.file.Truncate(al.size)
+		al.file.Seek(al.size, io.SeekStart)
+		return err
+	}
+
+	al.size += int64(len(encoded))
+	al.seq = record.seq
+	if al.fsync == FsyncAlways {
+		return al.file.Sync()
+	}
+	return nil
+}
+
+// appendSet writes a set record to log.
+func (al *appendLog) appendSet(key string, value *value) error {
+	return al.append(&logRecord{op: setOp, key: key, value: value})
+}
+
+// appendDelete writes a delete record to log.
+func (al *appendLog) appendDelete(key string) error {
+	return al.append(&logRecord{op: deleteOp, key: key})
+}
+
+// appendHashSet writes a record setting fields of the hash of key to log, and version is the version after setting.
+func (al *appendLog) appendHashSet(key string, version uint64, fields map[string][]byte) error {
+	return al.append(&logRecord{op: hashSetOp, key: key, version: version, fields: fields})
+}
+
+// appendHashDelete writes a record deleting fields of the hash of key to log, and version is the version after deleting.
+// Only the names of fields are logged.
+func (al *appendLog) appendHashDelete(key string, version uint64, fields map[string][]byte) error {
+	names := make(map[string][]byte, len(fields))
+	for field := range fields {
+		names[field] = nil
+	}
+	return al.append(&logRecord{op: hashDeleteOp, key: key, version: version, fields: names})
+}
+
+// appendListPush writes a record pushing items to the list of key to log, and version is the version after pushing.
+func (al *appendLog) appendListPush(key string, version uint64, left bool, items [][]byte) error {
+	return al.append(&logRecord{op: listPushOp, key: key, version: version, left: left, items: items})
+}
+
+// appendListPop writes a record popping an item from the list of key to log, and version is the version after popping.
+func (al *appendLog) appendListPop(key string, version uint64, left bool) error {
+	return al.append(&logRecord{op: listPopOp, key: key, version: version, left: left})
+}
+
+// mark returns the current size and seq of log.
+// Records after the returned size all have seqs greater than the returned seq.
+func (al *appendLog) mark() (int64, uint64) {
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	return al.size, al.seq
+}
+
+// currentSize returns the size of log.
+func (al *appendLog) currentSize() int64 {
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	return al.size
+}
+
+// compact drops all records before offset and returns an error if failed.
+// It's used after dumping, so records included in the dump file will be dropped.
+func (al *appendLog) compact(offset int64) error {
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	if al.closed {
+		return appendLogClosedErr
+	}
+
+	oldFile, err := os.Open(al.path)
+	if err != nil {
+		return err
+	}
+	defer oldFile.Close()
+
+	if _, err = oldFile.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	newPath := al.path + nowSuffix()
+	newFile, err := os.OpenFile(newPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	size, err := io.Copy(newFile, oldFile)
+	if err == nil {
+		err = newFile.Sync()
+	}
+
+	if err == nil {
+		err = os.Rename(newPath, al.path)
+	}
+
+	if err != nil {
+		newFile.Close()
+		os.Remove(newPath)
+		return err
+	}
+
+	al.file.Close()
+	al.file = newFile
+	al.size = size
+	return nil
+}
+
+// sync syncs the log to disk.
+func (al *appendLog) sync() error {
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	if al.closed {
+		return appendLogClosedErr
+	}
+	return al.file.Sync()
+}
+
+// close syncs and closes the log, and it does nothing if the log is closed.
+func (al *appendLog) close() error {
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	if al.closed {
+		return nil
+	}
+
+	al.closed = true
+	al.file.Sync()
+	return al.file.Close()
+}
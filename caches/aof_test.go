@@ -0,0 +1,201 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/11/21 17:45:10
+
+package caches
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// go test -cover -run=^TestAppendLog$
+func TestAppendLog(t *testing.T) {
+
+	appendFile := filepath.Join(os.TempDir(), "TestAppendLog.aof")
+	os.Remove(appendFile)
+
+	log, err := openAppendLog(appendFile, FsyncAlways, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = log.appendSet("key", newValue([]byte("value"), SetOptions{Ttl: 60 * time.Millisecond}, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = log.appendDelete("key"); err != nil {
+		t.Fatal(err)
+	}
+	log.close()
+
+	// Simulate a broken record at the tail.
+	file, err := os.OpenFile(appendFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{0, 0, 0, 99, 1, 2})
+	file.Close()
+
+	var records []*logRecord
+	size, maxSeq, err := readRecords(appendFile, func(record *logRecord) {
+		records = append(records, record)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 2 || maxSeq != 2 || size != log.size {
+		t.Fatalf("Read %d records with max seq %d and size %d, but log size is %d!", len(records), maxSeq, size, log.size)
+	}
+
+	if records[0].op != setOp || records[0].key != "key" || string(records[0].value.Data) != "value" || records[0].value.Ttl != 60 {
+		t.Fatalf("The first record %+v is wrong!", records[0])
+	}
+
+	if records[1].op != deleteOp || records[1].key != "key" || records[1].seq != 2 {
+		t.Fatalf("The second record %+v is wrong!", records[1])
+	}
+}
+
+// go test -cover -run=^TestCacheAppendOnly$
+func TestCacheAppendOnly(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpFile = filepath.Join(os.TempDir(), "TestCacheAppendOnly.dump")
+	options.AppendFile = filepath.Join(os.TempDir(), "TestCacheAppendOnly.aof")
+	options.AppendOnly = true
+	options.AppendFsync = FsyncAlways
+	os.Remove(options.DumpFile)
+	os.Remove(options.AppendFile)
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		cache.Set("key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i)))
+	}
+
+	for i := 0; i < 50; i++ {
+		cache.Delete("key" + strconv.Itoa(i))
+	}
+	cache.log.close()
+
+	// Simulate a crash without any dumps.
+	cache, err = NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cache.Status().Count != 50 {
+		t.Fatalf("Replay append log failed! %d entries in cache!", cache.Status().Count)
+	}
+
+	if err = cache.dump(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cache.log.currentSize() != 0 {
+		t.Fatalf("Append log should be empty after dumping, but its size is %d!", cache.log.currentSize())
+	}
+
+	cache.Set("key0", []byte("value0"))
+	cache.Delete("key99")
+	cache.log.close()
+
+	// Simulate a crash after dumping.
+	cache, err = NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.log.close()
+
+	if cache.Status().Count != 50 {
+		t.Fatalf("Recover from dump file and append log failed! %d entries in cache!", cache.Status().Count)
+	}
+
+	if value, ok, _ := cache.Get("key0"); !ok || string(value) != "value0" {
+		t.Fatalf("Key key0 should be value0, but they are %v and %s in cache!", ok, string(value))
+	}
+
+	if _, ok, _ := cache.Get("key99"); ok {
+		t.Fatal("Key key99 should be deleted!")
+	}
+}
+
+// go test -cover -run=^TestCacheAppendOnlyEvictions$
+func TestCacheAppendOnlyEvictions(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpFile = filepath.Join(os.TempDir(), "TestCacheAppendOnlyEvictions.dump")
+	options.AppendFile = filepath.Join(os.TempDir(), "TestCacheAppendOnlyEvictions.aof")
+	options.AppendOnly = true
+	options.MaxEntrySize = 1
+	options.SegmentSize = 1
+	os.Remove(options.DumpFile)
+	os.Remove(options.AppendFile)
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 600*1024)
+	cache.Set("old", data)
+	cache.Set("new", data)
+	cache.log.close()
+
+	// Evicted entries shouldn't come back after replaying.
+	cache, err = NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.log.close()
+
+	if _, ok, _ := cache.Get("old"); ok {
+		t.Fatal("Key old is evicted, but it comes back after replaying!")
+	}
+
+	if status := cache.Status(); status.Count != 1 || status.Evictions != 0 {
+		t.Fatalf("Only key new should be replayed, but status is %+v!", status)
+	}
+}
+
+// go test -cover -run=^TestAppendLogUnknownOp$
+func TestAppendLogUnknownOp(t *testing.T) {
+
+	appendFile := filepath.Join(os.TempDir(), "TestAppendLogUnknownOp.aof")
+	os.Remove(appendFile)
+
+	log, err := openAppendLog(appendFile, FsyncAlways, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log.appendSet("key", newValue([]byte("value"), SetOptions{Ttl: NeverDie}, 0))
+	log.append(&logRecord{op: 99, key: "key"})
+	log.close()
+
+	if _, err = decodeRecord((&logRecord{op: 99, seq: 1, key: "key"}).encode()[recordHeaderSize:]); err != corruptedRecordErr {
+		t.Fatalf("Decode a record with an unknown op should return corruptedRecordErr but got %v!", err)
+	}
+
+	var records []*logRecord
+	_, _, err = readRecords(appendFile, func(record *logRecord) {
+		records = append(records, record)
+	})
+
+	if !errors.Is(err, corruptedRecordErr) || len(records) != 1 {
+		t.Fatalf("Read a record with an unknown op should fail after 1 record, but got %d records and %v!", len(records), err)
+	}
+}
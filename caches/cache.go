@@ -9,11 +9,46 @@
 package caches
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+var (
+	// alreadyDumpingErr means the cache is dumping by another one.
+	alreadyDumpingErr = errors.New("the cache is already dumping")
+
+	// cacheClosedErr means the cache is closed.
+	cacheClosedErr = errors.New("the cache is closed")
+)
+
+// IsClosed returns if err means the cache is closed.
+func IsClosed(err error) bool {
+	return errors.Is(err, cacheClosedErr)
+}
+
+// IsEntrySizeExceeded returns if err means the entry is too large to store.
+func IsEntrySizeExceeded(err error) bool {
+	return errors.Is(err, entrySizeExceededErr)
+}
+
+// IsInvalidArgument returns if err is caused by arguments of an operation, so retrying it won't help.
+// For example, the value has a wrong type or isn't an integer, or the expiration mode is unknown.
+func IsInvalidArgument(err error) bool {
+	for _, argumentErr := range []error{wrongTypeErr, notAnIntegerErr, integerOverflowErr, unknownExpirationModeErr, invalidCursorErr, path.ErrBadPattern} {
+		if errors.Is(err, argumentErr) {
+			return true
+		}
+	}
+	return false
+}
+
 // Cache is a struct with caching functions.
 type Cache struct {
 
@@ -30,34 +65,153 @@ type Cache struct {
 	// dumping means if cache is in dumping status.
 	// 1 is dumping.
 	dumping int32
+
+	// dumpedSegments is the number of segments dumped in current dump.
+	dumpedSegments int32
+
+	// lastDumpDuration is the duration of last dump.
+	// The unit is Millisecond.
+	lastDumpDuration int64
+
+	// log records all write operations, which is nil if append log is disabled.
+	log *appendLog
+
+	// appendRewriteSize is the size of log which triggers a rewrite.
+	appendRewriteSize int64
+
+	// rewriting means if log is in rewriting status.
+	// 1 is rewriting.
+	rewriting int32
+
+	// dirty is the number of writes since last save.
+	dirty int64
+
+	// lastSave is the time of last successful dump.
+	// The unit is second.
+	lastSave int64
+
+	// closed means if cache is closed.
+	closed bool
+
+	// closeLock guards closed, so operations won't run during closing.
+	closeLock *sync.RWMutex
+
+	// closing is closed when cache starts closing, and background tasks will stop.
+	closing chan struct{}
+
+	// tasks is the group of background tasks.
+	tasks *sync.WaitGroup
 }
 
 // NewCache returns a new Cache holder with default options.
+// It recovers from the default dump file, and an empty cache will be returned if failed.
+// The error of recovering is logged, so use NewCacheWith to fail instead of dropping a broken dump file.
 func NewCache() *Cache {
-	return NewCacheWith(DefaultOptions())
+	cache, err := NewCacheWith(DefaultOptions())
+	if err != nil {
+		log.Printf("Recover from dump file %s failed and an empty cache is used: %v\n", DefaultOptions().DumpFile, err)
+		return newCache(DefaultOptions())
+	}
+	return cache
 }
 
-// NewCacheWith returns a new Cache holder with given options.
-func NewCacheWith(options Options) *Cache {
-	if cache, ok := recoverFromDumpFile(options.DumpFile); ok {
-		return cache
+// NewCacheWith returns a new Cache holder with given options and an error if failed.
+// It recovers from the dump file and replays the append log if it's enabled.
+// Unlike older versions, it returns an error instead of an empty cache if recovering failed,
+// so entries in a broken dump file or append log won't be dropped silently.
+// The given options are always used, and entries in the dump file will be rehashed if SegmentSize changed.
+// An error will be returned if the dump file exists but is corrupted.
+func NewCacheWith(options Options) (*Cache, error) {
+	cache, seq, err := recoverFromDumpFile(options)
+	if err != nil {
+		return nil, err
 	}
+
+	if !options.AppendOnly {
+		return cache, nil
+	}
+	return cache, cache.openAppendLog(&options, seq)
+}
+
+// newCache returns a new empty Cache holder with given options.
+// The system clock will be used if the clock in options is nil.
+func newCache(options Options) *Cache {
+	if options.Clock == nil {
+		options.Clock = SystemClock()
+	}
+
 	return &Cache{
-		segmentSize: options.SegmentSize,
-		segments:    newSegments(&options),
-		options:     &options,
-		dumping:     0,
+		segmentSize:    options.SegmentSize,
+		segments:       newSegments(&options),
+		options:        &options,
+		dumping:        0,
+		dumpedSegments: 0,
+		lastSave:       options.Clock.Now().Unix(),
+		closed:         false,
+		closeLock:      &sync.RWMutex{},
+		closing:        make(chan struct{}),
+		tasks:          &sync.WaitGroup{},
 	}
 }
 
-// recoverFromDumpFile recovers the cache from a dump file.
-// Return a false if failed.
-func recoverFromDumpFile(dumpFile string) (*Cache, bool) {
-	cache, err := newEmptyDump().from(dumpFile)
+// recoverFromDumpFile recovers the cache from the latest dump file in options.
+// Returns the cache, the seq of append log in dump file and an error if failed.
+// A new cache will be returned if the dump file doesn't exist.
+func recoverFromDumpFile(options Options) (*Cache, uint64, error) {
+	dumpFile, err := latestDumpFile(&options)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	d := newEmptyDump()
+	cache, err := d.from(dumpFile, options)
+	if os.IsNotExist(err) {
+		return newCache(options), 0, nil
+	}
+
 	if err != nil {
-		return nil, false
+		return nil, 0, fmt.Errorf("recover from dump file %s failed: %w", dumpFile, err)
 	}
-	return cache, true
+	return cache, d.Seq, nil
+}
+
+// openAppendLog replays the records after seq in append log and opens it for appending.
+// Returns an error if failed.
+func (c *Cache) openAppendLog(options *Options, seq uint64) error {
+	size, maxSeq, err := readRecords(options.AppendFile, func(record *logRecord) {
+		if record.seq > seq {
+			c.segmentOf(record.key).replay(record)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if maxSeq < seq {
+		maxSeq = seq
+	}
+	return c.startAppendLog(options, size, maxSeq)
+}
+
+// startAppendLog opens the append log with size and seq and starts appending.
+// Records after size in append log will be dropped.
+func (c *Cache) startAppendLog(options *Options, size int64, seq uint64) (err error) {
+	c.log, err = openAppendLog(options.AppendFile, options.AppendFsync, size, seq)
+	if err != nil {
+		return err
+	}
+
+	c.appendRewriteSize = int64(options.AppendRewriteSize) * 1024 * 1024
+	for _, segment := range c.segments {
+		segment.log = c.log
+	}
+
+	if options.AppendFsync == FsyncEverySecond {
+		c.runTask(context.Background(), time.Second, func() {
+			c.log.sync()
+		})
+	}
+	return nil
 }
 
 // newSegments returns a slice of initialized segments.
@@ -85,27 +239,363 @@ func (c *Cache) segmentOf(key string) *segment {
 }
 
 // Get returns the value of specified key.
-func (c *Cache) Get(key string) ([]byte, bool) {
-	c.waitForDumping()
+// Returns false if the key doesn't exist, cacheClosedErr if cache is closed and an error if the key isn't a string.
+func (c *Cache) Get(key string) ([]byte, bool, error) {
+	c.closeLock.RLock()
+	defer c.closeLock.RUnlock()
+	if c.closed {
+		return nil, false, cacheClosedErr
+	}
 	return c.segmentOf(key).get(key)
 }
 
+// Peek returns the value of specified key without visiting it.
+// Unlike Get, a sliding entry won't live longer and the eviction policy won't know this reading.
+// Returns false if the key doesn't exist, cacheClosedErr if cache is closed and an error if the key isn't a string.
+func (c *Cache) Peek(key string) ([]byte, bool, error) {
+	c.closeLock.RLock()
+	defer c.closeLock.RUnlock()
+	if c.closed {
+		return nil, false, cacheClosedErr
+	}
+	return c.segmentOf(key).peek(key)
+}
+
+// GetWithVersion returns the value of specified key and its version.
+// The version increases on each write of key, so it can be used in CompareAndSet.
+// Returns false if the key doesn't exist, cacheClosedErr if cache is closed and an error if the key isn't a string.
+func (c *Cache) GetWithVersion(key string) ([]byte, uint64, bool, error) {
+	c.closeLock.RLock()
+	defer c.closeLock.RUnlock()
+	if c.closed {
+		return nil, 0, false, cacheClosedErr
+	}
+	return c.segmentOf(key).getWithVersion(key)
+}
+
+// write runs op on the segment of key and returns its error.
+// The cache is marked dirty if op changed something, and cacheClosedErr will be returned if cache is closed.
+func (c *Cache) write(key string, op func(s *segment) (bool, error)) error {
+	c.closeLock.RLock()
+	defer c.closeLock.RUnlock()
+	if c.closed {
+		return cacheClosedErr
+	}
+
+	changed, err := op(c.segmentOf(key))
+	if changed {
+		atomic.AddInt64(&c.dirty, 1)
+	}
+
+	c.rewriteIfNeeded()
+	return err
+}
+
+// writeAll runs op on all segments one by one and returns the total count of changes.
+// The cache is marked dirty by the count, and cacheClosedErr will be returned if cache is closed.
+func (c *Cache) writeAll(op func(s *segment) (int, error)) (int, error) {
+	c.closeLock.RLock()
+	defer c.closeLock.RUnlock()
+	if c.closed {
+		return 0, cacheClosedErr
+	}
+
+	total := 0
+	var err error
+	for _, segment := range c.segments {
+		var count int
+		count, err = op(segment)
+		total += count
+		if err != nil {
+			break
+		}
+	}
+
+	atomic.AddInt64(&c.dirty, int64(total))
+	c.rewriteIfNeeded()
+	return total, err
+}
+
+// segmentsOf groups keys by their segments.
+func (c *Cache) segmentsOf(keys []string) map[*segment][]string {
+	groups := make(map[*segment][]string, len(c.segments))
+	for _, key := range keys {
+		segment := c.segmentOf(key)
+		groups[segment] = append(groups[segment], key)
+	}
+	return groups
+}
+
+// writeMany runs op on the segments of keys one by one and returns the total count of changes.
+// Keys are grouped by segments, so each segment is locked only once.
+// The cache is marked dirty by the count, and cacheClosedErr will be returned if cache is closed.
+func (c *Cache) writeMany(keys []string, op func(s *segment, keys []string) (int, error)) (int, error) {
+	groups := c.segmentsOf(keys)
+	return c.writeAll(func(s *segment) (int, error) {
+		if keys, ok := groups[s]; ok {
+			return op(s, keys)
+		}
+		return 0, nil
+	})
+}
+
 // Set sets an entry of specified key and value.
 func (c *Cache) Set(key string, value []byte) error {
 	return c.SetWithTTL(key, value, NeverDie)
 }
 
-// SetWithTTL sets an entry of specified key and value which has ttl.
+// SetWithTTL sets an entry of specified key and value which has a sliding ttl.
+// The unit of ttl is second.
 func (c *Cache) SetWithTTL(key string, value []byte, ttl int64) error {
-	c.waitForDumping()
-	return c.segmentOf(key).set(key, value, ttl)
+	return c.SetWithOptions(key, value, SetOptions{Ttl: time.Duration(ttl) * time.Second, Expiration: Sliding})
+}
+
+// SetWithOptions sets an entry of specified key and value with options.
+// Returns an error if options are invalid.
+func (c *Cache) SetWithOptions(key string, value []byte, options SetOptions) error {
+	if err := options.check(); err != nil {
+		return err
+	}
+
+	return c.write(key, func(s *segment) (bool, error) {
+		err := s.set(key, value, options)
+		return err == nil, err
+	})
+}
+
+// CompareAndSet sets an entry of specified key and value which has a sliding ttl only if the version of key is expectedVersion.
+// The unit of ttl is second, and the version of a key not existing is 0.
+// Returns the new version and true if it's set, otherwise the current version and false.
+func (c *Cache) CompareAndSet(key string, value []byte, expectedVersion uint64, ttl int64) (uint64, bool, error) {
+	return c.CompareAndSetWithOptions(key, value, expectedVersion, SetOptions{Ttl: time.Duration(ttl) * time.Second, Expiration: Sliding})
+}
+
+// CompareAndSetWithOptions sets an entry of specified key and value with options only if the version of key is expectedVersion.
+// Returns the new version and true if it's set, otherwise the current version and false.
+func (c *Cache) CompareAndSetWithOptions(key string, value []byte, expectedVersion uint64, options SetOptions) (uint64, bool, error) {
+	if err := options.check(); err != nil {
+		return 0, false, err
+	}
+
+	version, ok := uint64(0), false
+	err := c.write(key, func(s *segment) (bool, error) {
+		var err error
+		version, ok, err = s.compareAndSet(key, value, expectedVersion, options)
+		return ok, err
+	})
+	return version, ok, err
+}
+
+// SetNX sets an entry of specified key and value with options only if the key doesn't exist.
+// Returns false if the key exists.
+func (c *Cache) SetNX(key string, value []byte, options SetOptions) (bool, error) {
+	return c.setIf(key, value, options, func(existed bool) bool {
+		return !existed
+	})
+}
+
+// SetXX sets an entry of specified key and value with options only if the key exists.
+// Returns false if the key doesn't exist, and an error if the key isn't a string.
+func (c *Cache) SetXX(key string, value []byte, options SetOptions) (bool, error) {
+	return c.setIf(key, value, options, func(existed bool) bool {
+		return existed
+	})
+}
+
+// setIf sets an entry of specified key and value with options only if should returns true.
+// The argument of should is if the key exists.
+func (c *Cache) setIf(key string, value []byte, options SetOptions, should func(existed bool) bool) (bool, error) {
+	if err := options.check(); err != nil {
+		return false, err
+	}
+
+	ok := false
+	err := c.write(key, func(s *segment) (bool, error) {
+		var err error
+		_, _, ok, err = s.setIf(key, value, options, should)
+		return ok, err
+	})
+	return ok, err
+}
+
+// GetSet sets an entry of specified key and value with options and returns the old value.
+// Returns false if the key didn't exist, and an error if the key isn't a string.
+func (c *Cache) GetSet(key string, value []byte, options SetOptions) ([]byte, bool, error) {
+	if err := options.check(); err != nil {
+		return nil, false, err
+	}
+
+	var oldValue []byte
+	existed := false
+	err := c.write(key, func(s *segment) (bool, error) {
+		var ok bool
+		var err error
+		oldValue, existed, ok, err = s.setIf(key, value, options, func(bool) bool {
+			return true
+		})
+		return ok, err
+	})
+	return oldValue, existed, err
+}
+
+// GetDel deletes the specified key and returns its value.
+// Returns false if the key doesn't exist.
+func (c *Cache) GetDel(key string) ([]byte, bool, error) {
+	var oldValue []byte
+	ok := false
+	err := c.write(key, func(s *segment) (bool, error) {
+		var err error
+		oldValue, ok, err = s.getDelete(key)
+		return ok, err
+	})
+	return oldValue, ok, err
+}
+
+// MGet returns the values of specified keys, and keys not existing are missing in the result.
+// Keys are grouped by segments, so each segment is locked only once.
+// Returns cacheClosedErr if cache is closed, and an error if any of keys isn't a string.
+func (c *Cache) MGet(keys []string) (map[string][]byte, error) {
+	c.closeLock.RLock()
+	defer c.closeLock.RUnlock()
+	if c.closed {
+		return nil, cacheClosedErr
+	}
+
+	values := make(map[string][]byte, len(keys))
+	for segment, keys := range c.segmentsOf(keys) {
+		if err := segment.getMany(keys, values); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// MSet sets all entries of keys and values in entries with options.
+// Keys are grouped by segments, so each segment is locked only once.
+// Returns an error if options are invalid or any entry failed, and entries before it are set.
+func (c *Cache) MSet(entries map[string][]byte, options SetOptions) error {
+	if err := options.check(); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+
+	_, err := c.writeMany(keys, func(s *segment, keys []string) (int, error) {
+		return s.setMany(keys, entries, options)
+	})
+	return err
+}
+
+// MDelete deletes the specified keys and returns the count of keys deleted.
+// Keys are grouped by segments, so each segment is locked only once.
+func (c *Cache) MDelete(keys []string) (int, error) {
+	return c.writeMany(keys, func(s *segment, keys []string) (int, error) {
+		return s.deleteMany(keys)
+	})
 }
 
 // Delete deletes the specified key and value.
 func (c *Cache) Delete(key string) error {
-	c.waitForDumping()
-	c.segmentOf(key).delete(key)
-	return nil
+	return c.write(key, func(s *segment) (bool, error) {
+		return s.delete(key)
+	})
+}
+
+// Now returns the current time of the clock used by cache.
+func (c *Cache) Now() time.Time {
+	return c.options.Clock.Now()
+}
+
+// ExpireAt makes the entry of specified key die at the given time, and it won't live longer after visiting.
+// The entry will be deleted at once if the time has passed.
+// Returns false if the key doesn't exist.
+func (c *Cache) ExpireAt(key string, at time.Time) (bool, error) {
+	ok := false
+	err := c.write(key, func(s *segment) (bool, error) {
+		var err error
+		ok, err = s.expireAt(key, unixMillis(at))
+		return ok, err
+	})
+	return ok, err
+}
+
+// IncrBy adds delta to the value of specified key atomically and returns the result.
+// The value is treated as a decimal int64, and a key not existing is treated as 0 which never dies.
+// The ttl of an existing value is kept.
+func (c *Cache) IncrBy(key string, delta int64) (int64, error) {
+	result := int64(0)
+	err := c.write(key, func(s *segment) (bool, error) {
+		var err error
+		result, err = s.incrBy(key, delta)
+		return err == nil, err
+	})
+	return result, err
+}
+
+// Incr adds 1 to the value of specified key atomically and returns the result.
+func (c *Cache) Incr(key string) (int64, error) {
+	return c.IncrBy(key, 1)
+}
+
+// Decr subtracts 1 from the value of specified key atomically and returns the result.
+func (c *Cache) Decr(key string) (int64, error) {
+	return c.IncrBy(key, -1)
+}
+
+// TTL returns the remaining life of specified key without visiting it.
+// Returns NeverDie if it never dies, false if the key doesn't exist, and cacheClosedErr if cache is closed.
+func (c *Cache) TTL(key string) (time.Duration, bool, error) {
+	c.closeLock.RLock()
+	defer c.closeLock.RUnlock()
+	if c.closed {
+		return 0, false, cacheClosedErr
+	}
+
+	ttl, ok := c.segmentOf(key).ttl(key)
+	return time.Duration(ttl) * time.Millisecond, ok, nil
+}
+
+// Expire makes the entry of specified key die after ttl from now, and its expiration mode is kept.
+// A sliding entry will live ttl longer after each visiting, and the entry will be deleted at once if ttl isn't positive.
+// Returns false if the key doesn't exist.
+func (c *Cache) Expire(key string, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return c.ExpireAt(key, c.options.Clock.Now())
+	}
+
+	ok := false
+	err := c.write(key, func(s *segment) (bool, error) {
+		var err error
+		ok, err = s.setTtl(key, millisOf(ttl))
+		return ok, err
+	})
+	return ok, err
+}
+
+// Persist makes the entry of specified key never die.
+// Returns false if the key doesn't exist.
+func (c *Cache) Persist(key string) (bool, error) {
+	ok := false
+	err := c.write(key, func(s *segment) (bool, error) {
+		var err error
+		ok, err = s.persist(key)
+		return ok, err
+	})
+	return ok, err
+}
+
+// Touch visits the entry of specified key in any type without reading it, so a sliding entry lives longer.
+// Returns false if the key doesn't exist, and cacheClosedErr if cache is closed.
+func (c *Cache) Touch(key string) (bool, error) {
+	c.closeLock.RLock()
+	defer c.closeLock.RUnlock()
+	if c.closed {
+		return false, cacheClosedErr
+	}
+	return c.segmentOf(key).touch(key), nil
 }
 
 // Status returns the status of cache.
@@ -116,13 +606,20 @@ func (c *Cache) Status() Status {
 		result.Count += status.Count
 		result.KeySize += status.KeySize
 		result.ValueSize += status.ValueSize
+		result.Evictions += status.Evictions
+		result.Expired += status.Expired
 	}
+
+	result.Dumping = atomic.LoadInt32(&c.dumping) != 0
+	result.DumpProgress = float64(atomic.LoadInt32(&c.dumpedSegments)) / float64(len(c.segments))
+	result.LastDumpDuration = atomic.LoadInt64(&c.lastDumpDuration)
+	result.LastSave = atomic.LoadInt64(&c.lastSave)
+	result.DirtyCount = atomic.LoadInt64(&c.dirty)
 	return *result
 }
 
 // gc will clean up the dead entries in cache.
 func (c *Cache) gc() {
-	c.waitForDumping()
 	wg := &sync.WaitGroup{}
 	for _, seg := range c.segments {
 		wg.Add(1)
@@ -134,42 +631,221 @@ func (c *Cache) gc() {
 	wg.Wait()
 }
 
-// AutoGc starts a goroutine and runs the gc task at fixed duration.
-func (c *Cache) AutoGc() {
+// runTask starts a goroutine and runs task at fixed duration.
+// The goroutine stops when ctx is done or cache is closed.
+func (c *Cache) runTask(ctx context.Context, duration time.Duration, task func()) {
+	c.tasks.Add(1)
 	go func() {
-		ticker := time.NewTicker(time.Duration(c.options.GcDuration) * time.Minute)
+		defer c.tasks.Done()
+		ticker := time.NewTicker(duration)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
-				c.gc()
+				task()
+			case <-ctx.Done():
+				return
+			case <-c.closing:
+				return
 			}
 		}
 	}()
 }
 
+// Start starts all background tasks including gc, active expiration and dump.
+// These tasks stop when ctx is done or cache is closed.
+func (c *Cache) Start(ctx context.Context) {
+	c.startGc(ctx)
+	c.startExpire(ctx)
+	c.startDump(ctx)
+}
+
+// Close stops all background tasks and closes the append log.
+// A final dump will be done if DumpOnClose is true.
+// Operations after closing return cacheClosedErr.
+// Returns ctx.Err() if ctx is done before background tasks stop, and the final dump will be skipped then,
+// but the append log is always closed, and tasks still running can't write or compact it any more.
+// Closing a closed cache does nothing and returns nil.
+func (c *Cache) Close(ctx context.Context) (err error) {
+	c.closeLock.Lock()
+	if c.closed {
+		c.closeLock.Unlock()
+		return nil
+	}
+
+	c.closed = true
+	close(c.closing)
+	c.closeLock.Unlock()
+
+	defer func() {
+		if c.log == nil {
+			return
+		}
+
+		if closeErr := c.log.close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	stopped := make(chan struct{})
+	go func() {
+		c.tasks.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if c.options.DumpOnClose {
+		err = c.dump()
+	}
+	return err
+}
+
+// AutoGc starts a goroutine and runs the gc task at fixed duration.
+func (c *Cache) AutoGc() {
+	c.startGc(context.Background())
+}
+
+// startGc starts the gc task which stops when ctx is done.
+func (c *Cache) startGc(ctx context.Context) {
+	c.runTask(ctx, time.Duration(c.options.GcDuration)*time.Minute, c.gc)
+}
+
+// expire removes dead entries in all segments actively and returns the count of removed entries.
+// At most MaxExpireCount entries are checked in one segment, so segments won't be locked for long.
+func (c *Cache) expire() int {
+	count := 0
+	for _, segment := range c.segments {
+		count += segment.expire(c.options.MaxExpireCount)
+	}
+	return count
+}
+
+// AutoExpire starts a goroutine and runs the active expiration task at fixed duration.
+// Nothing happens if ExpireDuration isn't positive.
+func (c *Cache) AutoExpire() {
+	c.startExpire(context.Background())
+}
+
+// startExpire starts the active expiration task which stops when ctx is done.
+func (c *Cache) startExpire(ctx context.Context) {
+	if c.options.ExpireDuration <= 0 {
+		return
+	}
+
+	c.runTask(ctx, time.Duration(c.options.ExpireDuration)*time.Millisecond, func() {
+		c.expire()
+	})
+}
+
 // dump dumps c to dumpFile and returns an error if failed.
+// Segments are frozen one at a time, so the cache keeps serving during dumping.
+// Records included in the dump file will be dropped from append log.
+// If DumpDir is set, a new snapshot will be created and old ones will be pruned.
+// Writes during dumping are still dirty after dumping because they may not be included.
 func (c *Cache) dump() error {
-	atomic.StoreInt32(&c.dumping, 1)
-	defer atomic.StoreInt32(&c.dumping, 0)
-	return newDump(c).to(c.options.DumpFile)
+	if !atomic.CompareAndSwapInt32(&c.dumping, 0, 1) {
+		return alreadyDumpingErr
+	}
+
+	beginTime := c.options.Clock.Now()
+	dirty := atomic.LoadInt64(&c.dirty)
+	atomic.StoreInt32(&c.dumpedSegments, 0)
+	defer func() {
+		atomic.StoreInt64(&c.lastDumpDuration, c.options.Clock.Now().Sub(beginTime).Milliseconds())
+		atomic.StoreInt32(&c.dumping, 0)
+	}()
+
+	dumpFile, err := nextDumpFile(c.options, beginTime)
+	if err != nil {
+		return err
+	}
+
+	offset, seq := int64(0), uint64(0)
+	if c.log != nil {
+		offset, seq = c.log.mark()
+	}
+
+	if err = newDump(c, seq).to(dumpFile); err != nil {
+		return err
+	}
+
+	atomic.AddInt64(&c.dirty, -dirty)
+	atomic.StoreInt64(&c.lastSave, beginTime.Unix())
+
+	if c.options.DumpDir != "" {
+		if err = pruneSnapshots(c.options, beginTime); err != nil {
+			log.Printf("Prune snapshots in %s failed: %v\n", c.options.DumpDir, err)
+		}
+	}
+
+	if c.log == nil {
+		return nil
+	}
+	return c.log.compact(offset)
+}
+
+// needDump returns if a dump is needed at now.
+// It's needed if anything is dirty and DumpDuration has passed or any of SaveRules matches since last save.
+func (c *Cache) needDump(now time.Time) bool {
+	dirty := atomic.LoadInt64(&c.dirty)
+	if dirty <= 0 {
+		return false
+	}
+
+	elapsed := now.Unix() - atomic.LoadInt64(&c.lastSave)
+	if c.options.DumpDuration > 0 && elapsed >= int64(c.options.DumpDuration)*60 {
+		return true
+	}
+
+	for _, rule := range c.options.SaveRules {
+		if dirty >= int64(rule.Changes) && elapsed >= int64(rule.Seconds) {
+			return true
+		}
+	}
+	return false
 }
 
-// AutoDump starts a goroutine and runs the dump task at fixed duration.
+// AutoDump starts a goroutine and checks if a dump is needed every second.
+// The dump will be skipped if nothing is dirty.
 func (c *Cache) AutoDump() {
-	go func() {
-		ticker := time.NewTicker(time.Duration(c.options.DumpDuration) * time.Minute)
-		for {
-			select {
-			case <-ticker.C:
-				c.dump()
-			}
+	c.startDump(context.Background())
+}
+
+// startDump starts the dump task which stops when ctx is done.
+func (c *Cache) startDump(ctx context.Context) {
+	c.runTask(ctx, time.Second, func() {
+		if !c.needDump(c.options.Clock.Now()) {
+			return
 		}
-	}()
+
+		if err := c.dump(); err != nil && err != alreadyDumpingErr {
+			log.Printf("Dump failed: %v\n", err)
+		}
+	})
 }
 
-// waitForDumping will wait for dumping.
-func (c *Cache) waitForDumping() {
-	for atomic.LoadInt32(&c.dumping) != 0 {
-		time.Sleep(time.Duration(c.options.CasSleepTime) * time.Microsecond)
+// rewriteIfNeeded starts a goroutine to rewrite append log if its size exceeds.
+// It should be called with closeLock held, so closing will wait for the rewrite.
+func (c *Cache) rewriteIfNeeded() {
+	if c.log == nil || c.log.currentSize() < c.appendRewriteSize {
+		return
 	}
+
+	if !atomic.CompareAndSwapInt32(&c.rewriting, 0, 1) {
+		return
+	}
+
+	c.tasks.Add(1)
+	go func() {
+		defer c.tasks.Done()
+		defer atomic.StoreInt32(&c.rewriting, 0)
+		if err := c.dump(); err != nil && err != alreadyDumpingErr {
+			log.Printf("Rewrite append log %s failed: %v\n", c.log.path, err)
+		}
+	}()
 }
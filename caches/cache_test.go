@@ -9,9 +9,18 @@
 package caches
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -25,7 +34,7 @@ func TestCache(t *testing.T) {
 	}
 
 	k := "key"
-	if value, ok := cache.Get(k); ok {
+	if value, ok, _ := cache.Get(k); ok {
 		t.Fatalf("cache.Get(\"key\") = %s, but this should not happen...", string(value))
 	}
 
@@ -35,27 +44,34 @@ func TestCache(t *testing.T) {
 		t.Fatal("cache.Count() != 1...")
 	}
 
-	value, ok := cache.Get(k)
+	value, ok, _ := cache.Get(k)
 	if !ok || string(value) != v {
 		t.Fatalf("ok = %v, value = %s, but ok should be true and value should be %s...", ok, string(value), v)
 	}
 }
 
+// newTestCache returns a cache in memory using a fake clock and the clock.
+func newTestCache(options Options) (*Cache, *FakeClock) {
+	clock := NewFakeClock(time.Now())
+	options.Clock = clock
+	return newCache(options), clock
+}
+
 // go test -cover -run=^TestCacheTTL$
 func TestCacheTTL(t *testing.T) {
 
-	cache := NewCache()
+	cache, clock := newTestCache(DefaultOptions())
 
 	k := "key"
 	v := "value"
 	cache.SetWithTTL(k, []byte(v), 2)
 
-	if value, ok := cache.Get(k); !ok || string(value) != v {
+	if value, ok, _ := cache.Get(k); !ok || string(value) != v {
 		t.Fatalf("ok = %v, value = %s, but ok should be true and value should be %s...", ok, string(value), v)
 	}
 
-	time.Sleep(3 * time.Second)
-	if value, ok := cache.Get(k); ok {
+	clock.Add(3 * time.Second)
+	if value, ok, _ := cache.Get(k); ok {
 		t.Fatalf("cache.Get(\"key\") = %s, but this should not happen...", string(value))
 	}
 }
@@ -87,14 +103,14 @@ func TestCacheStatus(t *testing.T) {
 // go test -cover -run="^TestCacheGc$"
 func TestCacheGc(t *testing.T) {
 
-	cache := NewCache()
+	cache, clock := newTestCache(DefaultOptions())
 	cache.SetWithTTL("key1", []byte{}, 1)
 	cache.SetWithTTL("key2", []byte{}, 1)
 	if cache.Status().Count != 2 {
 		t.Fatal("The count of cache is wrong!")
 	}
 
-	time.Sleep(2 * time.Second)
+	clock.Add(2 * time.Second)
 	if cache.Status().Count != 2 {
 		t.Fatal("The count of cache is wrong before gc!")
 	}
@@ -106,8 +122,8 @@ func TestCacheGc(t *testing.T) {
 
 	options := DefaultOptions()
 	options.MaxGcCount = 4
+	cache, clock = newTestCache(options)
 
-	cache = NewCacheWith(options)
 	for i := 0; i < 10000; i++ {
 		cache.SetWithTTL("key"+strconv.Itoa(i), []byte{}, 2)
 	}
@@ -116,7 +132,7 @@ func TestCacheGc(t *testing.T) {
 		t.Fatal("The count of cache is wrong!")
 	}
 
-	time.Sleep(3 * time.Second)
+	clock.Add(3 * time.Second)
 	if cache.Status().Count != 10000 {
 		t.Fatal("The count of cache is wrong before gc!")
 	}
@@ -130,9 +146,16 @@ func TestCacheGc(t *testing.T) {
 // go test -cover -run=^TestCacheDump$
 func TestCacheDump(t *testing.T) {
 
+	clock := NewFakeClock(time.Now())
 	options := DefaultOptions()
 	options.DumpFile = filepath.Join(os.TempDir(), "kafo.dump")
-	cache := NewCacheWith(options)
+	options.Clock = clock
+	os.Remove(options.DumpFile)
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	for i := 0; i < 100; i++ {
 		cache.Set("key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i)))
@@ -142,7 +165,7 @@ func TestCacheDump(t *testing.T) {
 		t.Fatalf("Set 100 entries failed! Only %d entries in cache!", cache.Status().Count)
 	}
 
-	err := cache.dump()
+	err = cache.dump()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -157,7 +180,11 @@ func TestCacheDump(t *testing.T) {
 		t.Fatalf("Still %d entries in cache!", cache.Status().Count)
 	}
 
-	cache = NewCacheWith(options)
+	cache, err = NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	if cache.Status().Count != 100 {
 		t.Fatalf("Recover 100 entries failed! Only %d entries in cache!", cache.Status().Count)
 	}
@@ -165,7 +192,7 @@ func TestCacheDump(t *testing.T) {
 	for i := 0; i < 100; i++ {
 		k := "key" + strconv.Itoa(i)
 		v := "value" + strconv.Itoa(i)
-		value, ok := cache.Get(k)
+		value, ok, _ := cache.Get(k)
 		if !ok || string(value) != v {
 			t.Fatalf("Key {%s} should be %s, but they are %v and %s in cache!", k, v, ok, string(value))
 		}
@@ -176,14 +203,785 @@ func TestCacheDump(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	value, ok := cache.Get("testKey")
+	value, ok, _ := cache.Get("testKey")
 	if !ok || string(value) != "testValue" {
 		t.Fatalf("Key testKey should be testValue, but they are %v and %s in cache!", ok, string(value))
 	}
 
-	time.Sleep(2 * time.Second)
-	_, ok = cache.Get("testKey")
+	clock.Add(2 * time.Second)
+	_, ok, _ = cache.Get("testKey")
 	if ok {
 		t.Fatal("Key testKey should be dead!")
 	}
 }
+
+// go test -cover -run=^TestCacheDumpWhileWriting$
+func TestCacheDumpWhileWriting(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpFile = filepath.Join(os.TempDir(), "TestCacheDumpWhileWriting.dump")
+	cache := newCache(options)
+	for i := 0; i < 10000; i++ {
+		cache.Set("key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i)))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10000; i++ {
+			key := "key" + strconv.Itoa(i)
+			cache.Set(key, []byte("new"+key))
+			cache.Get(key)
+		}
+	}()
+
+	if err := cache.dump(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	status := cache.Status()
+	if status.Dumping || status.DumpProgress != 1 || status.LastDumpDuration < 0 {
+		t.Fatalf("The dump status %+v is wrong!", status)
+	}
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cache.Status().Count != 10000 {
+		t.Fatalf("Recover 10000 entries failed! Only %d entries in cache!", cache.Status().Count)
+	}
+}
+
+// go test -cover -run=^TestCacheRecoverWithNewOptions$
+func TestCacheRecoverWithNewOptions(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpFile = filepath.Join(os.TempDir(), "TestCacheRecoverWithNewOptions.dump")
+	cache := newCache(options)
+	for i := 0; i < 1000; i++ {
+		cache.Set("key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i)))
+	}
+
+	if err := cache.dump(); err != nil {
+		t.Fatal(err)
+	}
+
+	options.SegmentSize = 16
+	options.GcDuration = 1
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cache.segmentSize != 16 || len(cache.segments) != 16 || cache.options.GcDuration != 1 {
+		t.Fatalf("Options of cache %+v should be the new one!", *cache.options)
+	}
+
+	if cache.Status().Count != 1000 {
+		t.Fatalf("Recover 1000 entries failed! Only %d entries in cache!", cache.Status().Count)
+	}
+
+	for i := 0; i < 1000; i++ {
+		k := "key" + strconv.Itoa(i)
+		v := "value" + strconv.Itoa(i)
+		value, ok, _ := cache.Get(k)
+		if !ok || string(value) != v {
+			t.Fatalf("Key {%s} should be %s, but they are %v and %s in cache!", k, v, ok, string(value))
+		}
+	}
+}
+
+// go test -cover -run=^TestNewCacheRecover$
+func TestNewCacheRecover(t *testing.T) {
+
+	// The default dump file is in the working directory, so run in a temporary one.
+	dir, err := ioutil.TempDir("", "TestNewCacheRecover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	options := DefaultOptions()
+	cache := newCache(options)
+	cache.Set("key", []byte("value"))
+	if err = cache.dump(); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, ok, _ := NewCache().Get("key"); !ok || string(value) != "value" {
+		t.Fatalf("NewCache should recover from the default dump file but got %v and %s!", ok, string(value))
+	}
+
+	if err = ioutil.WriteFile(options.DumpFile, []byte("corrupted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = NewCacheWith(options); err == nil {
+		t.Fatal("NewCacheWith should fail if the dump file is corrupted!")
+	}
+
+	output := &bytes.Buffer{}
+	log.SetOutput(output)
+	defer log.SetOutput(os.Stderr)
+
+	if count := NewCache().Status().Count; count != 0 {
+		t.Fatalf("NewCache should return an empty cache if the dump file is corrupted but got %d entries!", count)
+	}
+
+	if !strings.Contains(output.String(), options.DumpFile) {
+		t.Fatalf("NewCache should log the error of recovering but got %q!", output.String())
+	}
+}
+
+// go test -cover -run=^TestCacheSaveRules$
+func TestCacheSaveRules(t *testing.T) {
+
+	rules, err := ParseSaveRules("60:1000, 300:10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rules) != 2 || rules[0] != (SaveRule{Seconds: 60, Changes: 1000}) || rules[1] != (SaveRule{Seconds: 300, Changes: 10}) {
+		t.Fatalf("Parsed rules %+v are wrong!", rules)
+	}
+
+	for _, wrong := range []string{"60", "a:1", "60:0", "60:1,"} {
+		if _, err = ParseSaveRules(wrong); err == nil {
+			t.Fatalf("Parse wrong rules %q should fail!", wrong)
+		}
+	}
+
+	options := DefaultOptions()
+	options.DumpFile = filepath.Join(os.TempDir(), "TestCacheSaveRules.dump")
+	options.DumpDuration = 30
+	options.SaveRules = rules
+	cache := newCache(options)
+
+	now := time.Unix(atomic.LoadInt64(&cache.lastSave), 0)
+	if cache.needDump(now.Add(time.Hour)) {
+		t.Fatal("Nothing is dirty so no dumps are needed!")
+	}
+
+	for i := 0; i < 10; i++ {
+		cache.Set("key"+strconv.Itoa(i), []byte("value"))
+	}
+	cache.Delete("key0")
+	cache.Delete("key0")
+
+	if cache.Status().DirtyCount != 11 {
+		t.Fatalf("DirtyCount %d is wrong!", cache.Status().DirtyCount)
+	}
+
+	if cache.needDump(now.Add(time.Minute)) {
+		t.Fatal("No rules match in 1 minute!")
+	}
+
+	if !cache.needDump(now.Add(5 * time.Minute)) {
+		t.Fatal("Rule 300:10 should match in 5 minutes!")
+	}
+
+	if err = cache.dump(); err != nil {
+		t.Fatal(err)
+	}
+
+	status := cache.Status()
+	if status.DirtyCount != 0 || status.LastSave < now.Unix() {
+		t.Fatalf("DirtyCount %d or LastSave %d is wrong after dumping!", status.DirtyCount, status.LastSave)
+	}
+
+	cache.Set("key", []byte("value"))
+	now = time.Unix(status.LastSave, 0)
+	if cache.needDump(now.Add(10 * time.Minute)) {
+		t.Fatal("Only 1 write in 10 minutes and no rules match!")
+	}
+
+	if !cache.needDump(now.Add(30 * time.Minute)) {
+		t.Fatal("DumpDuration has passed and something is dirty!")
+	}
+}
+
+// go test -cover -run=^TestCacheExpireAt$
+func TestCacheExpireAt(t *testing.T) {
+
+	cache, clock := newTestCache(DefaultOptions())
+	cache.Set("key", []byte("value"))
+	cache.Set("passed", []byte("value"))
+
+	if ok, err := cache.ExpireAt("notExist", clock.Now().Add(time.Second)); ok || err != nil {
+		t.Fatalf("ExpireAt a key not existing should return false but got %v and %v!", ok, err)
+	}
+
+	if ok, err := cache.ExpireAt("passed", clock.Now().Add(-time.Second)); !ok || err != nil {
+		t.Fatalf("ExpireAt a passed time should return true but got %v and %v!", ok, err)
+	}
+
+	if _, ok, _ := cache.Get("passed"); ok || cache.Status().Count != 1 {
+		t.Fatal("Key passed should be deleted!")
+	}
+
+	if ok, err := cache.ExpireAt("key", clock.Now().Add(150*time.Millisecond)); !ok || err != nil {
+		t.Fatalf("ExpireAt key should return true but got %v and %v!", ok, err)
+	}
+
+	clock.Add(149 * time.Millisecond)
+	if _, ok, _ := cache.Get("key"); !ok {
+		t.Fatal("Key should be alive before its deadline!")
+	}
+
+	clock.Add(time.Millisecond)
+	if _, ok, _ := cache.Get("key"); ok {
+		t.Fatal("Key should be dead after its deadline even if it's visited!")
+	}
+}
+
+// go test -cover -run=^TestCacheDeleteDeadKeys$
+func TestCacheDeleteDeadKeys(t *testing.T) {
+
+	cache, clock := newTestCache(DefaultOptions())
+	options := SetOptions{Ttl: time.Second, Expiration: Absolute}
+	for _, key := range []string{"delete", "mDelete", "getDel", "expireAt"} {
+		cache.SetWithOptions(key, []byte("value"), options)
+	}
+
+	clock.Add(time.Second)
+	if ok, err := cache.ExpireAt("expireAt", clock.Now().Add(time.Second)); ok || err != nil {
+		t.Fatalf("ExpireAt a dead key should return false but got %v and %v!", ok, err)
+	}
+
+	if count, err := cache.MDelete([]string{"mDelete"}); count != 0 || err != nil {
+		t.Fatalf("MDelete dead keys should return 0 but got %d and %v!", count, err)
+	}
+
+	if _, ok, err := cache.GetDel("getDel"); ok || err != nil {
+		t.Fatalf("GetDel a dead key should return false but got %v and %v!", ok, err)
+	}
+
+	if ok, err := cache.segmentOf("delete").delete("delete"); ok || err != nil {
+		t.Fatalf("Delete a dead key should return false but got %v and %v!", ok, err)
+	}
+
+	if status := cache.Status(); status.Count != 0 || status.Expired != 4 || status.DirtyCount != 4 {
+		t.Fatalf("Dead keys should be counted as expired instead of deleted, but status is %+v!", status)
+	}
+}
+
+// go test -cover -run=^TestCacheClose$
+func TestCacheClose(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpFile = filepath.Join(os.TempDir(), "TestCacheClose.dump")
+	options.AppendFile = filepath.Join(os.TempDir(), "TestCacheClose.aof")
+	options.AppendOnly = true
+	options.DumpOnClose = true
+	os.Remove(options.DumpFile)
+	os.Remove(options.AppendFile)
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cache.Start(ctx)
+
+	for i := 0; i < 100; i++ {
+		cache.Set("key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i)))
+	}
+
+	if err = cache.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cache.Close(context.Background()); err != nil {
+		t.Fatalf("Close a closed cache should do nothing but got %v!", err)
+	}
+
+	if err = cache.Set("key", []byte("value")); err != cacheClosedErr {
+		t.Fatalf("Set after closing should return cacheClosedErr but got %v!", err)
+	}
+
+	if _, ok, err := cache.Get("key0"); ok || err != cacheClosedErr {
+		t.Fatalf("Get after closing should return cacheClosedErr but got %v and %v!", ok, err)
+	}
+
+	if _, ok, err := cache.TTL("key0"); ok || err != cacheClosedErr {
+		t.Fatalf("TTL after closing should return cacheClosedErr but got %v and %v!", ok, err)
+	}
+
+	if ok, err := cache.Touch("key0"); ok || err != cacheClosedErr {
+		t.Fatalf("Touch after closing should return cacheClosedErr but got %v and %v!", ok, err)
+	}
+
+	if fileInfo, err := os.Stat(options.AppendFile); err != nil || fileInfo.Size() != 0 {
+		t.Fatalf("Append file should be empty after the final dump! Error is %v.", err)
+	}
+
+	cache, err = NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cache.Status().Count != 100 {
+		t.Fatalf("Recover from the final dump failed! %d entries in cache!", cache.Status().Count)
+	}
+
+	// Background tasks should stop when ctx is done.
+	ctx, cancel = context.WithCancel(context.Background())
+	cache.Start(ctx)
+	cancel()
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = cache.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The append log should be closed even if background tasks don't stop in time.
+	cache, err = NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.tasks.Add(1)
+	defer cache.tasks.Done()
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err = cache.Close(ctx); err != context.Canceled {
+		t.Fatalf("Close with a canceled ctx should return context.Canceled but got %v!", err)
+	}
+
+	// Tasks still running after Close returns can't touch the closed log any more.
+	if err = cache.log.appendDelete("key"); err != appendLogClosedErr {
+		t.Fatalf("Append a closed log should return appendLogClosedErr but got %v!", err)
+	}
+
+	if err = cache.log.compact(0); err != appendLogClosedErr {
+		t.Fatalf("Compact a closed log should return appendLogClosedErr but got %v!", err)
+	}
+
+	if err = cache.log.sync(); err != appendLogClosedErr {
+		t.Fatalf("Sync a closed log should return appendLogClosedErr but got %v!", err)
+	}
+}
+
+// go test -cover -run=^TestCacheIncrBy$
+func TestCacheIncrBy(t *testing.T) {
+
+	cache, clock := newTestCache(DefaultOptions())
+	if result, err := cache.IncrBy("counter", 5); err != nil || result != 5 {
+		t.Fatalf("IncrBy a key not existing should return 5 but got %d and %v!", result, err)
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.Incr("counter")
+		}()
+	}
+	wg.Wait()
+
+	if value, ok, _ := cache.Get("counter"); !ok || string(value) != "105" {
+		t.Fatalf("Value of counter should be 105 but got %s!", string(value))
+	}
+
+	if result, err := cache.Decr("counter"); err != nil || result != 104 {
+		t.Fatalf("Decr should return 104 but got %d and %v!", result, err)
+	}
+
+	cache.SetWithOptions("limited", []byte("1"), SetOptions{Ttl: time.Second, Expiration: Absolute})
+	if result, err := cache.IncrBy("limited", -3); err != nil || result != -2 {
+		t.Fatalf("IncrBy limited should return -2 but got %d and %v!", result, err)
+	}
+
+	clock.Add(time.Second)
+	if _, ok, _ := cache.Get("limited"); ok {
+		t.Fatal("Ttl of limited should be kept after incrementing!")
+	}
+
+	if result, err := cache.Incr("limited"); err != nil || result != 1 {
+		t.Fatalf("Incr a dead key should return 1 but got %d and %v!", result, err)
+	}
+
+	cache.Set("text", []byte("value"))
+	if _, err := cache.Incr("text"); err != notAnIntegerErr {
+		t.Fatalf("Incr a text should return notAnIntegerErr but got %v!", err)
+	}
+
+	cache.Set("max", []byte(strconv.FormatInt(math.MaxInt64, 10)))
+	if _, err := cache.Incr("max"); err != integerOverflowErr {
+		t.Fatalf("Incr the max int64 should return integerOverflowErr but got %v!", err)
+	}
+}
+
+// go test -cover -run=^TestCacheCompareAndSet$
+func TestCacheCompareAndSet(t *testing.T) {
+
+	options := DefaultOptions()
+	options.AppendFile = filepath.Join(os.TempDir(), "TestCacheCompareAndSet.aof")
+	options.DumpFile = filepath.Join(os.TempDir(), "TestCacheCompareAndSet.dump")
+	options.AppendOnly = true
+	os.Remove(options.AppendFile)
+	os.Remove(options.DumpFile)
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, version, ok, _ := cache.GetWithVersion("key"); ok || version != 0 {
+		t.Fatalf("Version of a key not existing should be 0 but got %d!", version)
+	}
+
+	version, ok, err := cache.CompareAndSet("key", []byte("0"), 0, NeverDie)
+	if !ok || err != nil {
+		t.Fatalf("CompareAndSet a key not existing with version 0 should succeed but got %v and %v!", ok, err)
+	}
+
+	if current, ok, err := cache.CompareAndSet("key", []byte("1"), version+1, NeverDie); ok || err != nil || current != version {
+		t.Fatalf("CompareAndSet with a wrong version should return %d and false but got %d, %v and %v!", version, current, ok, err)
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				for {
+					data, version, _, _ := cache.GetWithVersion("key")
+					number, _ := strconv.Atoi(string(data))
+					if _, ok, _ := cache.CompareAndSet("key", []byte(strconv.Itoa(number+1)), version, NeverDie); ok {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, version, ok, _ := cache.GetWithVersion("key")
+	if !ok || string(data) != "100" {
+		t.Fatalf("Updates shouldn't be lost! Value is %s.", string(data))
+	}
+
+	// Versions shouldn't be reused after deleting, so an old version won't match a new value.
+	cache.Delete("key")
+	cache.Set("key", []byte("value"))
+	if _, newVersion, _, _ := cache.GetWithVersion("key"); newVersion <= version {
+		t.Fatalf("Version %d after deleting should be greater than %d!", newVersion, version)
+	}
+
+	_, version, _, _ = cache.GetWithVersion("key")
+	if err = cache.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, recovered, _, _ := cache.GetWithVersion("key"); recovered != version {
+		t.Fatalf("Version %d should be recovered from append log but got %d!", version, recovered)
+	}
+
+	if _, ok, _ := cache.CompareAndSet("key", []byte("value"), version, NeverDie); !ok {
+		t.Fatal("CompareAndSet with the recovered version should succeed!")
+	}
+	cache.Close(context.Background())
+}
+
+// go test -cover -run=^TestCacheConditionalWrites$
+func TestCacheConditionalWrites(t *testing.T) {
+
+	cache, clock := newTestCache(DefaultOptions())
+	options := SetOptions{Ttl: time.Second, Expiration: Absolute}
+	if ok, err := cache.SetXX("key", []byte("value"), options); ok || err != nil {
+		t.Fatalf("SetXX a key not existing should return false but got %v and %v!", ok, err)
+	}
+
+	if ok, err := cache.SetNX("key", []byte("value"), options); !ok || err != nil {
+		t.Fatalf("SetNX a key not existing should return true but got %v and %v!", ok, err)
+	}
+
+	if ok, err := cache.SetNX("key", []byte("new"), options); ok || err != nil {
+		t.Fatalf("SetNX an existing key should return false but got %v and %v!", ok, err)
+	}
+
+	if ok, err := cache.SetXX("key", []byte("new"), options); !ok || err != nil {
+		t.Fatalf("SetXX an existing key should return true but got %v and %v!", ok, err)
+	}
+
+	if oldValue, existed, err := cache.GetSet("key", []byte("newer"), SetOptions{Ttl: NeverDie}); !existed || err != nil || string(oldValue) != "new" {
+		t.Fatalf("GetSet should return new and true but got %s, %v and %v!", string(oldValue), existed, err)
+	}
+
+	if value, ok, err := cache.GetDel("key"); !ok || err != nil || string(value) != "newer" {
+		t.Fatalf("GetDel should return newer and true but got %s, %v and %v!", string(value), ok, err)
+	}
+
+	if value, ok, err := cache.GetDel("key"); ok || err != nil || value != nil {
+		t.Fatalf("GetDel a key not existing should return false but got %s, %v and %v!", string(value), ok, err)
+	}
+
+	if oldValue, existed, err := cache.GetSet("key", []byte("value"), options); existed || err != nil || oldValue != nil {
+		t.Fatalf("GetSet a key not existing should return false but got %s, %v and %v!", string(oldValue), existed, err)
+	}
+
+	// Dead entries are treated as not existing.
+	clock.Add(time.Second)
+	if ok, err := cache.SetXX("key", []byte("value"), options); ok || err != nil {
+		t.Fatalf("SetXX a dead key should return false but got %v and %v!", ok, err)
+	}
+
+	if ok, err := cache.SetNX("key", []byte("value"), options); !ok || err != nil {
+		t.Fatalf("SetNX a dead key should return true but got %v and %v!", ok, err)
+	}
+
+	if cache.Status().Count != 1 || cache.Status().DirtyCount != 6 {
+		t.Fatalf("Status %+v is wrong!", cache.Status())
+	}
+
+	// Hashes and lists shouldn't be overwritten by writes of strings.
+	cache.HSet("hash", map[string][]byte{"field": []byte("value")})
+	cache.RPush("list", []byte("item"))
+	if ok, err := cache.SetXX("hash", []byte("value"), options); ok || !errors.Is(err, wrongTypeErr) {
+		t.Fatalf("SetXX a hash should return wrongTypeErr but got %v and %v!", ok, err)
+	}
+
+	if _, existed, err := cache.GetSet("list", []byte("value"), options); !existed || !errors.Is(err, wrongTypeErr) {
+		t.Fatalf("GetSet a list should return wrongTypeErr but got %v and %v!", existed, err)
+	}
+
+	if ok, err := cache.SetNX("hash", []byte("value"), options); ok || err != nil {
+		t.Fatalf("SetNX a hash should return false but got %v and %v!", ok, err)
+	}
+
+	if length, _ := cache.LLen("list"); length != 1 {
+		t.Fatalf("List should be kept but its length is %d!", length)
+	}
+}
+
+// go test -cover -run=^TestCacheTTLCommands$
+func TestCacheTTLCommands(t *testing.T) {
+
+	cache, clock := newTestCache(DefaultOptions())
+	cache.SetWithOptions("sliding", []byte("value"), SetOptions{Ttl: time.Second, Expiration: Sliding})
+	cache.SetWithOptions("absolute", []byte("value"), SetOptions{Ttl: time.Second, Expiration: Absolute})
+	cache.Set("forever", []byte("value"))
+
+	if _, ok, err := cache.TTL("notExist"); ok || err != nil {
+		t.Fatal("TTL of a key not existing should return false!")
+	}
+
+	if ttl, ok, _ := cache.TTL("forever"); !ok || ttl != NeverDie {
+		t.Fatalf("TTL of forever should be NeverDie but got %v and %v!", ttl, ok)
+	}
+
+	clock.Add(400 * time.Millisecond)
+	if ttl, ok, _ := cache.TTL("absolute"); !ok || ttl != 600*time.Millisecond {
+		t.Fatalf("TTL of absolute should be 600ms but got %v and %v!", ttl, ok)
+	}
+
+	if ok, err := cache.Touch("sliding"); !ok || err != nil {
+		t.Fatalf("Touch sliding should return true but got %v and %v!", ok, err)
+	}
+
+	if ok, err := cache.Touch("notExist"); ok || err != nil {
+		t.Fatalf("Touch a key not existing should return false but got %v and %v!", ok, err)
+	}
+
+	if ttl, _, _ := cache.TTL("sliding"); ttl != time.Second {
+		t.Fatalf("TTL of sliding should be 1s after touching but got %v!", ttl)
+	}
+
+	if ok, err := cache.Expire("absolute", 2*time.Second); !ok || err != nil {
+		t.Fatalf("Expire absolute should return true but got %v and %v!", ok, err)
+	}
+
+	if ok, err := cache.Expire("forever", time.Second); !ok || err != nil {
+		t.Fatalf("Expire forever should return true but got %v and %v!", ok, err)
+	}
+
+	if ok, err := cache.Persist("sliding"); !ok || err != nil {
+		t.Fatalf("Persist sliding should return true but got %v and %v!", ok, err)
+	}
+
+	clock.Add(1500 * time.Millisecond)
+	if _, ok, _ := cache.Get("forever"); ok {
+		t.Fatal("Key forever should be dead after expiring!")
+	}
+
+	if ttl, ok, _ := cache.TTL("absolute"); !ok || ttl != 500*time.Millisecond {
+		t.Fatalf("TTL of absolute should be 500ms but got %v and %v!", ttl, ok)
+	}
+
+	if ttl, ok, _ := cache.TTL("sliding"); !ok || ttl != NeverDie {
+		t.Fatalf("TTL of sliding should be NeverDie after persisting but got %v and %v!", ttl, ok)
+	}
+
+	if ok, err := cache.Expire("sliding", 0); !ok || err != nil {
+		t.Fatalf("Expire sliding with 0 should return true but got %v and %v!", ok, err)
+	}
+
+	if ok, err := cache.Persist("sliding"); ok || err != nil {
+		t.Fatalf("Persist a deleted key should return false but got %v and %v!", ok, err)
+	}
+
+	// Touch works for keys in any type.
+	cache.RPush("list", []byte("item"))
+	cache.Expire("list", time.Second)
+	clock.Add(400 * time.Millisecond)
+	if ok, _ := cache.Touch("list"); !ok {
+		t.Fatal("Touch a list should return true!")
+	}
+
+	if ttl, _, _ := cache.TTL("list"); ttl != time.Second {
+		t.Fatalf("TTL of list should be 1s after touching but got %v!", ttl)
+	}
+}
+
+// go test -cover -run=^TestCachePeek$
+func TestCachePeek(t *testing.T) {
+
+	options := DefaultOptions()
+	options.EvictionPolicy = LRU
+	cache, clock := newTestCache(options)
+	cache.SetWithOptions("session", []byte("value"), SetOptions{Ttl: time.Second, Expiration: Sliding})
+
+	clock.Add(800 * time.Millisecond)
+	if value, ok, _ := cache.Peek("session"); !ok || string(value) != "value" {
+		t.Fatalf("Peek session should return value but got %s and %v!", string(value), ok)
+	}
+
+	if ttl, _, _ := cache.TTL("session"); ttl != 200*time.Millisecond {
+		t.Fatalf("Peeking shouldn't refresh the sliding ttl, but ttl is %v!", ttl)
+	}
+
+	clock.Add(200 * time.Millisecond)
+	if _, ok, _ := cache.Peek("session"); ok {
+		t.Fatal("Peek a dead key should return false!")
+	}
+
+	// Peeking shouldn't change the order of eviction.
+	s := newSegment(&options)
+	s.set("old", []byte("value"), SetOptions{Ttl: NeverDie})
+	s.set("new", []byte("value"), SetOptions{Ttl: NeverDie})
+	s.peek("old")
+	if victim, _ := s.policy.Victim(); victim != "old" {
+		t.Fatalf("Victim should be old but got %s!", victim)
+	}
+}
+
+// go test -cover -race -run=^TestCacheConcurrentReads$
+func TestCacheConcurrentReads(t *testing.T) {
+
+	options := DefaultOptions()
+	options.EvictionPolicy = LRU
+	cache, clock := newTestCache(options)
+	for i := 0; i < 100; i++ {
+		cache.SetWithOptions(strconv.Itoa(i), []byte("value"), SetOptions{Ttl: time.Second, Expiration: Sliding})
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				cache.Get(strconv.Itoa(j))
+				cache.segmentOf(strconv.Itoa(j)).snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+
+	clock.Add(time.Second)
+	if _, ok, _ := cache.Get("0"); ok {
+		t.Fatal("Get a dead key should return false!")
+	}
+
+	if status := cache.Status(); status.Count != 99 || status.Expired != 1 {
+		t.Fatalf("Dead key should be removed by reading, but status is %+v!", status)
+	}
+}
+
+// go test -cover -run=^TestCacheBatch$
+func TestCacheBatch(t *testing.T) {
+
+	cache, clock := newTestCache(DefaultOptions())
+	entries := map[string][]byte{}
+	keys := []string{"notExist"}
+	for i := 0; i < 200; i++ {
+		key := "key" + strconv.Itoa(i)
+		entries[key] = []byte("value" + strconv.Itoa(i))
+		keys = append(keys, key)
+	}
+
+	if err := cache.MSet(entries, SetOptions{Ttl: time.Second, Expiration: Absolute}); err != nil {
+		t.Fatal(err)
+	}
+
+	values, _ := cache.MGet(keys)
+	if len(values) != 200 {
+		t.Fatalf("MGet should return 200 values but got %d!", len(values))
+	}
+
+	for key, value := range entries {
+		if string(values[key]) != string(value) {
+			t.Fatalf("Value of %s should be %s but got %s!", key, string(value), string(values[key]))
+		}
+	}
+
+	if count, err := cache.MDelete(keys[:101]); err != nil || count != 100 {
+		t.Fatalf("MDelete should delete 100 keys but got %d and %v!", count, err)
+	}
+
+	if status := cache.Status(); status.Count != 100 || status.DirtyCount != 300 {
+		t.Fatalf("Status %+v is wrong after batch operations!", status)
+	}
+
+	clock.Add(time.Second)
+	if values, _ = cache.MGet(keys); len(values) != 0 {
+		t.Fatalf("MGet dead keys should return nothing but got %d values!", len(values))
+	}
+
+	if err := cache.MSet(entries, SetOptions{Expiration: 99}); err == nil {
+		t.Fatal("MSet with invalid options should fail!")
+	}
+}
+
+// go test -cover -run=^TestErrorKinds$
+func TestErrorKinds(t *testing.T) {
+
+	cache := NewCache()
+	cache.LPush("list", []byte("item"))
+	if _, err := cache.Incr("list"); !IsInvalidArgument(err) || IsClosed(err) {
+		t.Fatalf("Incr a list should return an invalid argument error but got %v!", err)
+	}
+
+	if err := cache.Set("large", make([]byte, cache.segmentOf("large").maxEntrySize()+1)); !IsEntrySizeExceeded(err) {
+		t.Fatalf("Set a large value should return an entry size exceeded error but got %v!", err)
+	}
+
+	cache.Close(context.Background())
+	if err := cache.Set("key", []byte("value")); !IsClosed(err) || IsInvalidArgument(err) {
+		t.Fatalf("Set after closing should return a closed error but got %v!", err)
+	}
+}
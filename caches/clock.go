@@ -0,0 +1,116 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/12/19 14:08:36
+
+package caches
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time used by cache for expiration, gc, dump timestamps and timeouts of blocking pops.
+type Clock interface {
+
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel receiving the time after d and a function stopping it.
+	// The channel never receives anything after stopping.
+	After(d time.Duration) (<-chan time.Time, func())
+}
+
+// systemClock is a clock returning the time of system.
+type systemClock struct{}
+
+// SystemClock returns a clock telling the time of system.
+func SystemClock() Clock {
+	return systemClock{}
+}
+
+// Now returns the time of system.
+func (sc systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// After returns the channel of a system timer and a function stopping it.
+func (sc systemClock) After(d time.Duration) (<-chan time.Time, func()) {
+	timer := time.NewTimer(d)
+	return timer.C, func() {
+		timer.Stop()
+	}
+}
+
+// FakeClock is a clock which only moves when it's told to, so tests about time can be deterministic.
+type FakeClock struct {
+
+	// now is the time of clock.
+	now time.Time
+
+	// timers are channels waiting for their deadlines, and they receive the time when the clock reaches them.
+	timers map[chan time.Time]time.Time
+
+	// lock is for concurrency.
+	lock *sync.RWMutex
+}
+
+// NewFakeClock returns a fake clock starting at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now:    now,
+		timers: make(map[chan time.Time]time.Time),
+		lock:   &sync.RWMutex{},
+	}
+}
+
+// Now returns the time of clock.
+func (fc *FakeClock) Now() time.Time {
+	fc.lock.RLock()
+	defer fc.lock.RUnlock()
+	return fc.now
+}
+
+// After returns a channel receiving the time when the clock is moved to d later than now,
+// and a function stopping it.
+func (fc *FakeClock) After(d time.Duration) (<-chan time.Time, func()) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	timer := make(chan time.Time, 1)
+	fc.timers[timer] = fc.now.Add(d)
+	fc.fire()
+	return timer, func() {
+		fc.lock.Lock()
+		defer fc.lock.Unlock()
+		delete(fc.timers, timer)
+	}
+}
+
+// Set sets the time of clock to now.
+func (fc *FakeClock) Set(now time.Time) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	fc.now = now
+	fc.fire()
+}
+
+// Add moves the clock forward by d.
+func (fc *FakeClock) Add(d time.Duration) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	fc.now = fc.now.Add(d)
+	fc.fire()
+}
+
+// fire sends the time to timers reaching their deadlines and removes them without locking.
+func (fc *FakeClock) fire() {
+	for timer, deadline := range fc.timers {
+		if !deadline.After(fc.now) {
+			timer <- fc.now
+			delete(fc.timers, timer)
+		}
+	}
+}
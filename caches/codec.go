@@ -0,0 +1,344 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/12/05 15:20:41
+
+package caches
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// CompressionNone means dump files aren't compressed.
+	CompressionNone = "none"
+
+	// CompressionGzip means dump files are compressed by gzip.
+	CompressionGzip = "gzip"
+
+	// CompressionFlate means dump files are compressed by flate.
+	CompressionFlate = "flate"
+)
+
+const (
+	// gzipFlag is the flag in header of dump file compressed by gzip.
+	gzipFlag = uint16(1 << 0)
+
+	// flateFlag is the flag in header of dump file compressed by flate.
+	flateFlag = uint16(1 << 1)
+
+	// encryptedFlag is the flag in header of dump file encrypted by AES-GCM.
+	encryptedFlag = uint16(1 << 2)
+
+	// knownFlags is all flags supported.
+	knownFlags = gzipFlag | flateFlag | encryptedFlag
+
+	// maxCompressionRatio is the max ratio of data size to compressed size of flate.
+	maxCompressionRatio = 1032
+
+	// encryptedChunkSize is the max size of plaintext in one encrypted chunk.
+	encryptedChunkSize = 64 * 1024
+
+	// lastChunkFlag is the flag of the last encrypted chunk.
+	lastChunkFlag = byte(1)
+)
+
+var (
+	// unknownCompressionErr means the compression in options isn't supported.
+	unknownCompressionErr = errors.New("the compression of dump file is unknown")
+
+	// invalidDumpKeyErr means the key of dump file isn't a hex string of 16, 24 or 32 bytes.
+	invalidDumpKeyErr = errors.New("the key of dump file should be a hex string of 16, 24 or 32 bytes")
+
+	// dumpKeyRequiredErr means the dump file is encrypted but no keys are given.
+	dumpKeyRequiredErr = errors.New("the dump file is encrypted but no keys are given")
+)
+
+// compressionFlag returns the flag of compression and an error if compression is unknown.
+func compressionFlag(compression string) (uint16, error) {
+	switch compression {
+	case "", CompressionNone:
+		return 0, nil
+	case CompressionGzip:
+		return gzipFlag, nil
+	case CompressionFlate:
+		return flateFlag, nil
+	default:
+		return 0, fmt.Errorf("%w: %s", unknownCompressionErr, compression)
+	}
+}
+
+// dumpKey returns the key of dump file in options, which is nil if no keys are configured.
+// DumpKeyFile will be used first, then the environment variable named DumpKeyEnv.
+func dumpKey(options *Options) ([]byte, error) {
+
+	var encoded string
+	switch {
+	case options.DumpKeyFile != "":
+		data, err := ioutil.ReadFile(options.DumpKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(data)
+	case options.DumpKeyEnv != "":
+		encoded = os.Getenv(options.DumpKeyEnv)
+		if encoded == "" {
+			return nil, fmt.Errorf("%w: environment variable %s is empty", invalidDumpKeyErr, options.DumpKeyEnv)
+		}
+	default:
+		return nil, nil
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, invalidDumpKeyErr
+	}
+
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		return nil, invalidDumpKeyErr
+	}
+	return key, nil
+}
+
+// newAEAD returns an AES-GCM cipher of key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkAdditionalData returns the additional data of chunk, which binds its index and flag.
+// So chunks can't be reordered, and the stream can't be truncated at a chunk boundary.
+func chunkAdditionalData(index uint64, flag byte) []byte {
+	return append(appendUint64(nil, index), flag)
+}
+
+// =======================================================================
+
+// encryptWriter encrypts data written to it chunk by chunk.
+// A chunk is a flag byte, a uint32 size of sealed data, a nonce and the sealed data.
+type encryptWriter struct {
+
+	// writer is the writer of encrypted chunks.
+	writer io.Writer
+
+	// aead is the cipher encrypting chunks.
+	aead cipher.AEAD
+
+	// chunk stores the plaintext of current chunk.
+	chunk []byte
+
+	// index is the index of current chunk.
+	index uint64
+}
+
+// newEncryptWriter returns an encrypt writer writing to writer with aead.
+func newEncryptWriter(writer io.Writer, aead cipher.AEAD) *encryptWriter {
+	return &encryptWriter{
+		writer: writer,
+		aead:   aead,
+		chunk:  make([]byte, 0, encryptedChunkSize),
+	}
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := encryptedChunkSize - len(ew.chunk)
+		if n > len(p) {
+			n = len(p)
+		}
+
+		ew.chunk = append(ew.chunk, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(ew.chunk) >= encryptedChunkSize {
+			if err := ew.writeChunk(0); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// writeChunk encrypts and writes current chunk with flag.
+func (ew *encryptWriter) writeChunk(flag byte) error {
+	nonce := make([]byte, ew.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	sealed := ew.aead.Seal(nil, nonce, ew.chunk, chunkAdditionalData(ew.index, flag))
+	chunkHeader := appendUint32([]byte{flag}, uint32(len(sealed)))
+	if _, err := ew.writer.Write(append(append(chunkHeader, nonce...), sealed...)); err != nil {
+		return err
+	}
+
+	ew.chunk = ew.chunk[:0]
+	ew.index++
+	return nil
+}
+
+// Close writes the last chunk, and the underlying writer won't be closed.
+func (ew *encryptWriter) Close() error {
+	return ew.writeChunk(lastChunkFlag)
+}
+
+// decryptReader decrypts chunks written by encryptWriter.
+type decryptReader struct {
+
+	// reader is the reader of encrypted chunks.
+	reader io.Reader
+
+	// aead is the cipher decrypting chunks.
+	aead cipher.AEAD
+
+	// limit is the max size of one sealed chunk.
+	limit int
+
+	// chunk is the plaintext left in current chunk.
+	chunk []byte
+
+	// index is the index of next chunk.
+	index uint64
+
+	// done means the last chunk has been read.
+	done bool
+}
+
+// newDecryptReader returns a decrypt reader reading from reader with aead.
+func newDecryptReader(reader io.Reader, aead cipher.AEAD) *decryptReader {
+	return &decryptReader{
+		reader: reader,
+		aead:   aead,
+		limit:  encryptedChunkSize + aead.Overhead(),
+	}
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.chunk) <= 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+
+		if err := dr.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, dr.chunk)
+	dr.chunk = dr.chunk[n:]
+	return n, nil
+}
+
+// readChunk reads and decrypts the next chunk.
+func (dr *decryptReader) readChunk() error {
+	chunkHeader := make([]byte, 5+dr.aead.NonceSize())
+	if _, err := io.ReadFull(dr.reader, chunkHeader); err != nil {
+		return corrupted("read encrypted chunk %d failed: %v", dr.index, err)
+	}
+
+	flag := chunkHeader[0]
+	size := int(binary.BigEndian.Uint32(chunkHeader[1:]))
+	if flag&^lastChunkFlag != 0 || size > dr.limit {
+		return corrupted("wrong header of encrypted chunk %d", dr.index)
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(dr.reader, sealed); err != nil {
+		return corrupted("read encrypted chunk %d failed: %v", dr.index, err)
+	}
+
+	chunk, err := dr.aead.Open(sealed[:0], chunkHeader[5:], sealed, chunkAdditionalData(dr.index, flag))
+	if err != nil {
+		return corrupted("decrypt chunk %d failed, the key may be wrong", dr.index)
+	}
+
+	dr.chunk = chunk
+	dr.index++
+	dr.done = flag == lastChunkFlag
+	return nil
+}
+
+// =======================================================================
+
+// newBodyWriter returns a writer of dump body on writer according to flags.
+// The returned closers should be closed in order after writing the body.
+func newBodyWriter(writer io.Writer, flags uint16, key []byte) (io.Writer, []io.Closer, error) {
+
+	var closers []io.Closer
+	if flags&encryptedFlag != 0 {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		encryptor := newEncryptWriter(writer, aead)
+		writer = encryptor
+		closers = append(closers, encryptor)
+	}
+
+	switch {
+	case flags&gzipFlag != 0:
+		compressor := gzip.NewWriter(writer)
+		writer = compressor
+		closers = append([]io.Closer{compressor}, closers...)
+	case flags&flateFlag != 0:
+		compressor, err := flate.NewWriter(writer, flate.DefaultCompression)
+		if err != nil {
+			return nil, nil, err
+		}
+		writer = compressor
+		closers = append([]io.Closer{compressor}, closers...)
+	}
+	return writer, closers, nil
+}
+
+// newBodyReader returns a reader of dump body on reader according to flags.
+func newBodyReader(reader io.Reader, flags uint16, key []byte) (io.Reader, error) {
+
+	if flags&^knownFlags != 0 || flags&gzipFlag != 0 && flags&flateFlag != 0 {
+		return nil, corrupted("unsupported flags %b", flags)
+	}
+
+	if flags&encryptedFlag != 0 {
+		if key == nil {
+			return nil, dumpKeyRequiredErr
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		reader = newDecryptReader(reader, aead)
+	}
+
+	switch {
+	case flags&gzipFlag != 0:
+		decompressor, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, corrupted("read gzip header failed: %v", err)
+		}
+		reader = decompressor
+	case flags&flateFlag != 0:
+		reader = flate.NewReader(reader)
+	}
+	return reader, nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/12/05 17:02:13
+
+package caches
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// go test -cover -run=^TestDumpCompressedAndEncrypted$
+func TestDumpCompressedAndEncrypted(t *testing.T) {
+
+	keyFile := filepath.Join(os.TempDir(), "TestDumpCompressedAndEncrypted.key")
+	if err := ioutil.WriteFile(keyFile, []byte("000102030405060708090a0b0c0d0e0f000102030405060708090a0b0c0d0e0f\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("KAFO_TEST_DUMP_KEY", "0f0e0d0c0b0a09080706050403020100")
+	defer os.Unsetenv("KAFO_TEST_DUMP_KEY")
+
+	cache := NewCache()
+	value := bytes.Repeat([]byte(`{"name":"kafo","secret":"plaintext"}`), 5)
+	for i := 0; i < 2000; i++ {
+		cache.Set("key"+strconv.Itoa(i), value)
+	}
+
+	plainFile := filepath.Join(os.TempDir(), "TestDumpCompressedAndEncrypted.plain.dump")
+	if err := newDump(cache, 0).to(plainFile); err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := ioutil.ReadFile(plainFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		compression string
+		keyFile     string
+		keyEnv      string
+		flags       uint16
+	}{
+		{compression: CompressionGzip, flags: gzipFlag},
+		{compression: CompressionFlate, flags: flateFlag},
+		{compression: CompressionNone, keyFile: keyFile, flags: encryptedFlag},
+		{compression: CompressionGzip, keyEnv: "KAFO_TEST_DUMP_KEY", flags: gzipFlag | encryptedFlag},
+	}
+
+	for _, c := range cases {
+		options := DefaultOptions()
+		options.DumpCompression = c.compression
+		options.DumpKeyFile = c.keyFile
+		options.DumpKeyEnv = c.keyEnv
+		cache.options = &options
+
+		dumpFile := filepath.Join(os.TempDir(), "TestDumpCompressedAndEncrypted.dump")
+		if err := newDump(cache, 0).to(dumpFile); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadFile(dumpFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if flags := binary.BigEndian.Uint16(data[6:]); flags != c.flags {
+			t.Fatalf("Flags of %+v should be %b but got %b!", c, c.flags, flags)
+		}
+
+		if c.flags&(gzipFlag|flateFlag) != 0 && len(data) >= len(plain)/2 {
+			t.Fatalf("Compressed size %d of %+v is too large, and plain size is %d!", len(data), c, len(plain))
+		}
+
+		if c.flags&encryptedFlag != 0 && bytes.Contains(data, []byte("plaintext")) {
+			t.Fatalf("Dump file of %+v shouldn't contain any plaintext!", c)
+		}
+
+		// Loading doesn't need the compression, and it's detected from the header.
+		loadOptions := DefaultOptions()
+		loadOptions.DumpKeyFile = c.keyFile
+		loadOptions.DumpKeyEnv = c.keyEnv
+		loaded, err := newEmptyDump().from(dumpFile, loadOptions)
+		if err != nil {
+			t.Fatalf("Load dump file of %+v failed: %v", c, err)
+		}
+
+		if got, ok, _ := loaded.Get("key1999"); !ok || !bytes.Equal(got, value) || loaded.Status().Count != 2000 {
+			t.Fatalf("Load dump file of %+v is wrong! Status is %+v.", c, loaded.Status())
+		}
+
+		if c.flags&encryptedFlag == 0 {
+			continue
+		}
+
+		if _, err = newEmptyDump().from(dumpFile, DefaultOptions()); err != dumpKeyRequiredErr {
+			t.Fatalf("Load encrypted dump file without keys should fail with dumpKeyRequiredErr but got %v!", err)
+		}
+
+		wrongOptions := DefaultOptions()
+		wrongOptions.DumpKeyEnv = "KAFO_TEST_DUMP_KEY"
+		if c.keyEnv != "" {
+			wrongOptions.DumpKeyEnv = ""
+			wrongOptions.DumpKeyFile = keyFile
+		}
+
+		if _, err = newEmptyDump().from(dumpFile, wrongOptions); !errors.Is(err, corruptedDumpErr) {
+			t.Fatalf("Load encrypted dump file with a wrong key should fail but got %v!", err)
+		}
+	}
+}
+
+// go test -cover -run=^TestEncryptedChunks$
+func TestEncryptedChunks(t *testing.T) {
+
+	aead, err := newAEAD(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("kafo"), encryptedChunkSize)
+	buffer := &bytes.Buffer{}
+	writer := newEncryptWriter(buffer, aead)
+	if _, err = writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if writer.index != 5 {
+		t.Fatalf("There should be 5 chunks but got %d!", writer.index)
+	}
+
+	encrypted := buffer.Bytes()
+	decrypted, err := ioutil.ReadAll(newDecryptReader(bytes.NewReader(encrypted), aead))
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("Decrypted data is wrong! Error is %v.", err)
+	}
+
+	// Dropping the last chunk should be detected.
+	truncated := encrypted[:len(encrypted)-5-aead.NonceSize()-aead.Overhead()]
+	if _, err = ioutil.ReadAll(newDecryptReader(bytes.NewReader(truncated), aead)); !errors.Is(err, corruptedDumpErr) {
+		t.Fatalf("Read truncated chunks should fail but got %v!", err)
+	}
+}
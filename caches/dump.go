@@ -9,23 +9,772 @@
 package caches
 
 import (
-	"encoding/gob"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
 	"os"
-	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/avino-plan/kafo/helpers"
 )
 
-// dump is for dumping the cache.
-type dump struct {
+// The format of dump file (all integers are big endian):
+//
+// Header:
+//   magic       4 bytes     "KAFO"
+//   version     uint16      the version of format, which is dumpVersion now
+//   flags       uint16      the compression and encryption of blocks and trailer
+//   seq         uint64      the seq of append log when dumping
+//   count       uint64      the number of entries in file
+//   configSize  uint32      the size of config
+//   config      fields      the options of cache when dumping
+//   crc         uint32      the crc32 of all bytes above in header
+//
+// Block (repeated until a block with zero size):
+//   size        uint32      the size of entries, zero means no more blocks
+//   count       uint32      the number of entries in block
+//   entries     entries     the entries in block
+//   crc         uint32      the crc32 of count and entries
+//
+// Trailer:
+//   magic       4 bytes     "KEND"
+//   count       uint64      the number of entries in file
+//   blocks      uint64      the number of blocks in file
+//   crc         uint32      the crc32 of all bytes above in trailer
+//
+// An entry is a uvarint size of key, the key, a uvarint number of fields and the fields.
+// A field is a uvarint tag, a uvarint size of data and the data.
+// Config is a sequence of fields without the number of fields.
+// Readers skip the fields with unknown tags, so fields can be added without changing the version.
+//
+// Header is always plain, and blocks and trailer are compressed by gzip or flate if gzipFlag or flateFlag is set.
+// Then they are encrypted by AES-GCM in chunks if encryptedFlag is set, see encryptWriter.
+
+const (
+	// dumpMagic is the magic bytes at the beginning of dump file.
+	dumpMagic = "KAFO"
+
+	// dumpTrailerMagic is the magic bytes at the beginning of trailer.
+	dumpTrailerMagic = "KEND"
+
+	// dumpVersion is the version of dump format.
+	dumpVersion = uint16(1)
+
+	// dumpBlockSize is the size of entries which triggers flushing a block.
+	dumpBlockSize = 64 * 1024
+
+	// dumpReportSize is the min size of dump file which reports the progress of recovering.
+	dumpReportSize = 64 * dumpBlockSize
+
+	// dumpCountOffset is the offset of count in header.
+	dumpCountOffset = 16
+
+	// dumpHeaderFixedSize is the size of header before config.
+	dumpHeaderFixedSize = dumpCountOffset + 8 + 4
+)
+
+const (
+	// dataField is the tag of value.Data.
+	dataField = uint64(1)
+
+	// ttlField is the tag of value.Ttl.
+	ttlField = uint64(2)
+
+	// ctimeField is the tag of value.Ctime.
+	ctimeField = uint64(3)
+
+	// atimeField is the tag of value.Atime.
+	// value.Atime is value.Ctime if it's missing.
+	atimeField = uint64(4)
+
+	// expirationField is the tag of value.Expiration.
+	// value.Expiration is Sliding if it's missing.
+	expirationField = uint64(5)
+
+	// maxTtlField is the tag of value.MaxTtl.
+	maxTtlField = uint64(6)
+
+	// versionField is the tag of value.Version.
+	// value.Version is 0 if it's missing.
+	versionField = uint64(7)
+
+	// hashField is the tag of value.Hash encoded by encodeHash, and it only exists in hash values.
+	hashField = uint64(8)
+
+	// listField is the tag of value.List encoded by encodeList, and it only exists in list values.
+	listField = uint64(9)
+)
+
+const (
+	// segmentSizeConfig is the tag of Options.SegmentSize.
+	segmentSizeConfig = uint64(1)
+
+	// maxEntrySizeConfig is the tag of Options.MaxEntrySize.
+	maxEntrySizeConfig = uint64(2)
+
+	// mapSizeOfSegmentConfig is the tag of Options.MapSizeOfSegment.
+	mapSizeOfSegmentConfig = uint64(3)
+
+	// evictionPolicyConfig is the tag of Options.EvictionPolicy.
+	evictionPolicyConfig = uint64(4)
+)
+
+var (
+	// corruptedDumpErr means the dump file is corrupted.
+	corruptedDumpErr = errors.New("the dump file is corrupted")
+)
 
-	// SegmentSize is the size of segments.
-	SegmentSize int
+// corrupted returns an error wrapping corruptedDumpErr with reason.
+func corrupted(reason string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", corruptedDumpErr, fmt.Sprintf(reason, args...))
+}
+
+// appendField appends a field of tag and data to buffer.
+func appendField(buffer []byte, tag uint64, data []byte) []byte {
+	buffer = appendUvarint(buffer, tag)
+	buffer = appendUvarint(buffer, uint64(len(data)))
+	return append(buffer, data...)
+}
+
+// appendUint32 appends x to buffer in big endian.
+func appendUint32(buffer []byte, x uint32) []byte {
+	var encoded [4]byte
+	binary.BigEndian.PutUint32(encoded[:], x)
+	return append(buffer, encoded[:]...)
+}
+
+// appendUint64 appends x to buffer in big endian.
+func appendUint64(buffer []byte, x uint64) []byte {
+	var encoded [8]byte
+	binary.BigEndian.PutUint64(encoded[:], x)
+	return append(buffer, encoded[:]...)
+}
+
+// appendUvarint appends x to buffer in uvarint.
+func appendUvarint(buffer []byte, x uint64) []byte {
+	var encoded [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(encoded[:], x)
+	return append(buffer, encoded[:n]...)
+}
+
+// varintBytes returns the bytes of x in varint.
+func varintBytes(x int64) []byte {
+	encoded := make([]byte, binary.MaxVarintLen64)
+	return encoded[:binary.PutVarint(encoded, x)]
+}
+
+// uvarintBytes returns the bytes of x in uvarint.
+func uvarintBytes(x uint64) []byte {
+	return appendUvarint(nil, x)
+}
+
+// fieldReader reads uvarints and fields from bytes.
+type fieldReader struct {
+
+	// data is the bytes remaining to read.
+	data []byte
+}
+
+// readUvarint reads an uvarint and returns false if failed.
+func (fr *fieldReader) readUvarint() (uint64, bool) {
+	x, n := binary.Uvarint(fr.data)
+	if n <= 0 {
+		return 0, false
+	}
+	fr.data = fr.data[n:]
+	return x, true
+}
+
+// readBytes reads a uvarint size and bytes of this size and returns false if failed.
+func (fr *fieldReader) readBytes() ([]byte, bool) {
+	size, ok := fr.readUvarint()
+	if !ok || size > uint64(len(fr.data)) {
+		return nil, false
+	}
+
+	data := fr.data[:size]
+	fr.data = fr.data[size:]
+	return data, true
+}
+
+// readField reads a field and returns false if failed.
+func (fr *fieldReader) readField() (uint64, []byte, bool) {
+	tag, ok := fr.readUvarint()
+	if !ok {
+		return 0, nil, false
+	}
+
+	data, ok := fr.readBytes()
+	return tag, data, ok
+}
+
+// fieldInt64 returns the int64 of a varint field.
+func fieldInt64(data []byte) (int64, bool) {
+	x, n := binary.Varint(data)
+	return x, n > 0
+}
+
+// fieldUint64 returns the uint64 of a uvarint field.
+func fieldUint64(data []byte) (uint64, bool) {
+	x, n := binary.Uvarint(data)
+	return x, n > 0
+}
+
+// fieldInt stores the int of a uvarint field to x and returns false if failed.
+func fieldInt(data []byte, x *int) bool {
+	u, n := binary.Uvarint(data)
+	*x = int(u)
+	return n > 0
+}
 
-	// Segments is a slice stores the real data.
-	Segments []*segment
+// =======================================================================
+
+// encodeConfig returns the config fields of options.
+func encodeConfig(options *Options) []byte {
+	var config []byte
+	config = appendField(config, segmentSizeConfig, uvarintBytes(uint64(options.SegmentSize)))
+	config = appendField(config, maxEntrySizeConfig, uvarintBytes(uint64(options.MaxEntrySize)))
+	config = appendField(config, mapSizeOfSegmentConfig, uvarintBytes(uint64(options.MapSizeOfSegment)))
+	config = appendField(config, evictionPolicyConfig, []byte(options.EvictionPolicy))
+	return config
+}
+
+// warnConfigChanged logs a warning for each config in dumped which is different from options.
+func warnConfigChanged(dumpFile string, dumped *Options, options *Options) {
+	changes := []struct {
+		name    string
+		dumped  interface{}
+		current interface{}
+	}{
+		{name: "SegmentSize", dumped: dumped.SegmentSize, current: options.SegmentSize},
+		{name: "MaxEntrySize", dumped: dumped.MaxEntrySize, current: options.MaxEntrySize},
+		{name: "MapSizeOfSegment", dumped: dumped.MapSizeOfSegment, current: options.MapSizeOfSegment},
+		{name: "EvictionPolicy", dumped: dumped.EvictionPolicy, current: options.EvictionPolicy},
+	}
+
+	for _, change := range changes {
+		if change.dumped != change.current {
+			log.Printf("Warning: %s of dump file %s is %v but current one is %v, and current one will be used.\n",
+				change.name, dumpFile, change.dumped, change.current)
+		}
+	}
+}
+
+// decodeConfig decodes config fields to options and returns false if failed.
+func decodeConfig(config []byte, options *Options) bool {
+	reader := &fieldReader{data: config}
+	for len(reader.data) > 0 {
+		tag, data, ok := reader.readField()
+		if !ok {
+			return false
+		}
+
+		switch tag {
+		case segmentSizeConfig:
+			ok = fieldInt(data, &options.SegmentSize)
+		case maxEntrySizeConfig:
+			ok = fieldInt(data, &options.MaxEntrySize)
+		case mapSizeOfSegmentConfig:
+			ok = fieldInt(data, &options.MapSizeOfSegment)
+		case evictionPolicyConfig:
+			options.EvictionPolicy = string(data)
+		}
+
+		if !ok {
+			return false
+		}
+	}
+	return options.SegmentSize > 0
+}
+
+// encodeEntry appends the entry of key and value to buffer.
+func encodeEntry(buffer []byte, key string, value *value) []byte {
+	buffer = appendUvarint(buffer, uint64(len(key)))
+	buffer = append(buffer, key...)
+	fieldCount := uint64(7)
+	if value.typ() != stringType {
+		fieldCount++
+	}
+
+	buffer = appendUvarint(buffer, fieldCount)
+	buffer = appendField(buffer, dataField, value.Data)
+	buffer = appendField(buffer, ttlField, varintBytes(value.Ttl))
+	buffer = appendField(buffer, ctimeField, varintBytes(value.Ctime))
+	buffer = appendField(buffer, atimeField, varintBytes(value.Atime))
+	buffer = appendField(buffer, expirationField, uvarintBytes(uint64(value.Expiration)))
+	buffer = appendField(buffer, maxTtlField, varintBytes(value.MaxTtl))
+	buffer = appendField(buffer, versionField, uvarintBytes(value.Version))
+	switch value.typ() {
+	case hashType:
+		buffer = appendField(buffer, hashField, encodeHash(value.Hash))
+	case listType:
+		buffer = appendField(buffer, listField, encodeList(value.List))
+	}
+	return buffer
+}
+
+// decodeEntry reads an entry from reader and returns false if failed.
+func decodeEntry(reader *fieldReader) (string, *value, bool) {
+	key, ok := reader.readBytes()
+	if !ok {
+		return "", nil, false
+	}
+
+	fieldCount, ok := reader.readUvarint()
+	if !ok {
+		return "", nil, false
+	}
+
+	v := &value{}
+	hasAtime := false
+	for i := uint64(0); i < fieldCount; i++ {
+		tag, data, ok := reader.readField()
+		if !ok {
+			return "", nil, false
+		}
+
+		switch tag {
+		case dataField:
+			v.Data = helpers.Copy(data)
+		case ttlField:
+			v.Ttl, ok = fieldInt64(data)
+		case ctimeField:
+			v.Ctime, ok = fieldInt64(data)
+		case atimeField:
+			v.Atime, ok = fieldInt64(data)
+			hasAtime = true
+		case expirationField:
+			var expiration int
+			ok = fieldInt(data, &expiration)
+			v.Expiration = ExpirationMode(expiration)
+		case maxTtlField:
+			v.MaxTtl, ok = fieldInt64(data)
+		case versionField:
+			v.Version, ok = fieldUint64(data)
+		case hashField:
+			v.Hash, ok = decodeHash(data)
+		case listField:
+			v.List, ok = decodeList(data)
+		}
+
+		if !ok {
+			return "", nil, false
+		}
+	}
+
+	if !hasAtime {
+		v.Atime = v.Ctime
+	}
+
+	v.countFieldsSize()
+	return string(key), v, true
+}
+
+// =======================================================================
+
+// dumpWriter writes entries to a dump file one by one.
+type dumpWriter struct {
+
+	// file is the dump file to write.
+	file *os.File
+
+	// buffer is the buffered writer of file.
+	buffer *bufio.Writer
+
+	// writer is the writer of blocks and trailer, which compresses and encrypts data if needed.
+	writer io.Writer
+
+	// closers closes the compressor and the encryptor of writer in order.
+	closers []io.Closer
+
+	// header is the header of dump file, which will be rewritten when closing.
+	header []byte
+
+	// block stores the entries of current block.
+	block []byte
+
+	// blockCount is the number of entries in current block.
+	blockCount uint32
+
+	// count is the number of entries written.
+	count uint64
+
+	// blocks is the number of blocks written.
+	blocks uint64
+}
+
+// newDumpWriter returns a dump writer of file and writes the header with options and seq.
+// Blocks will be compressed and encrypted according to DumpCompression and the key in options.
+func newDumpWriter(file *os.File, options *Options, seq uint64) (*dumpWriter, error) {
+
+	flags, err := compressionFlag(options.DumpCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := dumpKey(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if key != nil {
+		flags |= encryptedFlag
+	}
+
+	config := encodeConfig(options)
+	header := make([]byte, dumpHeaderFixedSize, dumpHeaderFixedSize+len(config)+4)
+	copy(header, dumpMagic)
+	binary.BigEndian.PutUint16(header[4:], dumpVersion)
+	binary.BigEndian.PutUint16(header[6:], flags)
+	binary.BigEndian.PutUint64(header[8:], seq)
+	binary.BigEndian.PutUint32(header[dumpCountOffset+8:], uint32(len(config)))
+	header = append(header, config...)
+	header = append(header, 0, 0, 0, 0)
+
+	buffer := bufio.NewWriter(file)
+	if _, err = buffer.Write(header); err != nil {
+		return nil, err
+	}
+
+	writer, closers, err := newBodyWriter(buffer, flags, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dumpWriter{
+		file:    file,
+		buffer:  buffer,
+		writer:  writer,
+		closers: closers,
+		header:  header,
+		block:   make([]byte, 0, dumpBlockSize),
+	}, nil
+}
+
+// writeEntry writes an entry of key and value and returns an error if failed.
+func (dw *dumpWriter) writeEntry(key string, value *value) error {
+	dw.block = encodeEntry(dw.block, key, value)
+	dw.blockCount++
+	dw.count++
+	if len(dw.block) >= dumpBlockSize {
+		return dw.flushBlock()
+	}
+	return nil
+}
+
+// flushBlock writes current block if it has entries.
+func (dw *dumpWriter) flushBlock() error {
+	if dw.blockCount <= 0 {
+		return nil
+	}
+
+	blockHeader := make([]byte, 8)
+	binary.BigEndian.PutUint32(blockHeader, uint32(len(dw.block)))
+	binary.BigEndian.PutUint32(blockHeader[4:], dw.blockCount)
+	checksum := crc32.Update(crc32.ChecksumIEEE(blockHeader[4:]), crc32.IEEETable, dw.block)
+
+	dw.writer.Write(blockHeader)
+	dw.writer.Write(dw.block)
+	_, err := dw.writer.Write(appendUint32(nil, checksum))
+	dw.blocks++
+	dw.blockCount = 0
+	dw.block = dw.block[:0]
+	return err
+}
+
+// close writes the last block and the trailer, then fills the count to header.
+// The file won't be closed.
+func (dw *dumpWriter) close() error {
+	if err := dw.flushBlock(); err != nil {
+		return err
+	}
+
+	trailer := make([]byte, 4, 28)
+	trailer = append(trailer, dumpTrailerMagic...)
+	trailer = appendUint64(trailer, dw.count)
+	trailer = appendUint64(trailer, dw.blocks)
+	trailer = appendUint32(trailer, crc32.ChecksumIEEE(trailer[4:]))
+	if _, err := dw.writer.Write(trailer); err != nil {
+		return err
+	}
+
+	for _, closer := range dw.closers {
+		if err := closer.Close(); err != nil {
+			return err
+		}
+	}
+
+	if err := dw.buffer.Flush(); err != nil {
+		return err
+	}
+
+	// The count is known after writing all entries, so fill it to header at last.
+	header := dw.header
+	binary.BigEndian.PutUint64(header[dumpCountOffset:], dw.count)
+	binary.BigEndian.PutUint32(header[len(header)-4:], crc32.ChecksumIEEE(header[:len(header)-4]))
+	_, err := dw.file.WriteAt(header, 0)
+	return err
+}
+
+// countingReader is a reader counting the bytes read.
+type countingReader struct {
+
+	// reader is the real reader.
+	reader io.Reader
+
+	// n is the number of bytes read.
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// dumpReader reads entries from a dump file one by one.
+// Only one block is kept in memory, so the memory used is constant.
+type dumpReader struct {
+
+	// reader is the buffered reader of blocks and trailer, which decrypts and decompresses data if needed.
+	reader *bufio.Reader
+
+	// buffer is the buffered reader of file.
+	buffer *bufio.Reader
+
+	// counter counts the bytes read from file.
+	counter *countingReader
+
+	// size is the size of file.
+	size int64
+
+	// limit is the max size of one block.
+	// It's larger than size if the file is compressed.
+	limit int64
+
+	// options is the options in header, which only has fields stored in config.
+	options Options
+
+	// seq is the seq in header.
+	seq uint64
+
+	// count is the number of entries in header.
+	count uint64
+
+	// read is the number of entries read.
+	read uint64
+
+	// blocks is the number of blocks read.
+	blocks uint64
+
+	// block is the buffer of current block.
+	block []byte
+
+	// entries reads the entries in current block.
+	entries *fieldReader
+
+	// blockLeft is the number of entries left in current block.
+	blockLeft uint32
+}
+
+// newDumpReader returns a dump reader of file and reads the header.
+// The compression and encryption are detected from the header, and the key in options is used to decrypt.
+func newDumpReader(file *os.File, options *Options) (*dumpReader, error) {
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	counter := &countingReader{reader: file}
+	buffer := bufio.NewReader(counter)
+	header := make([]byte, dumpHeaderFixedSize)
+	if _, err = io.ReadFull(buffer, header); err != nil {
+		return nil, corrupted("read header failed: %v", err)
+	}
+
+	if string(header[:4]) != dumpMagic {
+		return nil, corrupted("wrong magic %q", header[:4])
+	}
+
+	version := binary.BigEndian.Uint16(header[4:])
+	if version != dumpVersion {
+		return nil, corrupted("unsupported version %d", version)
+	}
+
+	configSize := int64(binary.BigEndian.Uint32(header[dumpCountOffset+8:]))
+	if configSize > fileInfo.Size() {
+		return nil, corrupted("wrong config size %d", configSize)
+	}
+
+	config := make([]byte, configSize+4)
+	if _, err = io.ReadFull(buffer, config); err != nil {
+		return nil, corrupted("read config failed: %v", err)
+	}
+
+	checksum := crc32.Update(crc32.ChecksumIEEE(header), crc32.IEEETable, config[:configSize])
+	if checksum != binary.BigEndian.Uint32(config[configSize:]) {
+		return nil, corrupted("header checksum mismatch")
+	}
+
+	dumped := Options{}
+	if !decodeConfig(config[:configSize], &dumped) {
+		return nil, corrupted("wrong config")
+	}
+
+	flags := binary.BigEndian.Uint16(header[6:])
+	var key []byte
+	if flags&encryptedFlag != 0 {
+		if key, err = dumpKey(options); err != nil {
+			return nil, err
+		}
+	}
+
+	body, err := newBodyReader(buffer, flags, key)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := fileInfo.Size()
+	if flags&(gzipFlag|flateFlag) != 0 {
+		limit *= maxCompressionRatio
+	}
+
+	return &dumpReader{
+		reader:  bufio.NewReader(body),
+		buffer:  buffer,
+		counter: counter,
+		size:    fileInfo.Size(),
+		limit:   limit,
+		options: dumped,
+		seq:     binary.BigEndian.Uint64(header[8:]),
+		count:   binary.BigEndian.Uint64(header[dumpCountOffset:]),
+		block:   make([]byte, 0, dumpBlockSize),
+		entries: &fieldReader{},
+	}, nil
+}
+
+// progress returns the progress of reading, which is from 0 to 1.
+func (dr *dumpReader) progress() float64 {
+	if dr.size <= 0 {
+		return 1
+	}
+	return float64(dr.counter.n-int64(dr.buffer.Buffered())) / float64(dr.size)
+}
+
+// next returns the next entry and an error if failed.
+// Returns io.EOF after reading all entries and verifying the trailer.
+func (dr *dumpReader) next() (string, *value, error) {
+	for dr.blockLeft <= 0 {
+		if len(dr.entries.data) > 0 {
+			return "", nil, corrupted("block %d has extra bytes", dr.blocks-1)
+		}
+
+		more, err := dr.readBlock()
+		if err != nil {
+			return "", nil, err
+		}
+
+		if !more {
+			return "", nil, dr.readTrailer()
+		}
+	}
+
+	key, value, ok := decodeEntry(dr.entries)
+	if !ok {
+		return "", nil, corrupted("decode entry in block %d failed", dr.blocks-1)
+	}
+
+	dr.blockLeft--
+	dr.read++
+	return key, value, nil
+}
+
+// readBlock reads the next block and returns false if there are no more blocks.
+func (dr *dumpReader) readBlock() (bool, error) {
+	blockHeader := make([]byte, 8)
+	if _, err := io.ReadFull(dr.reader, blockHeader[:4]); err != nil {
+		return false, corrupted("read block %d failed: %v", dr.blocks, err)
+	}
+
+	size := int64(binary.BigEndian.Uint32(blockHeader))
+	if size == 0 {
+		return false, nil
+	}
+
+	if size > dr.limit {
+		return false, corrupted("wrong size %d of block %d", size, dr.blocks)
+	}
+
+	if _, err := io.ReadFull(dr.reader, blockHeader[4:]); err != nil {
+		return false, corrupted("read block %d failed: %v", dr.blocks, err)
+	}
+
+	if int64(cap(dr.block)) < size+4 {
+		dr.block = make([]byte, size+4)
+	}
+
+	block := dr.block[:size+4]
+	if _, err := io.ReadFull(dr.reader, block); err != nil {
+		return false, corrupted("read block %d failed: %v", dr.blocks, err)
+	}
+
+	checksum := crc32.Update(crc32.ChecksumIEEE(blockHeader[4:]), crc32.IEEETable, block[:size])
+	if checksum != binary.BigEndian.Uint32(block[size:]) {
+		return false, corrupted("block %d checksum mismatch", dr.blocks)
+	}
+
+	dr.entries.data = block[:size]
+	dr.blockLeft = binary.BigEndian.Uint32(blockHeader[4:])
+	dr.blocks++
+	return true, nil
+}
+
+// readTrailer reads and verifies the trailer.
+// Returns io.EOF if the trailer is right.
+func (dr *dumpReader) readTrailer() error {
+	trailer := make([]byte, 24)
+	if _, err := io.ReadFull(dr.reader, trailer); err != nil {
+		return corrupted("read trailer failed: %v", err)
+	}
+
+	if string(trailer[:4]) != dumpTrailerMagic {
+		return corrupted("wrong trailer magic %q", trailer[:4])
+	}
+
+	if crc32.ChecksumIEEE(trailer[:20]) != binary.BigEndian.Uint32(trailer[20:]) {
+		return corrupted("trailer checksum mismatch")
+	}
+
+	if binary.BigEndian.Uint64(trailer[4:]) != dr.count || dr.read != dr.count {
+		return corrupted("entry count mismatch")
+	}
+
+	if binary.BigEndian.Uint64(trailer[12:]) != dr.blocks {
+		return corrupted("block count mismatch")
+	}
+	return io.EOF
+}
+
+// =======================================================================
+
+// dump is for dumping the cache.
+type dump struct {
 
 	// Options stores all options.
 	Options *Options
+
+	// Seq is the seq of the last record in append log when dumping.
+	// Records after this seq aren't guaranteed to be included in the dump.
+	Seq uint64
+
+	// cache is the cache to dump.
+	cache *Cache
 }
 
 // newEmptyDump return an empty dump holder.
@@ -33,12 +782,12 @@ func newEmptyDump() *dump {
 	return &dump{}
 }
 
-// newDump returns a dump holder of c.
-func newDump(c *Cache) *dump {
+// newDump returns a dump holder of c with seq of append log.
+func newDump(c *Cache, seq uint64) *dump {
 	return &dump{
-		SegmentSize: c.segmentSize,
-		Segments:    c.segments,
-		Options:     c.options,
+		Options: c.options,
+		Seq:     seq,
+		cache:   c,
 	}
 }
 
@@ -57,7 +806,11 @@ func (d *dump) to(dumpFile string) error {
 	}
 	defer file.Close()
 
-	err = gob.NewEncoder(file).Encode(d)
+	err = d.encode(file)
+	if err == nil {
+		err = file.Sync()
+	}
+
 	if err != nil {
 		file.Close()
 		os.Remove(newDumpFile)
@@ -69,8 +822,32 @@ func (d *dump) to(dumpFile string) error {
 	return os.Rename(newDumpFile, dumpFile)
 }
 
+// encode encodes d to file entry by entry and returns an error if failed.
+// Only one segment is frozen at a time, so other segments can be used during encoding.
+func (d *dump) encode(file *os.File) error {
+
+	writer, err := newDumpWriter(file, d.Options, d.Seq)
+	if err != nil {
+		return err
+	}
+
+	for i, segment := range d.cache.segments {
+		for key, value := range segment.snapshot() {
+			if err = writer.writeEntry(key, value); err != nil {
+				return err
+			}
+		}
+		atomic.StoreInt32(&d.cache.dumpedSegments, int32(i+1))
+	}
+	return writer.close()
+}
+
 // from returns a Cache holder parsed from d of dumpFile.
-func (d *dump) from(dumpFile string) (*Cache, error) {
+// Entries are restored one by one and the dead ones will be skipped.
+// The entries are rehashed by options, so the config in dumpFile won't affect the cache.
+// Returns an error satisfying os.IsNotExist if dumpFile doesn't exist and
+// an error wrapping corruptedDumpErr if dumpFile is corrupted.
+func (d *dump) from(dumpFile string, options Options) (*Cache, error) {
 
 	file, err := os.Open(dumpFile)
 	if err != nil {
@@ -78,19 +855,43 @@ func (d *dump) from(dumpFile string) (*Cache, error) {
 	}
 	defer file.Close()
 
-	if err = gob.NewDecoder(file).Decode(d); err != nil {
+	reader, err := newDumpReader(file, &options)
+	if err != nil {
 		return nil, err
 	}
 
-	for _, segment := range d.Segments {
-		segment.options = d.Options
-		segment.lock = &sync.RWMutex{}
+	warnConfigChanged(dumpFile, &reader.options, &options)
+	d.Options = &options
+	d.Seq = reader.seq
+	cache := newCache(options)
+
+	beginTime := time.Now()
+	skipped := 0
+	reported := 0
+	for {
+		key, value, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !value.alive(unixMillis(cache.options.Clock.Now())) {
+			skipped++
+			continue
+		}
+		cache.segmentOf(key).restore(key, value)
+
+		// Report the progress every 10 percent if the file is large.
+		if progress := int(reader.progress() * 10); reader.size >= dumpReportSize && progress > reported {
+			reported = progress
+			log.Printf("Recovering from dump file %s: %d%%.\n", dumpFile, progress*10)
+		}
 	}
 
-	return &Cache{
-		segmentSize: d.SegmentSize,
-		segments:    d.Segments,
-		options:     d.Options,
-		dumping:     0,
-	}, nil
+	log.Printf("Recovered %d entries from dump file %s in %v, and %d dead entries are skipped.\n",
+		reader.read-uint64(skipped), dumpFile, time.Since(beginTime), skipped)
+	return cache, nil
 }
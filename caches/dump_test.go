@@ -9,9 +9,14 @@
 package caches
 
 import (
+	"errors"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 )
 
 // go test -cover -run=^TestDump$
@@ -23,16 +28,16 @@ func TestDump(t *testing.T) {
 	}
 
 	dumpFile := filepath.Join(os.TempDir(), "TestDump.dump")
-	if err := newDump(cache).to(dumpFile); err != nil {
+	if err := newDump(cache, 0).to(dumpFile); err != nil {
 		t.Fatal(err)
 	}
 
-	cache, err := newEmptyDump().from(dumpFile)
+	cache, err := newEmptyDump().from(dumpFile, DefaultOptions())
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	value, ok := cache.Get("key")
+	value, ok, _ := cache.Get("key")
 	if !ok || string(value) != "value" {
 		t.Fatal("Get key from cache is wrong!", ok, string(value))
 	}
@@ -41,3 +46,143 @@ func TestDump(t *testing.T) {
 		t.Fatalf("The status of cache is wrong! Status is %+v.", cache.Status())
 	}
 }
+
+// go test -cover -run=^TestDumpCorrupted$
+func TestDumpCorrupted(t *testing.T) {
+
+	cache := NewCache()
+	for i := 0; i < 10000; i++ {
+		cache.Set("key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i)))
+	}
+
+	dumpFile := filepath.Join(os.TempDir(), "TestDumpCorrupted.dump")
+	if err := newDump(cache, 0).to(dumpFile); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(dumpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data[:4]) != dumpMagic || string(data[len(data)-24:len(data)-20]) != dumpTrailerMagic {
+		t.Fatalf("The magic of header %q or trailer %q is wrong!", data[:4], data[len(data)-24:len(data)-20])
+	}
+
+	_, err = newEmptyDump().from(filepath.Join(os.TempDir(), "TestDumpNotExist.dump"), DefaultOptions())
+	if !os.IsNotExist(err) {
+		t.Fatalf("Recover from a file not existing should return a not exist error but got %v!", err)
+	}
+
+	// Break the header, a block and the trailer in turn.
+	for _, offset := range []int{8, len(data) / 2, len(data) - 10} {
+		broken := make([]byte, len(data))
+		copy(broken, data)
+		broken[offset] ^= 0xFF
+
+		brokenFile := filepath.Join(os.TempDir(), "TestDumpCorrupted.broken.dump")
+		if err := ioutil.WriteFile(brokenFile, broken, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = newEmptyDump().from(brokenFile, DefaultOptions())
+		if !errors.Is(err, corruptedDumpErr) {
+			t.Fatalf("Recover from a file broken at %d should return a corrupted error but got %v!", offset, err)
+		}
+
+		options := DefaultOptions()
+		options.DumpFile = brokenFile
+		if _, err = NewCacheWith(options); err == nil {
+			t.Fatalf("NewCacheWith a file broken at %d should fail!", offset)
+		}
+	}
+}
+
+// go test -cover -run=^TestDumpReaderAndWriter$
+func TestDumpReaderAndWriter(t *testing.T) {
+
+	dumpFile := filepath.Join(os.TempDir(), "TestDumpReaderAndWriter.dump")
+	file, err := os.OpenFile(dumpFile, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	options := DefaultOptions()
+	options.SegmentSize = 16
+	writer, err := newDumpWriter(file, &options, 123)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write enough entries to fill more than one block.
+	count := 2 * dumpBlockSize / 10
+	for i := 0; i < count; i++ {
+		if err = writer.writeEntry("key"+strconv.Itoa(i), newValue([]byte(strconv.Itoa(i)), SetOptions{Ttl: time.Duration(i) * time.Millisecond}, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = writer.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if writer.blocks < 2 {
+		t.Fatalf("Entries should be written to more than one block, but only %d blocks!", writer.blocks)
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := newDumpReader(file, &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reader.options.SegmentSize != 16 || reader.seq != 123 || reader.count != uint64(count) {
+		t.Fatalf("The header read is wrong! SegmentSize is %d, seq is %d and count is %d.", reader.options.SegmentSize, reader.seq, reader.count)
+	}
+
+	for i := 0; i < count; i++ {
+		key, value, err := reader.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if key != "key"+strconv.Itoa(i) || string(value.Data) != strconv.Itoa(i) || value.Ttl != int64(i) {
+			t.Fatalf("The entry %d read is wrong! Key is %s and value is %+v.", i, key, value)
+		}
+	}
+
+	if _, _, err = reader.next(); err != io.EOF {
+		t.Fatalf("Reading after the last entry should return io.EOF but got %v!", err)
+	}
+
+	if reader.progress() != 1 {
+		t.Fatalf("The progress %f should be 1 after reading all entries!", reader.progress())
+	}
+}
+
+// go test -cover -run=^TestDumpSkipDeadEntries$
+func TestDumpSkipDeadEntries(t *testing.T) {
+
+	cache := NewCache()
+	cache.Set("key", []byte("value"))
+	cache.SetWithTTL("dead", []byte("value"), 1)
+	cache.segmentOf("dead").Data["dead"].Atime -= 10000
+
+	dumpFile := filepath.Join(os.TempDir(), "TestDumpSkipDeadEntries.dump")
+	if err := newDump(cache, 0).to(dumpFile); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := newEmptyDump().from(dumpFile, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, _ := cache.Get("key"); !ok || cache.Status().Count != 1 {
+		t.Fatalf("Dead entries should be skipped! Status is %+v.", cache.Status())
+	}
+}
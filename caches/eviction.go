@@ -0,0 +1,312 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/11/14 20:16:45
+
+package caches
+
+import (
+	"container/heap"
+	"container/list"
+	"math"
+)
+
+const (
+	// LRU evicts the least recently used entry first.
+	LRU = "lru"
+
+	// LFU evicts the least frequently used entry first.
+	LFU = "lfu"
+
+	// FIFO evicts the earliest inserted entry first.
+	FIFO = "fifo"
+
+	// Random evicts a random entry.
+	Random = "random"
+
+	// NearestTTL evicts the entry which is nearest to its death first.
+	NearestTTL = "ttl"
+
+	// TinyLFU evicts entries using a W-TinyLFU admission filter.
+	TinyLFU = "tinylfu"
+)
+
+// EvictionPolicy chooses the entry to evict when a segment is full.
+// Each segment has its own policy and all methods are called with the lock of segment held,
+// so a policy doesn't need to be concurrency safe.
+type EvictionPolicy interface {
+
+	// OnAccess is called after key is visited.
+	OnAccess(key string)
+
+	// OnInsert is called after key is added or updated.
+	OnInsert(key string)
+
+	// OnDelete is called after key is removed, no matter it's deleted, evicted or dead.
+	OnDelete(key string)
+
+	// Victim returns the key which should be evicted next and false if nothing can be evicted.
+	// The returned key will be removed and OnDelete will be called right after Victim returns.
+	Victim() (string, bool)
+}
+
+var (
+	// evictionPolicies stores all factories of policies mapping to their names.
+	evictionPolicies = map[string]func(s *segment) EvictionPolicy{
+		LRU: func(s *segment) EvictionPolicy {
+			return NewLRUPolicy()
+		},
+		LFU: func(s *segment) EvictionPolicy {
+			return NewLFUPolicy()
+		},
+		FIFO: func(s *segment) EvictionPolicy {
+			return NewFIFOPolicy()
+		},
+		TinyLFU: func(s *segment) EvictionPolicy {
+			return NewTinyLFUPolicy()
+		},
+		Random: func(s *segment) EvictionPolicy {
+			return &randomPolicy{segment: s}
+		},
+		NearestTTL: func(s *segment) EvictionPolicy {
+			return newPriorityPolicy(func(key string, oldPriority int64) int64 {
+				if value, ok := s.Data[key]; ok {
+					return value.deadline()
+				}
+				return math.MaxInt64
+			})
+		},
+	}
+)
+
+// RegisterEvictionPolicy registers a policy named name, so it can be used by setting Options.EvictionPolicy to name.
+// newPolicy will be called once for each segment. A registered policy with the same name will be replaced.
+// Notice that this function isn't concurrency safe, so call it before creating any caches.
+func RegisterEvictionPolicy(name string, newPolicy func() EvictionPolicy) {
+	evictionPolicies[name] = func(s *segment) EvictionPolicy {
+		return newPolicy()
+	}
+}
+
+// newEvictionPolicy returns a policy named name for segment s.
+// LRU will be used if name is unknown.
+func newEvictionPolicy(name string, s *segment) EvictionPolicy {
+	newPolicy, ok := evictionPolicies[name]
+	if !ok {
+		newPolicy = evictionPolicies[LRU]
+	}
+	return newPolicy(s)
+}
+
+// =======================================================================
+
+// listPolicy is a policy using a list ordered by time.
+// It works as LRU if moveOnAccess is true, otherwise it works as FIFO.
+type listPolicy struct {
+
+	// moveOnAccess means if moving the element to front when it's accessed or updated.
+	moveOnAccess bool
+
+	// keys stores all keys and the front one is the newest.
+	keys *list.List
+
+	// elements maps key to element in keys.
+	elements map[string]*list.Element
+}
+
+// NewLRUPolicy returns a policy evicting the least recently used entry first.
+func NewLRUPolicy() EvictionPolicy {
+	return newListPolicy(true)
+}
+
+// NewFIFOPolicy returns a policy evicting the earliest inserted entry first.
+func NewFIFOPolicy() EvictionPolicy {
+	return newListPolicy(false)
+}
+
+// newListPolicy returns a new list policy.
+func newListPolicy(moveOnAccess bool) *listPolicy {
+	return &listPolicy{
+		moveOnAccess: moveOnAccess,
+		keys:         list.New(),
+		elements:     map[string]*list.Element{},
+	}
+}
+
+func (lp *listPolicy) OnAccess(key string) {
+	if element, ok := lp.elements[key]; ok && lp.moveOnAccess {
+		lp.keys.MoveToFront(element)
+	}
+}
+
+func (lp *listPolicy) OnInsert(key string) {
+	if _, ok := lp.elements[key]; ok {
+		lp.OnAccess(key)
+		return
+	}
+	lp.elements[key] = lp.keys.PushFront(key)
+}
+
+func (lp *listPolicy) OnDelete(key string) {
+	if element, ok := lp.elements[key]; ok {
+		lp.keys.Remove(element)
+		delete(lp.elements, key)
+	}
+}
+
+func (lp *listPolicy) Victim() (string, bool) {
+	element := lp.keys.Back()
+	if element == nil {
+		return "", false
+	}
+	return element.Value.(string), true
+}
+
+// =======================================================================
+
+// randomPolicy is a policy choosing a random entry in segment.
+type randomPolicy struct {
+
+	// segment is the segment which entries belong to.
+	segment *segment
+}
+
+func (rp *randomPolicy) OnAccess(key string) {}
+
+func (rp *randomPolicy) OnInsert(key string) {}
+
+func (rp *randomPolicy) OnDelete(key string) {}
+
+func (rp *randomPolicy) Victim() (string, bool) {
+	// The iteration order of map is random so the first one is good enough.
+	for key := range rp.segment.Data {
+		return key, true
+	}
+	return "", false
+}
+
+// =======================================================================
+
+// priorityItem is an item in priorityQueue.
+type priorityItem struct {
+
+	// key is the key of entry.
+	key string
+
+	// priority is the priority of item and the lowest one will be evicted first.
+	priority int64
+
+	// seq is the order of updating, which is used to break ties.
+	seq uint64
+
+	// index is the position of item in queue.
+	index int
+}
+
+// priorityQueue is a min heap of priorityItem.
+type priorityQueue []*priorityItem
+
+func (pq priorityQueue) Len() int {
+	return len(pq)
+}
+
+func (pq priorityQueue) Less(i, j int) bool {
+	if pq[i].priority == pq[j].priority {
+		return pq[i].seq < pq[j].seq
+	}
+	return pq[i].priority < pq[j].priority
+}
+
+func (pq priorityQueue) Swap(i, j int) {
+	pq[i], pq[j] = pq[j], pq[i]
+	pq[i].index = i
+	pq[j].index = j
+}
+
+func (pq *priorityQueue) Push(x interface{}) {
+	item := x.(*priorityItem)
+	item.index = len(*pq)
+	*pq = append(*pq, item)
+}
+
+func (pq *priorityQueue) Pop() interface{} {
+	old := *pq
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*pq = old[:len(old)-1]
+	return item
+}
+
+// priorityPolicy is a policy which evicts the entry with the lowest priority.
+type priorityPolicy struct {
+
+	// queue is the heap of all items.
+	queue priorityQueue
+
+	// items maps key to item in queue.
+	items map[string]*priorityItem
+
+	// seq is the counter of updating.
+	seq uint64
+
+	// nextPriority returns the new priority of key after accessing or updating.
+	nextPriority func(key string, oldPriority int64) int64
+}
+
+// NewLFUPolicy returns a policy evicting the least frequently used entry first.
+// The least recently used one will be evicted if some entries have the same frequency.
+func NewLFUPolicy() EvictionPolicy {
+	return newPriorityPolicy(func(key string, oldPriority int64) int64 {
+		return oldPriority + 1
+	})
+}
+
+// newPriorityPolicy returns a new priority policy using nextPriority.
+func newPriorityPolicy(nextPriority func(key string, oldPriority int64) int64) *priorityPolicy {
+	return &priorityPolicy{
+		items:        map[string]*priorityItem{},
+		nextPriority: nextPriority,
+	}
+}
+
+func (pp *priorityPolicy) OnAccess(key string) {
+	if item, ok := pp.items[key]; ok {
+		pp.seq++
+		item.priority = pp.nextPriority(key, item.priority)
+		item.seq = pp.seq
+		heap.Fix(&pp.queue, item.index)
+	}
+}
+
+func (pp *priorityPolicy) OnInsert(key string) {
+	if _, ok := pp.items[key]; ok {
+		pp.OnAccess(key)
+		return
+	}
+
+	pp.seq++
+	item := &priorityItem{
+		key:      key,
+		priority: pp.nextPriority(key, 0),
+		seq:      pp.seq,
+	}
+	pp.items[key] = item
+	heap.Push(&pp.queue, item)
+}
+
+func (pp *priorityPolicy) OnDelete(key string) {
+	if item, ok := pp.items[key]; ok {
+		heap.Remove(&pp.queue, item.index)
+		delete(pp.items, key)
+	}
+}
+
+func (pp *priorityPolicy) Victim() (string, bool) {
+	if len(pp.queue) <= 0 {
+		return "", false
+	}
+	return pp.queue[0].key, true
+}
@@ -0,0 +1,198 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/11/14 21:03:27
+
+package caches
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestSegment returns a segment which can store 1024 bytes with policy.
+func newTestSegment(policy string) *segment {
+	options := DefaultOptions()
+	options.MaxEntrySize = 1
+	options.SegmentSize = 1024
+	options.EvictionPolicy = policy
+	return newSegment(&options)
+}
+
+// fillTestSegment sets entries of 100 bytes to s from key0 to key{count-1}.
+func fillTestSegment(t *testing.T, s *segment, count int, ttl func(i int) int64) {
+	for i := 0; i < count; i++ {
+		key := "key" + strconv.Itoa(i)
+		if err := s.set(key, make([]byte, 100-len(key)), SetOptions{Ttl: time.Duration(ttl(i)) * time.Second}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// neverDie is a ttl function returning NeverDie.
+func neverDie(i int) int64 {
+	return NeverDie
+}
+
+// go test -cover -run=^TestEvictionPolicies$
+func TestEvictionPolicies(t *testing.T) {
+
+	for _, policy := range []string{LRU, LFU, FIFO, Random, NearestTTL, TinyLFU} {
+		s := newTestSegment(policy)
+		fillTestSegment(t, s, 100, neverDie)
+
+		status := s.status()
+		if status.entrySize() > s.maxEntrySize() {
+			t.Fatalf("Policy %s: entry size %d exceeds %d!", policy, status.entrySize(), s.maxEntrySize())
+		}
+
+		if status.Count != 10 || status.Evictions != 90 {
+			t.Fatalf("Policy %s: status %+v is wrong!", policy, status)
+		}
+
+		if len(s.Data) != status.Count {
+			t.Fatalf("Policy %s: %d entries in data but count is %d!", policy, len(s.Data), status.Count)
+		}
+	}
+
+	s := newTestSegment(LRU)
+	if err := s.set("key", make([]byte, 1024), SetOptions{}); err == nil {
+		t.Fatal("Setting an entry bigger than segment should fail!")
+	}
+}
+
+// go test -cover -run=^TestEvictionVictims$
+func TestEvictionVictims(t *testing.T) {
+
+	cases := []struct {
+		policy  string
+		ttl     func(i int) int64
+		access  bool
+		evicted string
+		kept    string
+	}{
+		{policy: LRU, ttl: neverDie, access: true, evicted: "key1", kept: "key0"},
+		{policy: LFU, ttl: neverDie, access: true, evicted: "key1", kept: "key0"},
+		{policy: FIFO, ttl: neverDie, access: true, evicted: "key0", kept: "key1"},
+		{policy: TinyLFU, ttl: neverDie, access: true, evicted: "key9", kept: "key0"},
+		{
+			policy:  NearestTTL,
+			ttl:     func(i int) int64 { return int64(1000 - i) },
+			access:  false,
+			evicted: "key9",
+			kept:    "key0",
+		},
+	}
+
+	for _, c := range cases {
+		s := newTestSegment(c.policy)
+		fillTestSegment(t, s, 10, c.ttl)
+		if c.access {
+			for i := 0; i < 3; i++ {
+				if _, ok, _ := s.get("key0"); !ok {
+					t.Fatalf("Policy %s: key0 should exist!", c.policy)
+				}
+			}
+		}
+
+		if err := s.set("key10", make([]byte, 95), SetOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok, _ := s.get(c.evicted); ok {
+			t.Fatalf("Policy %s: %s should be evicted!", c.policy, c.evicted)
+		}
+
+		if _, ok, _ := s.get(c.kept); !ok {
+			t.Fatalf("Policy %s: %s should be kept!", c.policy, c.kept)
+		}
+	}
+}
+
+// go test -cover -run=^TestEvictionWorkload$
+func TestEvictionWorkload(t *testing.T) {
+
+	minHitRatios := map[string]float64{
+		LRU:        0.75,
+		LFU:        0.75,
+		FIFO:       0,
+		Random:     0,
+		NearestTTL: 0,
+		TinyLFU:    0.75,
+	}
+
+	for policy, minHitRatio := range minHitRatios {
+		s := newTestSegment(policy)
+		random := rand.New(rand.NewSource(1))
+
+		// 80% of requests visit 5 hot keys and the others visit 95 cold keys.
+		hits := 0
+		requests := 10000
+		for i := 0; i < requests; i++ {
+			key := "key" + strconv.Itoa(5+random.Intn(95))
+			if random.Intn(100) < 80 {
+				key = "key" + strconv.Itoa(random.Intn(5))
+			}
+
+			if _, ok, _ := s.get(key); ok {
+				hits++
+				continue
+			}
+
+			if err := s.set(key, make([]byte, 100-len(key)), SetOptions{}); err != nil {
+				t.Fatalf("Policy %s: %v", policy, err)
+			}
+		}
+
+		status := s.status()
+		if status.entrySize() > s.maxEntrySize() || len(s.Data) != status.Count {
+			t.Fatalf("Policy %s: status %+v is wrong!", policy, status)
+		}
+
+		hitRatio := float64(hits) / float64(requests)
+		t.Logf("Policy %s: hit ratio is %.4f and evictions is %d.", policy, hitRatio, status.Evictions)
+		if hitRatio < minHitRatio {
+			t.Fatalf("Policy %s: hit ratio %.4f is less than %.4f!", policy, hitRatio, minHitRatio)
+		}
+	}
+}
+
+// testPolicy is a policy which always evicts the key named "victim" first.
+type testPolicy struct {
+	EvictionPolicy
+}
+
+func (tp *testPolicy) Victim() (string, bool) {
+	return "victim", true
+}
+
+// go test -cover -run=^TestRegisterEvictionPolicy$
+func TestRegisterEvictionPolicy(t *testing.T) {
+
+	RegisterEvictionPolicy("test", func() EvictionPolicy {
+		return &testPolicy{EvictionPolicy: NewLRUPolicy()}
+	})
+
+	s := newTestSegment("test")
+	if err := s.set("victim", make([]byte, 94), SetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	fillTestSegment(t, s, 9, neverDie)
+
+	if err := s.set("key9", make([]byte, 96), SetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, _ := s.get("victim"); ok {
+		t.Fatal("victim should be evicted!")
+	}
+
+	if _, ok, _ := s.get("key0"); !ok {
+		t.Fatal("key0 should be kept!")
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/12/13 16:25:08
+
+package caches
+
+import (
+	"container/heap"
+	"math"
+)
+
+// expiryIndex is a min heap of entries ordered by their deadlines.
+// Only entries which will die are indexed, and entries never dying aren't in it.
+// Deadlines of sliding entries are extended after visiting without updating the index,
+// so an entry found alive at its old deadline should be updated again.
+type expiryIndex struct {
+
+	// queue is the heap of all items, and the priority of item is the deadline of entry.
+	queue priorityQueue
+
+	// items maps key to item in queue.
+	items map[string]*priorityItem
+}
+
+// newExpiryIndex returns an empty expiry index.
+func newExpiryIndex() *expiryIndex {
+	return &expiryIndex{
+		items: map[string]*priorityItem{},
+	}
+}
+
+// update indexes key with the deadline of value.
+// The key will be removed if value never dies.
+func (ei *expiryIndex) update(key string, value *value) {
+	deadline := value.deadline()
+	if deadline == math.MaxInt64 {
+		ei.remove(key)
+		return
+	}
+
+	if item, ok := ei.items[key]; ok {
+		item.priority = deadline
+		heap.Fix(&ei.queue, item.index)
+		return
+	}
+
+	item := &priorityItem{
+		key:      key,
+		priority: deadline,
+	}
+	ei.items[key] = item
+	heap.Push(&ei.queue, item)
+}
+
+// remove removes key from index.
+func (ei *expiryIndex) remove(key string) {
+	if item, ok := ei.items[key]; ok {
+		heap.Remove(&ei.queue, item.index)
+		delete(ei.items, key)
+	}
+}
+
+// peek returns the key with the nearest deadline and its deadline.
+// Returns false if index is empty.
+func (ei *expiryIndex) peek() (string, int64, bool) {
+	if len(ei.queue) <= 0 {
+		return "", 0, false
+	}
+	return ei.queue[0].key, ei.queue[0].priority, true
+}
+
+// len returns the number of keys in index.
+func (ei *expiryIndex) len() int {
+	return len(ei.queue)
+}
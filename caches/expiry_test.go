@@ -0,0 +1,71 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/12/13 17:40:51
+
+package caches
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// go test -cover -run=^TestSegmentExpire$
+func TestSegmentExpire(t *testing.T) {
+
+	clock := NewFakeClock(time.Now())
+	options := DefaultOptions()
+	options.Clock = clock
+	s := newSegment(&options)
+	for i := 0; i < 100; i++ {
+		s.set("dead"+strconv.Itoa(i), []byte("value"), SetOptions{Ttl: 50 * time.Millisecond, Expiration: Absolute})
+	}
+
+	s.set("sliding", []byte("value"), SetOptions{Ttl: 80 * time.Millisecond, Expiration: Sliding})
+	s.set("forever", []byte("value"), SetOptions{Ttl: NeverDie})
+	s.set("later", []byte("value"), SetOptions{Ttl: time.Minute, Expiration: Absolute})
+
+	if s.expiry.len() != 102 {
+		t.Fatalf("Only entries which will die should be indexed, but %d entries are indexed!", s.expiry.len())
+	}
+
+	if count := s.expire(10); count != 0 {
+		t.Fatalf("No entries should be expired before deadlines, but %d entries are expired!", count)
+	}
+
+	clock.Add(60 * time.Millisecond)
+	s.get("sliding")
+
+	if count := s.expire(10); count != 10 {
+		t.Fatalf("Only 10 entries should be expired in one task, but %d entries are expired!", count)
+	}
+
+	if count := s.expire(1000); count != 90 {
+		t.Fatalf("90 entries should be expired, but %d entries are expired!", count)
+	}
+
+	if _, ok := s.Data["sliding"]; !ok {
+		t.Fatal("Sliding entry visited should be alive!")
+	}
+
+	clock.Add(100 * time.Millisecond)
+	if count := s.expire(1000); count != 1 {
+		t.Fatalf("Sliding entry should be expired, but %d entries are expired!", count)
+	}
+
+	if s.Status.Count != 2 || s.Status.Expired != 101 || s.expiry.len() != 1 {
+		t.Fatalf("Status %+v or expiry index with %d entries is wrong!", s.Status, s.expiry.len())
+	}
+
+	// Overwriting or deleting an entry should update the index.
+	s.set("later", []byte("value"), SetOptions{Ttl: NeverDie})
+	s.set("forever", []byte("value"), SetOptions{Ttl: time.Minute})
+	s.delete("forever")
+	if s.expiry.len() != 0 {
+		t.Fatalf("Expiry index should be empty, but %d entries are indexed!", s.expiry.len())
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/12/21 20:36:52
+
+package caches
+
+import (
+	"strconv"
+
+	"github.com/avino-plan/kafo/helpers"
+)
+
+// encodeHash returns the bytes of hash.
+// It's the count of fields followed by fields and their data, and each of them has a uvarint size before it.
+func encodeHash(hash map[string][]byte) []byte {
+	buffer := appendUvarint(nil, uint64(len(hash)))
+	for field, data := range hash {
+		buffer = appendUvarint(buffer, uint64(len(field)))
+		buffer = append(buffer, field...)
+		buffer = appendUvarint(buffer, uint64(len(data)))
+		buffer = append(buffer, data...)
+	}
+	return buffer
+}
+
+// decodeHash returns the hash decoded from data and false if failed.
+// The hash is never nil, so the value is still a hash even if it has no fields.
+func decodeHash(data []byte) (map[string][]byte, bool) {
+	reader := &fieldReader{data: data}
+	count, ok := reader.readUvarint()
+	if !ok || count > uint64(len(data)) {
+		return nil, false
+	}
+
+	hash := make(map[string][]byte, count)
+	for i := uint64(0); i < count; i++ {
+		field, ok := reader.readBytes()
+		if !ok {
+			return nil, false
+		}
+
+		fieldData, ok := reader.readBytes()
+		if !ok {
+			return nil, false
+		}
+		hash[string(field)] = helpers.Copy(fieldData)
+	}
+	return hash, true
+}
+
+// copyHash returns a copy of hash.
+// Data of fields is shared because it's never modified.
+func copyHash(hash map[string][]byte) map[string][]byte {
+	copied := make(map[string][]byte, len(hash))
+	for field, data := range hash {
+		copied[field] = data
+	}
+	return copied
+}
+
+// sizeOfFields returns the size of fields and their data.
+func sizeOfFields(fields map[string][]byte) int64 {
+	size := int64(0)
+	for field, data := range fields {
+		size += int64(len(field)) + int64(len(data))
+	}
+	return size
+}
+
+// deltaOfFields returns how much the size of hash changes after setting fields, or deleting them if del is true.
+func deltaOfFields(hash map[string][]byte, fields map[string][]byte, del bool) int64 {
+	delta := int64(0)
+	for field, data := range fields {
+		if oldData, ok := hash[field]; ok {
+			delta -= int64(len(field)) + int64(len(oldData))
+		}
+
+		if !del {
+			delta += int64(len(field)) + int64(len(data))
+		}
+	}
+	return delta
+}
+
+// applyFields sets fields to hash, or deletes them from hash if del is true.
+func applyFields(hash map[string][]byte, fields map[string][]byte, del bool) {
+	for field, data := range fields {
+		if del {
+			delete(hash, field)
+			continue
+		}
+		hash[field] = data
+	}
+}
+
+// =======================================================================
+
+// updateHash sets fields returned by fieldsOf to the hash of specified key in place, or deletes them if del is true.
+// The hash given to fieldsOf is nil if the key doesn't exist, and it shouldn't be modified.
+// Fields to delete should exist in the hash, and the key will be deleted if no fields are left.
+// A key not existing is an empty hash which never dies, and the new hash is logged by a set record.
+// Otherwise, only the changed fields are logged, so the cost doesn't depend on the size of hash.
+// Returns false if no fields are changed, and wrongTypeErr if the key isn't a hash.
+func (s *segment) updateHash(key string, del bool, fieldsOf func(hash map[string][]byte) (map[string][]byte, error)) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	var hash map[string][]byte
+	oldValue, existed := s.aliveValue(key, now)
+	if existed {
+		if err := oldValue.checkType(hashType); err != nil {
+			return false, err
+		}
+		hash = oldValue.Hash
+	}
+
+	fields, err := fieldsOf(hash)
+	if err != nil || len(fields) <= 0 {
+		return false, err
+	}
+
+	if del && len(fields) >= len(hash) {
+		return s.erase(key)
+	}
+
+	if !existed {
+		newValue := newValue(nil, SetOptions{Ttl: NeverDie}, now)
+		newValue.Hash = fields
+		newValue.countFieldsSize()
+		if err := s.put(key, newValue); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	log := func(version uint64) error {
+		if del {
+			return s.log.appendHashDelete(key, version, fields)
+		}
+		return s.log.appendHashSet(key, version, fields)
+	}
+
+	change := func() {
+		applyFields(hash, fields, del)
+	}
+
+	if err := s.putInPlace(key, oldValue, deltaOfFields(hash, fields, del), log, change); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// readHash runs read with the hash of specified key after looking it up, and the hash is nil if the key doesn't exist.
+// The read runs under the read lock, and it shouldn't modify the hash or keep it after returning.
+// Returns wrongTypeErr if the key isn't a hash.
+func (s *segment) readHash(key string, read func(hash map[string][]byte)) error {
+	var err error
+	ok := s.lookup(key, func(value *value) {
+		if err = value.checkType(hashType); err == nil {
+			read(value.Hash)
+		}
+	})
+
+	if !ok {
+		read(nil)
+	}
+	return err
+}
+
+// =======================================================================
+
+// HSet sets fields of the hash of specified key and returns the count of new fields.
+// The hash will be created and never die if the key doesn't exist, otherwise its ttl is kept.
+// Returns an error if the key isn't a hash.
+func (c *Cache) HSet(key string, fields map[string][]byte) (int, error) {
+	count := 0
+	err := c.write(key, func(s *segment) (bool, error) {
+		return s.updateHash(key, false, func(hash map[string][]byte) (map[string][]byte, error) {
+			copied := make(map[string][]byte, len(fields))
+			for field, data := range fields {
+				if _, ok := hash[field]; !ok {
+					count++
+				}
+				copied[field] = helpers.Copy(data)
+			}
+			return copied, nil
+		})
+	})
+	return count, err
+}
+
+// HGet returns the data of field in the hash of specified key.
+// Returns false if the key or field doesn't exist, and an error if the key isn't a hash.
+func (c *Cache) HGet(key string, field string) ([]byte, bool, error) {
+	var data []byte
+	ok := false
+	err := c.readHash(key, func(hash map[string][]byte) {
+		data, ok = hash[field]
+	})
+	return data, ok, err
+}
+
+// HDel deletes fields of the hash of specified key and returns the count of deleted fields.
+// The key will be deleted if no fields are left.
+// Returns an error if the key isn't a hash.
+func (c *Cache) HDel(key string, fields ...string) (int, error) {
+	count := 0
+	err := c.write(key, func(s *segment) (bool, error) {
+		return s.updateHash(key, true, func(hash map[string][]byte) (map[string][]byte, error) {
+			deleted := make(map[string][]byte, len(fields))
+			for _, field := range fields {
+				if data, ok := hash[field]; ok {
+					deleted[field] = data
+				}
+			}
+
+			count = len(deleted)
+			return deleted, nil
+		})
+	})
+	return count, err
+}
+
+// HGetAll returns a copy of all fields in the hash of specified key.
+// Returns an empty map if the key doesn't exist, and an error if the key isn't a hash.
+func (c *Cache) HGetAll(key string) (map[string][]byte, error) {
+	var fields map[string][]byte
+	err := c.readHash(key, func(hash map[string][]byte) {
+		fields = copyHash(hash)
+	})
+	return fields, err
+}
+
+// HIncrBy adds delta to the decimal int64 data of field in the hash of specified key and returns the result.
+// A field not existing is treated as 0.
+// Returns an error if the key isn't a hash or the data of field isn't an integer.
+func (c *Cache) HIncrBy(key string, field string, delta int64) (int64, error) {
+	result := int64(0)
+	err := c.write(key, func(s *segment) (bool, error) {
+		return s.updateHash(key, false, func(hash map[string][]byte) (map[string][]byte, error) {
+			var err error
+			result, err = addInt(hash[field], delta)
+			if err != nil {
+				return nil, err
+			}
+			return map[string][]byte{field: []byte(strconv.FormatInt(result, 10))}, nil
+		})
+	})
+	return result, err
+}
+
+// HLen returns the count of fields in the hash of specified key.
+// Returns 0 if the key doesn't exist, and an error if the key isn't a hash.
+func (c *Cache) HLen(key string) (int, error) {
+	count := 0
+	err := c.readHash(key, func(hash map[string][]byte) {
+		count = len(hash)
+	})
+	return count, err
+}
+
+// readHash runs read with the hash of specified key, and the hash is nil if the key doesn't exist.
+// Returns cacheClosedErr if cache is closed, and wrongTypeErr if the key isn't a hash.
+func (c *Cache) readHash(key string, read func(hash map[string][]byte)) error {
+	c.closeLock.RLock()
+	defer c.closeLock.RUnlock()
+	if c.closed {
+		return cacheClosedErr
+	}
+	return c.segmentOf(key).readHash(key, read)
+}
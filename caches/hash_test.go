@@ -0,0 +1,214 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/12/21 21:48:05
+
+package caches
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// go test -cover -run=^TestCacheHash$
+func TestCacheHash(t *testing.T) {
+
+	cache, clock := newTestCache(DefaultOptions())
+	count, err := cache.HSet("user", map[string][]byte{"name": []byte("fish"), "age": []byte("18")})
+	if err != nil || count != 2 {
+		t.Fatalf("HSet should add 2 fields but got %d and %v!", count, err)
+	}
+
+	if count, _ = cache.HSet("user", map[string][]byte{"name": []byte("goddess"), "city": []byte("sz")}); count != 1 {
+		t.Fatalf("HSet should add 1 new field but got %d!", count)
+	}
+
+	if data, ok, err := cache.HGet("user", "name"); !ok || err != nil || string(data) != "goddess" {
+		t.Fatalf("HGet name should return goddess but got %s, %v and %v!", string(data), ok, err)
+	}
+
+	if status := cache.Status(); status.Count != 1 || status.ValueSize != int64(len("namegoddessage18citysz")) {
+		t.Fatalf("Fields should count toward status sizes, but status is %+v!", status)
+	}
+
+	if result, err := cache.HIncrBy("user", "age", 2); err != nil || result != 20 {
+		t.Fatalf("HIncrBy age should return 20 but got %d and %v!", result, err)
+	}
+
+	if _, err := cache.HIncrBy("user", "name", 1); err != notAnIntegerErr {
+		t.Fatalf("HIncrBy name should return notAnIntegerErr but got %v!", err)
+	}
+
+	fields, err := cache.HGetAll("user")
+	if err != nil || len(fields) != 3 || string(fields["age"]) != "20" {
+		t.Fatalf("HGetAll returns wrong fields %v and %v!", fields, err)
+	}
+
+	// Fields returned shouldn't affect the hash.
+	fields["new"] = []byte("value")
+	if length, err := cache.HLen("user"); err != nil || length != 3 {
+		t.Fatalf("HLen should return 3 but got %d and %v!", length, err)
+	}
+
+	if count, err = cache.HDel("user", "city", "notExist"); err != nil || count != 1 {
+		t.Fatalf("HDel should delete 1 field but got %d and %v!", count, err)
+	}
+
+	if fields, err = cache.HGetAll("notExist"); err != nil || len(fields) != 0 {
+		t.Fatalf("HGetAll a key not existing should return nothing but got %v and %v!", fields, err)
+	}
+
+	// Types of values should be checked.
+	cache.Set("string", []byte("value"))
+	if _, err = cache.HSet("string", map[string][]byte{"field": nil}); !errors.Is(err, wrongTypeErr) {
+		t.Fatalf("HSet a string should return wrongTypeErr but got %v!", err)
+	}
+
+	if _, ok, err := cache.Get("user"); ok || !errors.Is(err, wrongTypeErr) {
+		t.Fatalf("Get a hash should return wrongTypeErr but got %v and %v!", ok, err)
+	}
+
+	if _, ok, err := cache.Peek("user"); ok || !errors.Is(err, wrongTypeErr) {
+		t.Fatalf("Peek a hash should return wrongTypeErr but got %v and %v!", ok, err)
+	}
+
+	if _, err = cache.Incr("user"); !errors.Is(err, wrongTypeErr) {
+		t.Fatalf("Incr a hash should return wrongTypeErr but got %v!", err)
+	}
+
+	// The hash expires as a whole, and it's deleted when no fields are left.
+	if ok, err := cache.Expire("user", time.Second); !ok || err != nil {
+		t.Fatalf("Expire user should return true but got %v and %v!", ok, err)
+	}
+
+	cache.HSet("user", map[string][]byte{"city": []byte("gz")})
+	clock.Add(time.Second)
+	if length, _ := cache.HLen("user"); length != 0 {
+		t.Fatalf("Hash user should be dead but it has %d fields!", length)
+	}
+
+	cache.HSet("temp", map[string][]byte{"field": []byte("value")})
+	cache.HDel("temp", "field")
+	if status := cache.Status(); status.Count != 1 || status.ValueSize != int64(len("value")) {
+		t.Fatalf("Empty hash should be deleted, but status is %+v!", status)
+	}
+
+	// A hash evicted to make room for its own fields should be stored again with all fields.
+	options := DefaultOptions()
+	options.MaxEntrySize = 1
+	options.EvictionPolicy = LRU
+	cache, _ = newTestCache(options)
+	other := "other"
+	for i := 0; cache.segmentOf(other) != cache.segmentOf("hash"); i++ {
+		other = "other" + strconv.Itoa(i)
+	}
+
+	cache.HSet("hash", map[string][]byte{"a": make([]byte, 400)})
+	cache.Set(other, make([]byte, 300))
+	cache.Get(other)
+	if _, err = cache.HSet("hash", map[string][]byte{"b": make([]byte, 400)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if length, _ := cache.HLen("hash"); length != 2 {
+		t.Fatalf("Hash should have 2 fields after being stored again but got %d!", length)
+	}
+
+	if status := cache.Status(); status.Count != 1 || status.ValueSize != 802 || status.Evictions != 2 {
+		t.Fatalf("Status %+v is wrong after evicting!", status)
+	}
+}
+
+// go test -cover -run=^TestCacheHashPersistence$
+func TestCacheHashPersistence(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpFile = filepath.Join(os.TempDir(), "TestCacheHashPersistence.dump")
+	options.AppendFile = filepath.Join(os.TempDir(), "TestCacheHashPersistence.aof")
+	options.AppendOnly = true
+	os.Remove(options.DumpFile)
+	os.Remove(options.AppendFile)
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.HSet("dumped", map[string][]byte{"field": []byte("value")})
+	if err = cache.dump(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes of the dumped hash are logged by fields and replayed on the hash restored from dump file.
+	cache.HSet("dumped", map[string][]byte{"more": []byte("data")})
+	cache.HDel("dumped", "more")
+	cache.HSet("logged", map[string][]byte{"a": []byte("1"), "b": []byte("2")})
+	cache.HDel("logged", "a")
+	cache.HIncrBy("logged", "c", 3)
+
+	// Changing a field of a large hash only logs the field.
+	large := make(map[string][]byte, 200)
+	for i := 0; i < 200; i++ {
+		large["field"+strconv.Itoa(i)] = []byte("value")
+	}
+
+	if _, err = cache.HSet("large", large); err != nil {
+		t.Fatal(err)
+	}
+
+	size := cache.log.currentSize()
+	cache.HSet("large", map[string][]byte{"field0": []byte("changed")})
+	cache.HDel("large", "field1")
+	if logged := cache.log.currentSize() - size; logged > 200 {
+		t.Fatalf("Changing 2 fields of a large hash logs %d bytes!", logged)
+	}
+
+	status := cache.Status()
+	version := cache.segmentOf("logged").version
+	if err = cache.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close(context.Background())
+
+	if data, ok, _ := cache.HGet("dumped", "field"); !ok || string(data) != "value" {
+		t.Fatalf("Hash should be recovered from dump file but got %s and %v!", string(data), ok)
+	}
+
+	if length, _ := cache.HLen("dumped"); length != 1 {
+		t.Fatalf("Hash dumped should have 1 field after replaying but got %d!", length)
+	}
+
+	fields, err := cache.HGetAll("logged")
+	if err != nil || len(fields) != 2 || string(fields["b"]) != "2" || string(fields["c"]) != "3" {
+		t.Fatalf("Hash should be recovered from append file but got %v and %v!", fields, err)
+	}
+
+	if data, ok, _ := cache.HGet("large", "field0"); !ok || string(data) != "changed" {
+		t.Fatalf("Field of large hash should be recovered but got %s and %v!", string(data), ok)
+	}
+
+	if length, _ := cache.HLen("large"); length != 199 {
+		t.Fatalf("Hash large should have 199 fields but got %d!", length)
+	}
+
+	if recovered := cache.Status(); recovered.Count != status.Count || recovered.ValueSize != status.ValueSize {
+		t.Fatalf("Status %+v of recovered hashes should be %+v!", recovered, status)
+	}
+
+	if recovered := cache.segmentOf("logged").version; recovered != version {
+		t.Fatalf("Version %d of recovered segment should be %d!", recovered, version)
+	}
+}
@@ -0,0 +1,473 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/12/23 20:15:37
+
+package caches
+
+import (
+	"context"
+	"time"
+
+	"github.com/avino-plan/kafo/helpers"
+)
+
+// encodeList returns the bytes of list.
+// It's the count of items followed by items, and each of them has a uvarint size before it.
+func encodeList(list [][]byte) []byte {
+	buffer := appendUvarint(nil, uint64(len(list)))
+	for _, item := range list {
+		buffer = appendUvarint(buffer, uint64(len(item)))
+		buffer = append(buffer, item...)
+	}
+	return buffer
+}
+
+// decodeList returns the list decoded from data and false if failed.
+// The list is never nil, so the value is still a list even if it has no items.
+func decodeList(data []byte) ([][]byte, bool) {
+	reader := &fieldReader{data: data}
+	count, ok := reader.readUvarint()
+	if !ok || count > uint64(len(data)) {
+		return nil, false
+	}
+
+	list := make([][]byte, 0, count)
+	for i := uint64(0); i < count; i++ {
+		item, ok := reader.readBytes()
+		if !ok {
+			return nil, false
+		}
+		list = append(list, helpers.Copy(item))
+	}
+	return list, true
+}
+
+// rangeOf returns the range [from, to) of items between start and stop in a list of length.
+// Both start and stop are inclusive, and negative ones count from the tail, so -1 is the last item.
+func rangeOf(length int, start int, stop int) (int, int) {
+	if start < 0 {
+		start += length
+	}
+
+	if stop < 0 {
+		stop += length
+	}
+
+	if start < 0 {
+		start = 0
+	}
+
+	if stop >= length {
+		stop = length - 1
+	}
+
+	if start > stop {
+		return 0, 0
+	}
+	return start, stop + 1
+}
+
+// sizeOfItems returns the size of items.
+func sizeOfItems(items [][]byte) int64 {
+	size := int64(0)
+	for _, item := range items {
+		size += int64(len(item))
+	}
+	return size
+}
+
+// pushItems pushes items to the list of value in place, to the head if left is true, otherwise to the tail.
+// Items are pushed one by one, so the last item will be the head after pushing to the head.
+// The room before the head is kept in listBuffer and grows with the list, so pushing to the head costs O(1) amortized
+// like pushing to the tail.
+func pushItems(value *value, items [][]byte, left bool) {
+	if !left {
+		if len(value.List)+len(items) > cap(value.List) {
+			// Appending will move the list to a new array, and no room is before its head then.
+			value.listBuffer = nil
+		}
+		value.List = append(value.List, items...)
+		return
+	}
+
+	front := 0
+	if value.listBuffer != nil {
+		front = cap(value.listBuffer) - cap(value.List)
+	}
+
+	length := len(value.List)
+	if front < len(items) {
+		front = len(items) + length
+		value.listBuffer = make([][]byte, front+length)
+		copy(value.listBuffer[front:], value.List)
+	}
+
+	for _, item := range items {
+		front--
+		value.listBuffer[front] = item
+	}
+	value.List = value.listBuffer[front : front+length+len(items)]
+}
+
+// popItem removes and returns the head of the list of value in place if left is true, otherwise the tail.
+// The list shouldn't be empty.
+func popItem(value *value, left bool) []byte {
+	last := len(value.List) - 1
+	if left {
+		item := value.List[0]
+		value.List[0] = nil
+		value.List = value.List[1:]
+		return item
+	}
+
+	item := value.List[last]
+	value.List[last] = nil
+	value.List = value.List[:last]
+	return item
+}
+
+// =======================================================================
+
+// listOf returns the alive list value of specified key without locking, and false if the key doesn't exist.
+// Returns an error wrapping wrongTypeErr if the key isn't a list.
+func (s *segment) listOf(key string, now int64) (*value, bool, error) {
+	value, ok := s.aliveValue(key, now)
+	if !ok {
+		return nil, false, nil
+	}
+
+	if err := value.checkType(listType); err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// push pushes items to the head of the list of specified key if left is true, otherwise to the tail.
+// A key not existing is an empty list which never dies, and the new list is logged by a set record.
+// Otherwise, only the pushed items are logged, so the cost doesn't depend on the length of list.
+// Returns the length of list after pushing, and blocking pops waiting for this key will be woken up.
+func (s *segment) push(key string, items [][]byte, left bool) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	oldValue, ok, err := s.listOf(key, now)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(items) <= 0 {
+		if !ok {
+			return 0, nil
+		}
+		return len(oldValue.List), nil
+	}
+
+	copied := make([][]byte, len(items))
+	for i, item := range items {
+		copied[i] = helpers.Copy(item)
+	}
+
+	if !ok {
+		newValue := newValue(nil, SetOptions{Ttl: NeverDie}, now)
+		pushItems(newValue, copied, left)
+		newValue.countFieldsSize()
+		if err := s.put(key, newValue); err != nil {
+			return 0, err
+		}
+
+		s.wake(key)
+		return len(newValue.List), nil
+	}
+
+	log := func(version uint64) error {
+		return s.log.appendListPush(key, version, left, copied)
+	}
+
+	change := func() {
+		pushItems(oldValue, copied, left)
+	}
+
+	if err := s.putInPlace(key, oldValue, sizeOfItems(copied), log, change); err != nil {
+		return 0, err
+	}
+
+	s.wake(key)
+	return len(oldValue.List), nil
+}
+
+// pop removes and returns the head of the list of specified key if left is true, otherwise the tail.
+// Only the side popped is logged, and the key will be deleted if no items are left.
+// If the list is empty and wait is true, a channel closed when items are pushed to this key will be returned.
+func (s *segment) pop(key string, left bool, wait bool) ([]byte, bool, chan struct{}, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	oldValue, ok, err := s.listOf(key, s.now())
+	if err != nil {
+		return nil, false, nil, err
+	}
+
+	if !ok {
+		if !wait {
+			return nil, false, nil, nil
+		}
+
+		waiter := make(chan struct{})
+		s.waiters[key] = append(s.waiters[key], waiter)
+		return nil, false, waiter, nil
+	}
+
+	if len(oldValue.List) <= 1 {
+		item := oldValue.List[0]
+		if _, err := s.erase(key); err != nil {
+			return nil, false, nil, err
+		}
+		return item, true, nil, nil
+	}
+
+	index := len(oldValue.List) - 1
+	if left {
+		index = 0
+	}
+
+	var item []byte
+	log := func(version uint64) error {
+		return s.log.appendListPop(key, version, left)
+	}
+
+	change := func() {
+		item = popItem(oldValue, left)
+	}
+
+	if err := s.putInPlace(key, oldValue, -int64(len(oldValue.List[index])), log, change); err != nil {
+		return nil, false, nil, err
+	}
+	return item, true, nil, nil
+}
+
+// trim keeps items between start and stop in the list of specified key, and other items will be removed.
+// The list kept is copied and logged by a set record, and the key will be deleted if no items are left.
+// Returns false if no items are removed.
+func (s *segment) trim(key string, start int, stop int) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	oldValue, ok, err := s.listOf(key, s.now())
+	if !ok || err != nil {
+		return false, err
+	}
+
+	from, to := rangeOf(len(oldValue.List), start, stop)
+	if to-from >= len(oldValue.List) {
+		return false, nil
+	}
+
+	if from >= to {
+		return s.erase(key)
+	}
+
+	newValue := *oldValue
+	newValue.List = make([][]byte, to-from)
+	newValue.listBuffer = nil
+	copy(newValue.List, oldValue.List[from:to])
+	newValue.countFieldsSize()
+	if err := s.put(key, &newValue); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// wake wakes up all blocking pops waiting for specified key without locking.
+func (s *segment) wake(key string) {
+	for _, waiter := range s.waiters[key] {
+		close(waiter)
+	}
+	delete(s.waiters, key)
+}
+
+// unwait removes the waiter of specified key, so it won't be woken up any more.
+func (s *segment) unwait(key string, waiter chan struct{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	waiters := s.waiters[key]
+	for i, w := range waiters {
+		if w == waiter {
+			waiters = append(waiters[:i:i], waiters[i+1:]...)
+			break
+		}
+	}
+
+	if len(waiters) <= 0 {
+		delete(s.waiters, key)
+		return
+	}
+	s.waiters[key] = waiters
+}
+
+// readList runs read with the list of specified key after looking it up, and the list is nil if the key doesn't exist.
+// The read runs under the read lock, and it shouldn't modify the list or keep it after returning.
+// Returns an error wrapping wrongTypeErr if the key isn't a list.
+func (s *segment) readList(key string, read func(list [][]byte)) error {
+	var err error
+	ok := s.lookup(key, func(value *value) {
+		if err = value.checkType(listType); err == nil {
+			read(value.List)
+		}
+	})
+
+	if !ok {
+		read(nil)
+	}
+	return err
+}
+
+// =======================================================================
+
+// LPush pushes items to the head of the list of specified key one by one and returns the length of list.
+// The list will be created and never die if the key doesn't exist, otherwise its ttl is kept.
+// Returns an error if the key isn't a list.
+func (c *Cache) LPush(key string, items ...[]byte) (int, error) {
+	return c.push(key, items, true)
+}
+
+// RPush pushes items to the tail of the list of specified key one by one and returns the length of list.
+// The list will be created and never die if the key doesn't exist, otherwise its ttl is kept.
+// Returns an error if the key isn't a list.
+func (c *Cache) RPush(key string, items ...[]byte) (int, error) {
+	return c.push(key, items, false)
+}
+
+// push pushes items to the list of specified key and returns the length of list.
+func (c *Cache) push(key string, items [][]byte, left bool) (int, error) {
+	length := 0
+	err := c.write(key, func(s *segment) (bool, error) {
+		var err error
+		length, err = s.push(key, items, left)
+		return err == nil && len(items) > 0, err
+	})
+	return length, err
+}
+
+// LPop removes and returns the head of the list of specified key.
+// The key will be deleted if no items are left.
+// Returns false if the list is empty, and an error if the key isn't a list.
+func (c *Cache) LPop(key string) ([]byte, bool, error) {
+	return c.pop(key, true)
+}
+
+// RPop removes and returns the tail of the list of specified key.
+// The key will be deleted if no items are left.
+// Returns false if the list is empty, and an error if the key isn't a list.
+func (c *Cache) RPop(key string) ([]byte, bool, error) {
+	return c.pop(key, false)
+}
+
+// pop removes and returns the head or tail of the list of specified key.
+func (c *Cache) pop(key string, left bool) ([]byte, bool, error) {
+	var item []byte
+	popped := false
+	err := c.write(key, func(s *segment) (bool, error) {
+		var err error
+		item, popped, _, err = s.pop(key, left, false)
+		return popped, err
+	})
+	return item, popped, err
+}
+
+// BLPop is LPop but it waits until an item is pushed if the list is empty.
+// It stops waiting after timeout and returns false, and it never times out if timeout isn't positive.
+// Returns ctx.Err() if ctx is done, and cacheClosedErr if cache is closed while waiting.
+func (c *Cache) BLPop(ctx context.Context, key string, timeout time.Duration) ([]byte, bool, error) {
+	return c.blockingPop(ctx, key, timeout, true)
+}
+
+// BRPop is RPop but it waits until an item is pushed if the list is empty.
+// It stops waiting after timeout and returns false, and it never times out if timeout isn't positive.
+// Returns ctx.Err() if ctx is done, and cacheClosedErr if cache is closed while waiting.
+func (c *Cache) BRPop(ctx context.Context, key string, timeout time.Duration) ([]byte, bool, error) {
+	return c.blockingPop(ctx, key, timeout, false)
+}
+
+// blockingPop pops the list of specified key, and waits for pushing if the list is empty.
+// All waiters of a key are woken up by pushing and they pop again, so nobody misses an item.
+// The timeout is measured by the clock in options.
+func (c *Cache) blockingPop(ctx context.Context, key string, timeout time.Duration, left bool) ([]byte, bool, error) {
+	var timer <-chan time.Time
+	if timeout > 0 {
+		var stop func()
+		timer, stop = c.options.Clock.After(timeout)
+		defer stop()
+	}
+
+	for {
+		var item []byte
+		var waiter chan struct{}
+		popped := false
+		err := c.write(key, func(s *segment) (bool, error) {
+			var err error
+			item, popped, waiter, err = s.pop(key, left, true)
+			return popped, err
+		})
+
+		if popped || err != nil {
+			return item, popped, err
+		}
+
+		select {
+		case <-waiter:
+		case <-timer:
+			c.segmentOf(key).unwait(key, waiter)
+			return nil, false, nil
+		case <-ctx.Done():
+			c.segmentOf(key).unwait(key, waiter)
+			return nil, false, ctx.Err()
+		case <-c.closing:
+			return nil, false, cacheClosedErr
+		}
+	}
+}
+
+// LRange returns items between start and stop in the list of specified key.
+// Both start and stop are inclusive, and negative ones count from the tail, so LRange(key, 0, -1) returns all items.
+// Returns an error if the key isn't a list.
+func (c *Cache) LRange(key string, start int, stop int) ([][]byte, error) {
+	var items [][]byte
+	err := c.readList(key, func(list [][]byte) {
+		from, to := rangeOf(len(list), start, stop)
+		items = make([][]byte, to-from)
+		copy(items, list[from:to])
+	})
+	return items, err
+}
+
+// LLen returns the length of the list of specified key.
+// Returns 0 if the key doesn't exist, and an error if the key isn't a list.
+func (c *Cache) LLen(key string) (int, error) {
+	length := 0
+	err := c.readList(key, func(list [][]byte) {
+		length = len(list)
+	})
+	return length, err
+}
+
+// LTrim keeps items between start and stop in the list of specified key, and other items will be removed.
+// Indexes are the same as LRange, and the key will be deleted if no items are left.
+// Returns an error if the key isn't a list.
+func (c *Cache) LTrim(key string, start int, stop int) error {
+	return c.write(key, func(s *segment) (bool, error) {
+		return s.trim(key, start, stop)
+	})
+}
+
+// readList runs read with the list of specified key, and the list is nil if the key doesn't exist.
+// Returns cacheClosedErr if cache is closed, and an error wrapping wrongTypeErr if the key isn't a list.
+func (c *Cache) readList(key string, read func(list [][]byte)) error {
+	c.closeLock.RLock()
+	defer c.closeLock.RUnlock()
+	if c.closed {
+		return cacheClosedErr
+	}
+	return c.segmentOf(key).readList(key, read)
+}
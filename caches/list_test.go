@@ -0,0 +1,299 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/12/23 21:32:40
+
+package caches
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// joinItems joins items with comma, so lists can be compared easily.
+func joinItems(items [][]byte) string {
+	strs := make([]string, 0, len(items))
+	for _, item := range items {
+		strs = append(strs, string(item))
+	}
+	return strings.Join(strs, ",")
+}
+
+// go test -cover -run=^TestCacheList$
+func TestCacheList(t *testing.T) {
+
+	cache, _ := newTestCache(DefaultOptions())
+	if length, err := cache.RPush("list", []byte("c"), []byte("d")); err != nil || length != 2 {
+		t.Fatalf("RPush should return length 2 but got %d and %v!", length, err)
+	}
+
+	if length, err := cache.LPush("list", []byte("b"), []byte("a")); err != nil || length != 4 {
+		t.Fatalf("LPush should return length 4 but got %d and %v!", length, err)
+	}
+
+	if items, err := cache.LRange("list", 0, -1); err != nil || joinItems(items) != "a,b,c,d" {
+		t.Fatalf("LRange all items returns wrong items %s and %v!", joinItems(items), err)
+	}
+
+	if items, _ := cache.LRange("list", -3, 1); joinItems(items) != "b" {
+		t.Fatalf("LRange -3 to 1 returns wrong items %s!", joinItems(items))
+	}
+
+	if items, _ := cache.LRange("list", 3, 1); len(items) != 0 {
+		t.Fatalf("LRange 3 to 1 should return nothing but got %s!", joinItems(items))
+	}
+
+	if status := cache.Status(); status.Count != 1 || status.ValueSize != int64(len("abcd")) {
+		t.Fatalf("Items should count toward status sizes, but status is %+v!", status)
+	}
+
+	if item, ok, err := cache.LPop("list"); !ok || err != nil || string(item) != "a" {
+		t.Fatalf("LPop should return a but got %s, %v and %v!", string(item), ok, err)
+	}
+
+	if item, ok, err := cache.RPop("list"); !ok || err != nil || string(item) != "d" {
+		t.Fatalf("RPop should return d but got %s, %v and %v!", string(item), ok, err)
+	}
+
+	// Pushing after popping shouldn't overwrite items seen by old lists.
+	items, _ := cache.LRange("list", 0, -1)
+	cache.RPush("list", []byte("e"))
+	if joinItems(items) != "b,c" {
+		t.Fatalf("Items %s got before pushing are changed!", joinItems(items))
+	}
+
+	if err := cache.LTrim("list", 1, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	if length, err := cache.LLen("list"); err != nil || length != 2 {
+		t.Fatalf("LLen after trimming should return 2 but got %d and %v!", length, err)
+	}
+
+	cache.LPop("list")
+	cache.LPop("list")
+	if _, ok, err := cache.LPop("list"); ok || err != nil {
+		t.Fatalf("LPop an empty list should return false but got %v and %v!", ok, err)
+	}
+
+	if status := cache.Status(); status.Count != 0 || status.ValueSize != 0 {
+		t.Fatalf("Empty list should be deleted, but status is %+v!", status)
+	}
+
+	// Type errors should tell what the key holds.
+	cache.Set("string", []byte("value"))
+	_, err := cache.LPush("string", []byte("item"))
+	if !errors.Is(err, wrongTypeErr) || !strings.Contains(err.Error(), "expected a list but the key holds a string") {
+		t.Fatalf("LPush a string should return a clear type error but got %v!", err)
+	}
+
+	if _, _, err = cache.RPop("string"); !errors.Is(err, wrongTypeErr) {
+		t.Fatalf("RPop a string should return wrongTypeErr but got %v!", err)
+	}
+
+	cache.RPush("list", []byte("item"))
+	if _, err = cache.HLen("list"); !errors.Is(err, wrongTypeErr) {
+		t.Fatalf("HLen a list should return wrongTypeErr but got %v!", err)
+	}
+
+	if _, _, ok, err := cache.GetWithVersion("list"); ok || !errors.Is(err, wrongTypeErr) {
+		t.Fatalf("GetWithVersion a list should return wrongTypeErr but got %v and %v!", ok, err)
+	}
+
+	if _, err := cache.MGet([]string{"string", "list"}); !errors.Is(err, wrongTypeErr) {
+		t.Fatalf("MGet a list should return wrongTypeErr but got %v!", err)
+	}
+}
+
+// waitForWaiters waits until count blocking pops are waiting for key.
+func waitForWaiters(cache *Cache, key string, count int) {
+	s := cache.segmentOf(key)
+	for {
+		s.lock.RLock()
+		waiting := len(s.waiters[key])
+		s.lock.RUnlock()
+		if waiting >= count {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// go test -cover -run=^TestCacheBlockingPop$
+func TestCacheBlockingPop(t *testing.T) {
+
+	cache, clock := newTestCache(DefaultOptions())
+	cache.RPush("queue", []byte("ready"))
+	if item, ok, err := cache.BLPop(context.Background(), "queue", time.Second); !ok || err != nil || string(item) != "ready" {
+		t.Fatalf("BLPop a list with items should return ready but got %s, %v and %v!", string(item), ok, err)
+	}
+
+	// Timeouts are measured by the clock of cache.
+	timedOut := make(chan bool, 1)
+	go func() {
+		_, ok, err := cache.BRPop(context.Background(), "queue", 10*time.Millisecond)
+		timedOut <- !ok && err == nil
+	}()
+
+	waitForWaiters(cache, "queue", 1)
+	clock.Add(10 * time.Millisecond)
+	if !<-timedOut {
+		t.Fatal("BRPop should time out after the clock moves!")
+	}
+
+	if len(cache.segmentOf("queue").waiters) != 0 || len(clock.timers) != 0 {
+		t.Fatal("Waiters and timers should be removed after timing out!")
+	}
+
+	items := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			item, _, _ := cache.BLPop(context.Background(), "queue", 0)
+			items <- string(item)
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	cache.RPush("queue", []byte("a"))
+	cache.RPush("queue", []byte("b"))
+	if got := []string{<-items, <-items}; got[0]+got[1] != "ab" && got[0]+got[1] != "ba" {
+		t.Fatalf("Blocking pops should get a and b but got %v!", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := cache.BLPop(ctx, "queue", 0); err != context.Canceled {
+		t.Fatalf("BLPop with a canceled context should return context.Canceled but got %v!", err)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := cache.BLPop(context.Background(), "queue", 0)
+		errs <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cache.Close(context.Background())
+	if err := <-errs; err != cacheClosedErr {
+		t.Fatalf("BLPop should return cacheClosedErr after closing but got %v!", err)
+	}
+}
+
+// go test -cover -run=^TestCacheListPersistence$
+func TestCacheListPersistence(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpFile = filepath.Join(os.TempDir(), "TestCacheListPersistence.dump")
+	options.AppendFile = filepath.Join(os.TempDir(), "TestCacheListPersistence.aof")
+	options.AppendOnly = true
+	os.Remove(options.DumpFile)
+	os.Remove(options.AppendFile)
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.RPush("dumped", []byte("a"), []byte("b"))
+	if err = cache.dump(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pushes and pops of the dumped list are logged alone and replayed on the list restored from dump file.
+	cache.LPush("dumped", []byte("0"))
+	cache.RPop("dumped")
+	cache.RPush("logged", []byte("1"), []byte("2"), []byte("3"))
+	cache.LPop("logged")
+	cache.LPush("logged", []byte("x"), []byte("y"))
+	cache.RPop("logged")
+
+	// Pushing to and popping from a long list only logs the items changed.
+	for i := 0; i < 200; i++ {
+		cache.RPush("queue", []byte("item"+strconv.Itoa(i)))
+	}
+
+	size := cache.log.currentSize()
+	cache.RPush("queue", []byte("new"))
+	cache.LPop("queue")
+	if logged := cache.log.currentSize() - size; logged > 200 {
+		t.Fatalf("Pushing and popping a long list logs %d bytes!", logged)
+	}
+
+	status := cache.Status()
+	version := cache.segmentOf("queue").version
+	if err = cache.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close(context.Background())
+
+	if items, _ := cache.LRange("dumped", 0, -1); joinItems(items) != "0,a" {
+		t.Fatalf("List should be recovered from dump file but got %s!", joinItems(items))
+	}
+
+	if items, _ := cache.LRange("logged", 0, -1); joinItems(items) != "y,x,2" {
+		t.Fatalf("List should be recovered from append file but got %s!", joinItems(items))
+	}
+
+	if items, _ := cache.LRange("queue", 0, 0); joinItems(items) != "item1" {
+		t.Fatalf("Head of queue should be item1 but got %s!", joinItems(items))
+	}
+
+	if length, _ := cache.LLen("queue"); length != 200 {
+		t.Fatalf("Queue should have 200 items but got %d!", length)
+	}
+
+	if recovered := cache.Status(); recovered.Count != status.Count || recovered.ValueSize != status.ValueSize {
+		t.Fatalf("Status %+v of recovered lists should be %+v!", recovered, status)
+	}
+
+	if recovered := cache.segmentOf("queue").version; recovered != version {
+		t.Fatalf("Version %d of recovered segment should be %d!", recovered, version)
+	}
+}
+
+// go test -cover -run=^TestPushItems$
+func TestPushItems(t *testing.T) {
+
+	// Compare with a list changed by copying, so the room kept before the head is checked.
+	v := &value{}
+	var expected [][]byte
+	for i := 0; i < 1000; i++ {
+		item := []byte(strconv.Itoa(i))
+		switch i % 5 {
+		case 0, 1:
+			pushItems(v, [][]byte{item, item}, true)
+			expected = append([][]byte{item, item}, expected...)
+		case 2:
+			pushItems(v, [][]byte{item}, false)
+			expected = append(expected, item)
+		case 3:
+			if popped := popItem(v, true); string(popped) != string(expected[0]) {
+				t.Fatalf("Pop the head should return %s but got %s!", expected[0], popped)
+			}
+			expected = expected[1:]
+		default:
+			if popped := popItem(v, false); string(popped) != string(expected[len(expected)-1]) {
+				t.Fatalf("Pop the tail should return %s but got %s!", expected[len(expected)-1], popped)
+			}
+			expected = expected[:len(expected)-1]
+		}
+
+		if joinItems(v.List) != joinItems(expected) {
+			t.Fatalf("List is %s but it should be %s!", joinItems(v.List), joinItems(expected))
+		}
+	}
+}
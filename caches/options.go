@@ -8,6 +8,51 @@
 
 package caches
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SaveRule means dumping if at least Changes writes happened in Seconds.
+type SaveRule struct {
+
+	// Seconds is the seconds since last save.
+	Seconds int
+
+	// Changes is the min number of writes since last save.
+	Changes int
+}
+
+// ParseSaveRules parses rules like "60:1000,300:10" to save rules.
+// Each rule is seconds and changes separated by a colon, and an empty string returns nil.
+func ParseSaveRules(rules string) ([]SaveRule, error) {
+	if rules == "" {
+		return nil, nil
+	}
+
+	var saveRules []SaveRule
+	for _, rule := range strings.Split(rules, ",") {
+		parts := strings.Split(strings.TrimSpace(rule), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("save rule %q should be seconds:changes", rule)
+		}
+
+		seconds, err := strconv.Atoi(parts[0])
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("seconds of save rule %q is invalid", rule)
+		}
+
+		changes, err := strconv.Atoi(parts[1])
+		if err != nil || changes <= 0 {
+			return nil, fmt.Errorf("changes of save rule %q is invalid", rule)
+		}
+
+		saveRules = append(saveRules, SaveRule{Seconds: seconds, Changes: changes})
+	}
+	return saveRules, nil
+}
+
 // Options is the struct of options.
 type Options struct {
 
@@ -22,13 +67,68 @@ type Options struct {
 	// The unit is Minute.
 	GcDuration int
 
+	// MaxExpireCount is the max count of entries checked in one segment by one active expiration task.
+	MaxExpireCount int
+
+	// ExpireDuration is the duration between two active expiration tasks.
+	// Active expiration removes dead entries in the order of their deadlines.
+	// The unit is Millisecond.
+	ExpireDuration int
+
 	// DumpFile is the file used to dump the cache.
 	DumpFile string
 
+	// DumpDir is the directory storing snapshots.
+	// If it's set, every dump creates a timestamped snapshot named by DumpFile in it instead of overwriting DumpFile.
+	DumpDir string
+
+	// DumpRetainCount is the number of latest snapshots to keep in DumpDir.
+	// Snapshots matching DumpRetainCount or DumpRetainAge will be kept, and all of them will be kept if both are 0.
+	DumpRetainCount int
+
+	// DumpRetainAge is the max age of snapshots to keep in DumpDir.
+	// The unit is Hour.
+	DumpRetainAge int
+
+	// DumpCompression is the compression of dump files.
+	// The value should be one of none, gzip and flate.
+	DumpCompression string
+
+	// DumpKeyFile is the file storing the key used to encrypt dump files by AES-GCM.
+	// The key is a hex string of 16, 24 or 32 bytes, and dump files won't be encrypted if there are no keys.
+	DumpKeyFile string
+
+	// DumpKeyEnv is the name of environment variable storing the key, which is used if DumpKeyFile is empty.
+	DumpKeyEnv string
+
+	// DumpOnClose means if doing a final dump when the cache is closed.
+	DumpOnClose bool
+
 	// DumpDuration is the duration between two dump tasks.
+	// The dump is skipped if nothing is written since last save.
 	// The unit is Minute.
 	DumpDuration int
 
+	// SaveRules are the rules which trigger a dump by the number of writes.
+	// A dump is done if any of them matches.
+	SaveRules []SaveRule
+
+	// AppendOnly means if recording every write operation to AppendFile.
+	// The records after the last dump will be replayed when the cache starts.
+	AppendOnly bool
+
+	// AppendFile is the file used to record write operations.
+	AppendFile string
+
+	// AppendFsync is the policy of syncing AppendFile to disk.
+	// The value should be one of always, everysec and no.
+	AppendFsync string
+
+	// AppendRewriteSize is the size of AppendFile which triggers a rewrite.
+	// A rewrite dumps the cache and drops records included in the dump file.
+	// The unit is MB.
+	AppendRewriteSize int
+
 	// MapSizeOfSegment is the map size of segment.
 	MapSizeOfSegment int
 
@@ -38,19 +138,45 @@ type Options struct {
 
 	// CasSleepTime is the time of sleep in one cas step.
 	// The unit is Microsecond.
+	//
+	// Deprecated: Operations don't wait for dumping any more, so it's not used.
 	CasSleepTime int
+
+	// Clock tells the time used for expiration, gc and dump timestamps.
+	// The system clock will be used if it's nil.
+	Clock Clock
+
+	// EvictionPolicy is the policy used to evict entries when memory is full.
+	// The value should be one of lru, lfu, fifo, random, ttl, tinylfu and names registered by RegisterEvictionPolicy.
+	EvictionPolicy string
 }
 
 // DefaultOptions returns a default options.
 func DefaultOptions() Options {
 	return Options{
-		MaxEntrySize:     4, // 4 GB
-		MaxGcCount:       10,
-		GcDuration:       60, // 1 hour
-		DumpFile:         "kafo.dump",
-		DumpDuration:     30, // 30 minutes
-		MapSizeOfSegment: 256,
-		SegmentSize:      1024,
-		CasSleepTime:     1000, // 1 ms
+		MaxEntrySize:      4, // 4 GB
+		MaxGcCount:        10,
+		GcDuration:        60, // 1 hour
+		MaxExpireCount:    20,
+		ExpireDuration:    100, // 100 milliseconds
+		DumpFile:          "kafo.dump",
+		DumpDir:           "",
+		DumpRetainCount:   3,
+		DumpRetainAge:     0,
+		DumpCompression:   CompressionNone,
+		DumpKeyFile:       "",
+		DumpKeyEnv:        "",
+		DumpOnClose:       false,
+		DumpDuration:      30, // 30 minutes
+		SaveRules:         nil,
+		AppendOnly:        false,
+		AppendFile:        "kafo.aof",
+		AppendFsync:       FsyncEverySecond,
+		AppendRewriteSize: 64, // 64 MB
+		MapSizeOfSegment:  256,
+		SegmentSize:       1024,
+		CasSleepTime:      1000, // 1 ms
+		Clock:             SystemClock(),
+		EvictionPolicy:    LRU,
 	}
 }
@@ -0,0 +1,277 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/12/20 15:12:47
+
+package caches
+
+import (
+	"encoding/base64"
+	"errors"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultScanCount is the count of keys returned by one scan if count isn't positive.
+	defaultScanCount = 10
+
+	// keyIndexMaxLevel is the max level of nodes in key index, which is enough for 4^16 keys in one segment.
+	keyIndexMaxLevel = 16
+)
+
+var (
+	// invalidCursorErr means the cursor of scan is invalid.
+	invalidCursorErr = errors.New("the cursor of scan is invalid")
+)
+
+// matchKey returns if key matches pattern.
+// The syntax of pattern is the same as path.Match, and an empty pattern matches all keys.
+func matchKey(pattern string, key string) (bool, error) {
+	if pattern == "" {
+		return true, nil
+	}
+	return path.Match(pattern, key)
+}
+
+// keyNode is a node of key index.
+type keyNode struct {
+
+	// key is the key of node, and it's empty in the head of index.
+	key string
+
+	// next are the next nodes in all levels of this node, and next[0] is the next key in order.
+	next []*keyNode
+}
+
+// keyIndex keeps keys of a segment in order by a skip list, so scanning doesn't need to sort them.
+// Adding and removing a key cost O(log n), and a scan can start from any key in O(log n).
+type keyIndex struct {
+
+	// head is the node before all keys.
+	head *keyNode
+
+	// level is the highest level of all nodes.
+	level int
+
+	// seed is the state of random levels.
+	seed uint64
+}
+
+// newKeyIndex returns an empty key index.
+func newKeyIndex() *keyIndex {
+	return &keyIndex{
+		head:  &keyNode{next: make([]*keyNode, keyIndexMaxLevel)},
+		level: 1,
+		seed:  uint64(time.Now().UnixNano()) | 1,
+	}
+}
+
+// randomLevel returns the level of a new node, and each level is a quarter as likely as the one below it.
+func (ki *keyIndex) randomLevel() int {
+	// This is xorshift, which is enough for skip lists and much smaller than a rand.Rand in each segment.
+	ki.seed ^= ki.seed << 13
+	ki.seed ^= ki.seed >> 7
+	ki.seed ^= ki.seed << 17
+
+	level := 1
+	for random := ki.seed; level < keyIndexMaxLevel && random&3 == 0; random >>= 2 {
+		level++
+	}
+	return level
+}
+
+// search returns the first node with a key not less than key.
+// The last nodes before it in all levels will be stored to previous if previous isn't nil.
+func (ki *keyIndex) search(key string, previous []*keyNode) *keyNode {
+	node := ki.head
+	for i := ki.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].key < key {
+			node = node.next[i]
+		}
+
+		if previous != nil {
+			previous[i] = node
+		}
+	}
+	return node.next[0]
+}
+
+// add adds key to index if it isn't in index.
+func (ki *keyIndex) add(key string) {
+	previous := make([]*keyNode, keyIndexMaxLevel)
+	if node := ki.search(key, previous); node != nil && node.key == key {
+		return
+	}
+
+	level := ki.randomLevel()
+	for i := ki.level; i < level; i++ {
+		previous[i] = ki.head
+	}
+
+	if level > ki.level {
+		ki.level = level
+	}
+
+	node := &keyNode{key: key, next: make([]*keyNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = previous[i].next[i]
+		previous[i].next[i] = node
+	}
+}
+
+// remove removes key from index.
+func (ki *keyIndex) remove(key string) {
+	previous := make([]*keyNode, keyIndexMaxLevel)
+	node := ki.search(key, previous)
+	if node == nil || node.key != key {
+		return
+	}
+
+	for i := 0; i < len(node.next); i++ {
+		previous[i].next[i] = node.next[i]
+	}
+
+	for ki.level > 1 && ki.head.next[ki.level-1] == nil {
+		ki.level--
+	}
+}
+
+// from returns the node of the first key to scan, and nil if there are no keys to scan.
+// It's the first key if started is false, otherwise the first key greater than after.
+// Keys after it can be visited by next[0] one by one.
+func (ki *keyIndex) from(after string, started bool) *keyNode {
+	if !started {
+		return ki.head.next[0]
+	}
+
+	node := ki.search(after, nil)
+	if node != nil && node.key == after {
+		return node.next[0]
+	}
+	return node
+}
+
+// scanCursor is the position of scan, which is the index of segment and the last key returned in it.
+type scanCursor struct {
+
+	// segment is the index of segment to scan.
+	segment int
+
+	// started is if some keys in segment have been returned.
+	// It's needed because an empty key can be returned, so an empty after doesn't mean a new start.
+	started bool
+
+	// after is the last key returned in segment, so keys after it will be returned next time.
+	after string
+}
+
+// encode returns the string of cursor.
+// It's the index of segment, followed by a colon and the encoded after if the segment is started.
+func (sc scanCursor) encode() string {
+	if !sc.started {
+		return strconv.Itoa(sc.segment)
+	}
+	return strconv.Itoa(sc.segment) + ":" + base64.RawURLEncoding.EncodeToString([]byte(sc.after))
+}
+
+// decodeScanCursor returns the cursor of string and an error if failed.
+// An empty string means starting a new scan.
+func decodeScanCursor(cursor string, segmentSize int) (scanCursor, error) {
+	if cursor == "" {
+		return scanCursor{}, nil
+	}
+
+	started := true
+	colon := strings.IndexByte(cursor, ':')
+	if colon < 0 {
+		started = false
+		colon = len(cursor)
+	}
+
+	segment, err := strconv.Atoi(cursor[:colon])
+	if err != nil || segment < 0 || segment >= segmentSize {
+		return scanCursor{}, invalidCursorErr
+	}
+
+	if !started {
+		return scanCursor{segment: segment}, nil
+	}
+
+	after, err := base64.RawURLEncoding.DecodeString(cursor[colon+1:])
+	if err != nil {
+		return scanCursor{}, invalidCursorErr
+	}
+	return scanCursor{segment: segment, started: true, after: string(after)}, nil
+}
+
+// DeleteMatching deletes all keys matching pattern and returns the count of deleted keys.
+// Segments are cleaned one at a time, so keys set during deleting may be kept.
+// Keys are matched under the read lock, and only deleting them takes the write lock.
+// The syntax of pattern is the same as path.Match, and an empty pattern matches all keys.
+func (c *Cache) DeleteMatching(pattern string) (int, error) {
+	if _, err := matchKey(pattern, ""); err != nil {
+		return 0, err
+	}
+
+	return c.writeAll(func(s *segment) (int, error) {
+		return s.deleteMatching(pattern)
+	})
+}
+
+// FlushAll deletes all keys and returns the count of deleted keys.
+// Segments are cleaned one at a time without matching any keys.
+func (c *Cache) FlushAll() (int, error) {
+	return c.writeAll(func(s *segment) (int, error) {
+		return s.flush()
+	})
+}
+
+// Scan returns at most count keys matching pattern from cursor and the cursor for next scan.
+// Start a scan with an empty cursor, and it's finished when the returned cursor is empty.
+// Segments are scanned one at a time, so only one segment is locked at any moment.
+// Keys existing during the whole scan are returned exactly once, and dead keys are skipped.
+// The syntax of pattern is the same as path.Match, and an empty pattern matches all keys.
+func (c *Cache) Scan(cursor string, pattern string, count int) ([]string, string, error) {
+	c.closeLock.RLock()
+	defer c.closeLock.RUnlock()
+	if c.closed {
+		return nil, "", cacheClosedErr
+	}
+
+	position, err := decodeScanCursor(cursor, len(c.segments))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if _, err = matchKey(pattern, ""); err != nil {
+		return nil, "", err
+	}
+
+	if count <= 0 {
+		count = defaultScanCount
+	}
+
+	var keys []string
+	for position.segment < len(c.segments) {
+		scanned, err := c.segments[position.segment].scan(position.after, position.started, pattern, count-len(keys))
+		if err != nil {
+			return nil, "", err
+		}
+
+		keys = append(keys, scanned...)
+		if len(keys) >= count {
+			position.started = true
+			position.after = keys[len(keys)-1]
+			return keys, position.encode(), nil
+		}
+
+		position = scanCursor{segment: position.segment + 1}
+	}
+	return keys, "", nil
+}
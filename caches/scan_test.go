@@ -0,0 +1,215 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/12/20 15:40:16
+
+package caches
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// go test -cover -run=^TestCacheScan$
+func TestCacheScan(t *testing.T) {
+
+	cache, clock := newTestCache(DefaultOptions())
+	for i := 0; i < 1000; i++ {
+		cache.Set("user:"+strconv.Itoa(i), []byte("value"))
+	}
+
+	for i := 0; i < 100; i++ {
+		cache.Set("order:"+strconv.Itoa(i), []byte("value"))
+		cache.SetWithOptions("dead:"+strconv.Itoa(i), []byte("value"), SetOptions{Ttl: time.Second, Expiration: Absolute})
+	}
+	clock.Add(time.Second)
+
+	// An empty key is a key too, so it should be scanned.
+	cache.Set("", []byte("value"))
+
+	scanned := map[string]int{}
+	cursor, calls := "", 0
+	for {
+		keys, next, err := cache.Scan(cursor, "", 37)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(keys) > 37 {
+			t.Fatalf("Scan returns %d keys more than count!", len(keys))
+		}
+
+		for _, key := range keys {
+			scanned[key]++
+		}
+
+		// Keys added during scanning shouldn't break the scan.
+		cache.Set("added:"+strconv.Itoa(calls), []byte("value"))
+		calls++
+		if cursor = next; cursor == "" {
+			break
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		if scanned["user:"+strconv.Itoa(i)] != 1 {
+			t.Fatalf("Key user:%d is scanned %d times!", i, scanned["user:"+strconv.Itoa(i)])
+		}
+	}
+
+	if scanned[""] != 1 {
+		t.Fatalf("Empty key is scanned %d times!", scanned[""])
+	}
+
+	for key, times := range scanned {
+		if times != 1 || strings.HasPrefix(key, "dead:") {
+			t.Fatalf("Key %s is scanned %d times!", key, times)
+		}
+	}
+
+	keys, cursor, err := cache.Scan("", "order:9*", 1000)
+	if err != nil || cursor != "" || len(keys) != 11 {
+		t.Fatalf("Scan with pattern returns %d keys, cursor %s and error %v!", len(keys), cursor, err)
+	}
+
+	if _, _, err = cache.Scan("notACursor", "", 10); err != invalidCursorErr {
+		t.Fatalf("Scan with an invalid cursor should return invalidCursorErr but got %v!", err)
+	}
+
+	if _, _, err = cache.Scan("", "[", 10); err == nil {
+		t.Fatal("Scan with a malformed pattern should fail!")
+	}
+}
+
+// go test -cover -run=^TestCacheDeleteMatching$
+func TestCacheDeleteMatching(t *testing.T) {
+
+	cache, _ := newTestCache(DefaultOptions())
+	for i := 0; i < 100; i++ {
+		cache.Set("user:42:"+strconv.Itoa(i), []byte("value"))
+		cache.Set("user:43:"+strconv.Itoa(i), []byte("value"))
+	}
+
+	if count, err := cache.DeleteMatching("user:42:*"); err != nil || count != 100 {
+		t.Fatalf("DeleteMatching should delete 100 keys but got %d and %v!", count, err)
+	}
+
+	if _, ok, _ := cache.Get("user:42:0"); ok || cache.Status().Count != 100 {
+		t.Fatalf("Keys of user 42 should be deleted! Count is %d.", cache.Status().Count)
+	}
+
+	if _, err := cache.DeleteMatching("["); err == nil {
+		t.Fatal("DeleteMatching with a malformed pattern should fail!")
+	}
+
+	if count, err := cache.FlushAll(); err != nil || count != 100 {
+		t.Fatalf("FlushAll should delete 100 keys but got %d and %v!", count, err)
+	}
+
+	if status := cache.Status(); status.Count != 0 || status.KeySize != 0 || status.DirtyCount != 400 {
+		t.Fatalf("Status %+v is wrong after flushing!", status)
+	}
+
+	if keys, cursor, err := cache.Scan("", "", 10); len(keys) != 0 || cursor != "" || err != nil {
+		t.Fatalf("Scan after flushing should return nothing but got %v, %s and %v!", keys, cursor, err)
+	}
+
+	// Matched keys scattered in the key index should be deleted, and other keys are still scanned in order.
+	for i := 0; i < 1000; i++ {
+		cache.Set("key:"+strconv.Itoa(i), []byte("value"))
+	}
+
+	if count, err := cache.DeleteMatching("key:*7"); err != nil || count != 100 {
+		t.Fatalf("DeleteMatching should delete 100 scattered keys but got %d and %v!", count, err)
+	}
+
+	keys, cursor, err := cache.Scan("", "", 1000)
+	if err != nil || cursor != "" || len(keys) != 900 {
+		t.Fatalf("Scan after deleting returns %d keys, cursor %s and error %v!", len(keys), cursor, err)
+	}
+
+	for _, key := range keys {
+		if strings.HasSuffix(key, "7") {
+			t.Fatalf("Key %s should be deleted!", key)
+		}
+	}
+}
+
+// go test -cover -run=^TestKeyIndex$
+func TestKeyIndex(t *testing.T) {
+
+	keysFrom := func(node *keyNode) string {
+		var keys []string
+		for ; node != nil; node = node.next[0] {
+			keys = append(keys, node.key)
+		}
+		return strings.Join(keys, ",")
+	}
+
+	ki := newKeyIndex()
+	for _, key := range []string{"c", "a", "", "b", "a"} {
+		ki.add(key)
+	}
+
+	if keys := keysFrom(ki.from("", false)); keys != ",a,b,c" {
+		t.Fatalf("Keys %s should be unique and in order!", keys)
+	}
+
+	if keys := keysFrom(ki.from("", true)); keys != "a,b,c" {
+		t.Fatalf("Scan after the empty key should return a,b,c but got %s!", keys)
+	}
+
+	if keys := keysFrom(ki.from("aa", true)); keys != "b,c" {
+		t.Fatalf("Scan after aa should return b,c but got %s!", keys)
+	}
+
+	if node := ki.from("c", true); node != nil {
+		t.Fatalf("Scan after the last key should return nil but got %s!", node.key)
+	}
+
+	ki.remove("b")
+	ki.remove("notExist")
+	if keys := keysFrom(ki.from("", false)); keys != ",a,c" {
+		t.Fatalf("Keys %s are wrong after removing!", keys)
+	}
+
+	// Keys should be kept in order in any levels.
+	var keys []string
+	for i := 0; i < 10000; i++ {
+		key := strconv.Itoa(rand.Intn(5000))
+		if i%3 == 0 {
+			ki.remove(key)
+			continue
+		}
+		ki.add(key)
+	}
+
+	for node := ki.from("", false); node != nil; node = node.next[0] {
+		keys = append(keys, node.key)
+	}
+
+	if !sort.StringsAreSorted(keys) {
+		t.Fatal("Keys should be in order!")
+	}
+
+	for i := 1; i < len(keys); i++ {
+		if keys[i] == keys[i-1] {
+			t.Fatalf("Key %s appears twice!", keys[i])
+		}
+	}
+
+	for i := ki.level - 1; i > 0; i-- {
+		for node := ki.head.next[i]; node != nil && node.next[i] != nil; node = node.next[i] {
+			if node.key >= node.next[i].key {
+				t.Fatalf("Keys in level %d are out of order!", i)
+			}
+		}
+	}
+}
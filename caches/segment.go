@@ -10,9 +10,26 @@ package caches
 
 import (
 	"errors"
+	"math"
+	"strconv"
 	"sync"
 )
 
+var (
+	// entrySizeExceededErr means the entry size will exceed if setting this entry.
+	entrySizeExceededErr = errors.New("the entry size will exceed if you set this entry")
+
+	// notAnIntegerErr means the value isn't a decimal int64.
+	notAnIntegerErr = errors.New("the value is not an integer")
+
+	// integerOverflowErr means the result of incrementing will overflow int64.
+	integerOverflowErr = errors.New("the result of incrementing will overflow")
+
+	// wrongTypeErr means the operation is against a key holding the wrong type of value.
+	// It's wrapped by value.checkType, so use errors.Is to check it.
+	wrongTypeErr = errors.New("the key holds the wrong type of value")
+)
+
 // segment is the struct storing the real data.
 type segment struct {
 
@@ -27,64 +44,698 @@ type segment struct {
 
 	// lock is for concurrency.
 	lock *sync.RWMutex
+
+	// accessLock serializes eviction metadata updates of readers holding the read lock.
+	// Writers holding the write lock don't need it.
+	accessLock *sync.Mutex
+
+	// policy chooses the entry to evict when segment is full.
+	policy EvictionPolicy
+
+	// expiry indexes entries by their deadlines, so dead entries can be removed actively.
+	expiry *expiryIndex
+
+	// keys keeps all keys in order, so keys can be scanned page by page.
+	keys *keyIndex
+
+	// log records all write operations of segment, which is nil if append log is disabled.
+	log *appendLog
+
+	// version is the last version given to values in segment.
+	version uint64
+
+	// waiters stores channels of blocking pops waiting for lists, and they're closed when items are pushed.
+	waiters map[string][]chan struct{}
 }
 
 // newSegment returns a segment holder with options.
 func newSegment(options *Options) *segment {
-	return &segment{
-		Data:    make(map[string]*value, options.MapSizeOfSegment),
-		Status:  NewStatus(),
-		options: options,
-		lock:    &sync.RWMutex{},
+	s := &segment{
+		Data:       make(map[string]*value, options.MapSizeOfSegment),
+		Status:     NewStatus(),
+		options:    options,
+		lock:       &sync.RWMutex{},
+		accessLock: &sync.Mutex{},
+		expiry:     newExpiryIndex(),
+		keys:       newKeyIndex(),
+		waiters:    make(map[string][]chan struct{}),
 	}
+	s.policy = newEvictionPolicy(options.EvictionPolicy, s)
+	return s
 }
 
 // get returns the value of specified key.
-func (s *segment) get(key string) ([]byte, bool) {
+// Returns an error wrapping wrongTypeErr if the key isn't a string.
+func (s *segment) get(key string) ([]byte, bool, error) {
+	data, _, ok, err := s.getWithVersion(key)
+	return data, ok, err
+}
+
+// getWithVersion returns the value of specified key and its version.
+// Returns an error wrapping wrongTypeErr if the key isn't a string.
+func (s *segment) getWithVersion(key string) ([]byte, uint64, bool, error) {
+	var data []byte
+	var version uint64
+	var err error
+	ok := s.lookup(key, func(value *value) {
+		if err = value.checkType(stringType); err == nil {
+			data, version = value.Data, value.Version
+		}
+	})
+
+	if !ok || err != nil {
+		return nil, 0, false, err
+	}
+	return data, version, true, nil
+}
+
+// getMany stores the values of specified keys existing to values.
+// Returns an error wrapping wrongTypeErr if any of keys isn't a string.
+func (s *segment) getMany(keys []string, values map[string][]byte) error {
+	for _, key := range keys {
+		var err error
+		s.lookup(key, func(value *value) {
+			if err = value.checkType(stringType); err == nil {
+				values[key] = value.Data
+			}
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookup runs read with the value of specified key in any type after visiting it.
+// Returns false without running read if the key doesn't exist.
+// It only holds the read lock for alive values, and the write lock is taken to remove the value if it's dead.
+// The read lock is held while running read, because hashes and lists are changed in place by writers,
+// so read shouldn't keep the value or its hash and list after returning. Data of fields and items can be kept.
+func (s *segment) lookup(key string, read func(value *value)) bool {
 	s.lock.RLock()
-	defer s.lock.RUnlock()
+	now := s.now()
+	value, ok := s.Data[key]
+	if ok && value.alive(now) {
+		value.visit(now)
+		s.accessLock.Lock()
+		s.policy.OnAccess(key)
+		s.accessLock.Unlock()
+		read(value)
+		s.lock.RUnlock()
+		return true
+	}
+
+	s.lock.RUnlock()
+	if ok {
+		s.removeDead(key)
+	}
+	return false
+}
+
+// removeDead removes the specified key if it's dead.
+func (s *segment) removeDead(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if value, ok := s.Data[key]; ok && !value.alive(s.now()) {
+		s.remove(key, value)
+		s.Status.Expired++
+	}
+}
+
+// touch visits the value of specified key in any type.
+// Returns false if the key doesn't exist.
+func (s *segment) touch(key string) bool {
+	return s.lookup(key, func(value *value) {})
+}
+
+// aliveValue returns the value of specified key if it's alive at now without locking.
+// A dead value will be removed and counted as expired, and false will be returned.
+func (s *segment) aliveValue(key string, now int64) (*value, bool) {
 	value, ok := s.Data[key]
 	if !ok {
 		return nil, false
 	}
 
-	if !value.alive() {
-		s.lock.RUnlock()
-		s.delete(key)
-		s.lock.RLock()
+	if !value.alive(now) {
+		s.remove(key, value)
+		s.Status.Expired++
 		return nil, false
 	}
-	return value.visit(), true
+	return value, true
+}
+
+// peek returns the value of specified key without visiting it, so its life and eviction metadata are kept.
+// Returns an error wrapping wrongTypeErr if the key isn't a string.
+func (s *segment) peek(key string) ([]byte, bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	value, ok := s.Data[key]
+	if !ok || !value.alive(s.now()) {
+		return nil, false, nil
+	}
+
+	if err := value.checkType(stringType); err != nil {
+		return nil, false, err
+	}
+	return value.Data, true, nil
+}
+
+// scan returns at most count alive keys matching pattern in order.
+// Keys are returned from the first one if started is false, otherwise from the first one greater than after.
+// Returns an error if pattern is malformed.
+func (s *segment) scan(after string, started bool, pattern string, count int) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	now := s.now()
+	var keys []string
+	for node := s.keys.from(after, started); node != nil && len(keys) < count; node = node.next[0] {
+		key := node.key
+		if !s.Data[key].alive(now) {
+			continue
+		}
+
+		matched, err := matchKey(pattern, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if matched {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// deleteMatching deletes all keys matching pattern and returns the count of deleted alive keys.
+// Keys are matched under the read lock, so readers won't be blocked by matching.
+// Matched keys can be anywhere in the key index, and removing each of them costs O(log n) no matter where it is.
+// Returns an error if pattern is malformed or logging failed.
+func (s *segment) deleteMatching(pattern string) (int, error) {
+	s.lock.RLock()
+	var keys []string
+	for node := s.keys.from("", false); node != nil; node = node.next[0] {
+		key := node.key
+		matched, err := matchKey(pattern, key)
+		if err != nil {
+			s.lock.RUnlock()
+			return 0, err
+		}
+
+		if matched {
+			keys = append(keys, key)
+		}
+	}
+
+	s.lock.RUnlock()
+	return s.deleteMany(keys)
 }
 
-// set sets an entry of specified key and value which has ttl.
-func (s *segment) set(key string, value []byte, ttl int64) error {
+// flush deletes all keys and returns the count of deleted alive keys.
+// Returns an error if logging failed.
+func (s *segment) flush() (int, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	if oldValue, ok := s.Data[key]; ok {
-		s.Status.subEntry(key, oldValue.Data)
+	now := s.now()
+	count := 0
+	for key, value := range s.Data {
+		if !value.alive(now) {
+			s.remove(key, value)
+			s.Status.Expired++
+			continue
+		}
+
+		if s.log != nil {
+			if err := s.log.appendDelete(key); err != nil {
+				return count, err
+			}
+		}
+
+		s.remove(key, value)
+		count++
 	}
+	return count, nil
+}
+
+// set sets an entry of specified key and value with options.
+func (s *segment) set(key string, value []byte, options SetOptions) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.put(key, newValue(value, options, s.now()))
+}
 
-	if !s.checkEntrySize(key, value) {
-		if oldValue, ok := s.Data[key]; ok {
-			s.Status.addEntry(key, oldValue.Data)
+// setMany sets entries of specified keys and values in entries with options.
+// Returns the count of entries set and the first error.
+func (s *segment) setMany(keys []string, entries map[string][]byte, options SetOptions) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	for i, key := range keys {
+		if err := s.put(key, newValue(entries[key], options, now)); err != nil {
+			return i, err
 		}
-		return errors.New("the entry size will exceed if you set this entry")
 	}
+	return len(keys), nil
+}
 
-	s.Status.addEntry(key, value)
-	s.Data[key] = newValue(value, ttl)
-	return nil
+// setIf sets an entry of specified key and value with options only if should returns true.
+// The argument of should is if the key exists, and dead entries are treated as not existing.
+// Returns the old data, if the key existed and if the entry is set.
+// Returns an error wrapping wrongTypeErr if it should set but the key isn't a string, so hashes and lists won't be overwritten.
+func (s *segment) setIf(key string, value []byte, options SetOptions, should func(existed bool) bool) ([]byte, bool, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	var oldData []byte
+	oldValue, existed := s.Data[key]
+	existed = existed && oldValue.alive(now)
+	if existed {
+		oldData = oldValue.Data
+	}
+
+	if !should(existed) {
+		return oldData, existed, false, nil
+	}
+
+	if existed {
+		if err := oldValue.checkType(stringType); err != nil {
+			return nil, existed, false, err
+		}
+	}
+
+	if err := s.put(key, newValue(value, options, now)); err != nil {
+		return oldData, existed, false, err
+	}
+	return oldData, existed, true, nil
+}
+
+// getDelete deletes the specified key and returns its value.
+// Returns false if the key doesn't exist.
+func (s *segment) getDelete(key string) ([]byte, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	oldValue, ok := s.aliveValue(key, s.now())
+	if !ok {
+		return nil, false, nil
+	}
+
+	if err := oldValue.checkType(stringType); err != nil {
+		return nil, false, err
+	}
+
+	if s.log != nil {
+		if err := s.log.appendDelete(key); err != nil {
+			return nil, false, err
+		}
+	}
+
+	s.remove(key, oldValue)
+	return oldValue.Data, true, nil
+}
+
+// compareAndSet sets an entry of specified key and value with options only if the version of key is expected.
+// The version of a key not existing is 0. Returns the new version if it's set, otherwise the current version and false.
+func (s *segment) compareAndSet(key string, value []byte, expected uint64, options SetOptions) (uint64, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	current := uint64(0)
+	if oldValue, ok := s.Data[key]; ok && oldValue.alive(now) {
+		current = oldValue.Version
+	}
+
+	if current != expected {
+		return current, false, nil
+	}
+
+	newValue := newValue(value, options, now)
+	if err := s.put(key, newValue); err != nil {
+		return current, false, err
+	}
+	return newValue.Version, true, nil
 }
 
 // delete deletes the specified key and value.
-func (s *segment) delete(key string) {
+// Returns false if the key doesn't exist.
+func (s *segment) delete(key string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.erase(key)
+}
+
+// deleteMany deletes the specified keys and returns the count of keys deleted.
+// It costs O(k log n) for k keys in a segment of n keys, because each key is removed from the key index alone.
+func (s *segment) deleteMany(keys []string) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	count := 0
+	for _, key := range keys {
+		ok, err := s.erase(key)
+		if err != nil {
+			return count, err
+		}
+
+		if ok {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// erase logs and removes the specified key without locking.
+// Returns false if the key doesn't exist, and a dead key is removed as expired instead of deleted.
+func (s *segment) erase(key string) (bool, error) {
+	oldValue, ok := s.aliveValue(key, s.now())
+	if !ok {
+		return false, nil
+	}
+
+	if s.log != nil {
+		if err := s.log.appendDelete(key); err != nil {
+			return false, err
+		}
+	}
+
+	s.remove(key, oldValue)
+	return true, nil
+}
+
+// expireAt makes the entry of specified key die at the given time in milliseconds.
+// Returns false if the key doesn't exist.
+func (s *segment) expireAt(key string, at int64) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	oldValue, ok := s.aliveValue(key, now)
+	if !ok {
+		return false, nil
+	}
+
+	if at <= now {
+		if s.log != nil {
+			if err := s.log.appendDelete(key); err != nil {
+				return false, err
+			}
+		}
+
+		s.remove(key, oldValue)
+		return true, nil
+	}
+
+	newValue := *oldValue
+	newValue.Expiration = Absolute
+	newValue.Ttl = at - newValue.Ctime
+	if err := s.put(key, &newValue); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// incrBy adds delta to the decimal int64 value of specified key and returns the result.
+// A key not existing is treated as 0 and never dies, otherwise the ttl of value is kept.
+func (s *segment) incrBy(key string, delta int64) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	newValue := newValue(nil, SetOptions{Ttl: NeverDie}, now)
+	if oldValue, ok := s.Data[key]; ok && oldValue.alive(now) {
+		if err := oldValue.checkType(stringType); err != nil {
+			return 0, err
+		}
+
+		copied := *oldValue
+		newValue = &copied
+	}
+
+	number, err := addInt(newValue.Data, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	newValue.Data = []byte(strconv.FormatInt(number, 10))
+	if err := s.put(key, newValue); err != nil {
+		return 0, err
+	}
+	return number, nil
+}
+
+// addInt adds delta to the decimal int64 in data and returns the result.
+// Empty data is treated as 0.
+func addInt(data []byte, delta int64) (int64, error) {
+	number := int64(0)
+	if len(data) > 0 {
+		var err error
+		number, err = strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return 0, notAnIntegerErr
+		}
+	}
+
+	if (delta > 0 && number > math.MaxInt64-delta) || (delta < 0 && number < math.MinInt64-delta) {
+		return 0, integerOverflowErr
+	}
+	return number + delta, nil
+}
+
+// ttl returns the remaining life of specified key in milliseconds without visiting it.
+// Returns NeverDie if it never dies, and false if the key doesn't exist.
+func (s *segment) ttl(key string) (int64, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	value, ok := s.Data[key]
+	if !ok {
+		return 0, false
+	}
+
+	now := s.now()
+	if !value.alive(now) {
+		return 0, false
+	}
+
+	deadline := value.deadline()
+	if deadline == math.MaxInt64 {
+		return NeverDie, true
+	}
+	return deadline - now, true
+}
+
+// setTtl makes the entry of specified key die after ttl milliseconds from now, and its expiration mode is kept.
+// Returns false if the key doesn't exist.
+func (s *segment) setTtl(key string, ttl int64) (bool, error) {
+	return s.modify(key, func(value *value, now int64) {
+		switch value.Expiration {
+		case Absolute:
+			value.Ttl = now + ttl - value.Ctime
+		case SlidingWithMax:
+			value.Ttl, value.Atime = ttl, now
+			if value.MaxTtl > 0 && value.Ctime+value.MaxTtl < now+ttl {
+				value.MaxTtl = now + ttl - value.Ctime
+			}
+		default:
+			value.Ttl, value.Atime = ttl, now
+		}
+	})
+}
+
+// persist makes the entry of specified key never die.
+// Returns false if the key doesn't exist.
+func (s *segment) persist(key string) (bool, error) {
+	return s.modify(key, func(value *value, now int64) {
+		value.Ttl = NeverDie
+	})
+}
+
+// modify stores a copy of the value of specified key changed by change, so the change will be logged.
+// Returns false if the key doesn't exist.
+func (s *segment) modify(key string, change func(value *value, now int64)) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	oldValue, ok := s.aliveValue(key, now)
+	if !ok {
+		return false, nil
+	}
+
+	newValue := *oldValue
+	change(&newValue, now)
+	if err := s.put(key, &newValue); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// replay applies record from append log to segment without logging it again.
+func (s *segment) replay(record *logRecord) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	switch record.op {
+	case deleteOp:
+		if oldValue, ok := s.Data[record.key]; ok {
+			s.remove(record.key, oldValue)
+		}
+	case hashSetOp, hashDeleteOp:
+		// The hash may be skipped when restoring because it's dead or too large, and its changes are skipped too.
+		oldValue, ok := s.Data[record.key]
+		if !ok || oldValue.typ() != hashType {
+			return
+		}
+
+		del := record.op == hashDeleteOp
+		s.resize(record.key, oldValue, deltaOfFields(oldValue.Hash, record.fields, del), record.version)
+		applyFields(oldValue.Hash, record.fields, del)
+	case listPushOp, listPopOp:
+		// The list may be skipped when restoring like hashes, and an empty list has been deleted by a delete record.
+		oldValue, ok := s.Data[record.key]
+		if !ok || oldValue.typ() != listType || (record.op == listPopOp && len(oldValue.List) <= 0) {
+			return
+		}
+
+		if record.op == listPushOp {
+			s.resize(record.key, oldValue, sizeOfItems(record.items), record.version)
+			pushItems(oldValue, record.items, record.left)
+			return
+		}
+
+		item := popItem(oldValue, record.left)
+		s.resize(record.key, oldValue, -int64(len(item)), record.version)
+	default:
+		s.restore(record.key, record.value)
+	}
+}
+
+// restore stores key and value recovered from files without locking and logging.
+// The entry will be skipped if it's too large to store.
+func (s *segment) restore(key string, value *value) {
+	if s.makeRoomFor(key, value.size()) == nil {
+		s.store(key, value)
+	}
+
+	if value.Version > s.version {
+		s.version = value.Version
+	}
+}
+
+// put gives value a new version, logs and stores it with key without locking.
+// Returns an error if the entry is too large to store or logging failed.
+func (s *segment) put(key string, value *value) error {
+	value.Version = s.version + 1
+	if err := s.makeRoomFor(key, value.size()); err != nil {
+		return err
+	}
+
+	if s.log != nil {
+		if err := s.log.appendSet(key, value); err != nil {
+			return err
+		}
+	}
+
+	s.store(key, value)
+	s.version = value.Version
+	return nil
+}
+
+// putInPlace changes the stored value of key by change without locking, and the size of value changes by delta.
+// Only the change is logged by log with the new version, so the cost doesn't depend on the size of value.
+// If the value itself is evicted to make room, it will be changed and stored again by put.
+// Returns an error if the entry is too large to store or logging failed, and the value won't be changed then.
+func (s *segment) putInPlace(key string, value *value, delta int64, log func(version uint64) error, change func()) error {
+	if err := s.makeRoomFor(key, value.size()+delta); err != nil {
+		return err
+	}
+
+	if s.Data[key] != value {
+		change()
+		value.fieldsSize += delta
+		return s.put(key, value)
+	}
+
+	version := s.version + 1
+	if s.log != nil {
+		if err := log(version); err != nil {
+			return err
+		}
+	}
+
+	change()
+	s.resize(key, value, delta, version)
+	s.policy.OnInsert(key)
+	return nil
+}
+
+// resize records that the stored value of key has been changed in place by delta without locking.
+// The value will get version, so its version always increases.
+func (s *segment) resize(key string, value *value, delta int64, version uint64) {
+	value.fieldsSize += delta
+	value.Version = version
+	s.Status.ValueSize += delta
+	if version > s.version {
+		s.version = version
+	}
+}
+
+// snapshot returns a copy of data in segment.
+// Hashes and lists are copied because they're changed in place, but the data of values is shared because it's never modified.
+func (s *segment) snapshot() map[string]*value {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	data := make(map[string]*value, len(s.Data))
+	for key, v := range s.Data {
+		data[key] = v.clone()
+	}
+	return data
+}
+
+// makeRoomFor evicts entries until there is enough room for key and a value of size without locking.
+// Evictions are logged before removing, and an error will be returned if the entry is too large to store or logging failed.
+func (s *segment) makeRoomFor(key string, size int64) error {
+	if int64(len(key))+size > s.maxEntrySize() {
+		return entrySizeExceededErr
+	}
+
+	for !s.checkEntrySize(key, size) {
+		victim, ok := s.policy.Victim()
+		if !ok {
+			return entrySizeExceededErr
+		}
+
+		victimValue, ok := s.Data[victim]
+		if !ok {
+			return entrySizeExceededErr
+		}
+
+		// Evictions should be logged, otherwise evicted entries will come back after replaying.
+		if s.log != nil {
+			if err := s.log.appendDelete(victim); err != nil {
+				return err
+			}
+		}
+		s.remove(victim, victimValue)
+		s.Status.Evictions++
+	}
+	return nil
+}
+
+// store stores key and value to segment without locking.
+func (s *segment) store(key string, value *value) {
 	if oldValue, ok := s.Data[key]; ok {
-		s.Status.subEntry(key, oldValue.Data)
-		delete(s.Data, key)
+		s.Status.subEntry(key, oldValue.size())
+	} else {
+		s.keys.add(key)
 	}
+
+	s.Status.addEntry(key, value.size())
+	s.Data[key] = value
+	s.policy.OnInsert(key)
+	s.expiry.update(key, value)
+}
+
+// remove removes the specified key and value without locking.
+func (s *segment) remove(key string, value *value) {
+	s.Status.subEntry(key, value.size())
+	delete(s.Data, key)
+	s.policy.OnDelete(key)
+	s.expiry.remove(key)
+	s.keys.remove(key)
 }
 
 // Status returns the status of segment.
@@ -94,24 +745,59 @@ func (s *segment) status() Status {
 	return *s.Status
 }
 
+// now returns the time of clock in options in milliseconds.
+func (s *segment) now() int64 {
+	return unixMillis(s.options.Clock.Now())
+}
+
+// maxEntrySize returns the max size of entries in one segment.
+func (s *segment) maxEntrySize() int64 {
+	return int64((s.options.MaxEntrySize * 1024 * 1024) / s.options.SegmentSize)
+}
+
 // checkEntrySize checks the entry size and guarantees it will not exceed.
-func (s *segment) checkEntrySize(newKey string, newValue []byte) bool {
-	return s.Status.entrySize()+int64(len(newKey))+int64(len(newValue)) <= int64((s.options.MaxEntrySize*1024*1024)/s.options.SegmentSize)
+// The size of old entry will be excluded if the key exists.
+func (s *segment) checkEntrySize(newKey string, newValueSize int64) bool {
+	entrySize := s.Status.entrySize() + int64(len(newKey)) + newValueSize
+	if oldValue, ok := s.Data[newKey]; ok {
+		entrySize -= int64(len(newKey)) + oldValue.size()
+	}
+	return entrySize <= s.maxEntrySize()
 }
 
 // gc will clean up the dead entries in segment.
 func (s *segment) gc() {
+	s.expire(s.options.MaxGcCount)
+}
+
+// expire removes dead entries in the order of their deadlines and returns the count of removed entries.
+// At most limit entries will be checked, so the time of locking is bounded.
+// A sliding entry visited after indexing is still alive at its old deadline, so it will be indexed again.
+func (s *segment) expire(limit int) int {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	now := s.now()
 	count := 0
-	for key, value := range s.Data {
-		if !value.alive() {
-			s.Status.subEntry(key, value.Data)
-			delete(s.Data, key)
-			count++
-			if count >= s.options.MaxGcCount {
-				break
-			}
+	for i := 0; i < limit; i++ {
+		key, deadline, ok := s.expiry.peek()
+		if !ok || deadline > now {
+			break
+		}
+
+		value, ok := s.Data[key]
+		if !ok {
+			s.expiry.remove(key)
+			continue
+		}
+
+		if value.alive(now) {
+			s.expiry.update(key, value)
+			continue
 		}
+
+		s.remove(key, value)
+		s.Status.Expired++
+		count++
 	}
+	return count
 }
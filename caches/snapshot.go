@@ -0,0 +1,195 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/11/28 14:36:20
+
+package caches
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// snapshotTimeFormat is the format of time in the name of snapshot.
+	// The milliseconds will be appended to it.
+	snapshotTimeFormat = "20060102150405"
+
+	// snapshotTimeLength is the length of time in the name of snapshot.
+	snapshotTimeLength = len(snapshotTimeFormat) + 3
+)
+
+// Snapshot is a dump file stored in Options.DumpDir.
+type Snapshot struct {
+
+	// Path is the path of snapshot.
+	Path string `json:"path"`
+
+	// Time is the time when snapshot was created.
+	Time time.Time `json:"time"`
+
+	// Size is the size of snapshot file.
+	Size int64 `json:"size"`
+}
+
+// snapshotPrefix returns the prefix of snapshot names, which is the name of DumpFile and a dot.
+func snapshotPrefix(options *Options) string {
+	return filepath.Base(options.DumpFile) + "."
+}
+
+// parseSnapshotTime returns the time in name of snapshot and false if name isn't a snapshot.
+func parseSnapshotTime(options *Options, name string) (time.Time, bool) {
+	prefix := snapshotPrefix(options)
+	if !strings.HasPrefix(name, prefix) || len(name) != len(prefix)+snapshotTimeLength {
+		return time.Time{}, false
+	}
+
+	timeString := strings.TrimPrefix(name, prefix)
+	snapshotTime, err := time.ParseInLocation(snapshotTimeFormat, timeString[:len(snapshotTimeFormat)], time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	milliseconds, err := strconv.Atoi(timeString[len(snapshotTimeFormat):])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return snapshotTime.Add(time.Duration(milliseconds) * time.Millisecond), true
+}
+
+// nextDumpFile returns the file used to dump at now.
+// It's DumpFile if DumpDir isn't set, otherwise it's a new snapshot in DumpDir.
+func nextDumpFile(options *Options, now time.Time) (string, error) {
+	if options.DumpDir == "" {
+		return options.DumpFile, nil
+	}
+
+	if err := os.MkdirAll(options.DumpDir, 0755); err != nil {
+		return "", err
+	}
+
+	name := snapshotPrefix(options) + now.Format(snapshotTimeFormat) + fmt.Sprintf("%03d", now.Nanosecond()/int(time.Millisecond))
+	return filepath.Join(options.DumpDir, name), nil
+}
+
+// latestDumpFile returns the file used to recover.
+// It's the latest snapshot in DumpDir if there is one, otherwise it's DumpFile.
+func latestDumpFile(options *Options) (string, error) {
+	if options.DumpDir == "" {
+		return options.DumpFile, nil
+	}
+
+	snapshots, err := ListSnapshots(*options)
+	if err != nil {
+		return "", err
+	}
+
+	if len(snapshots) <= 0 {
+		return options.DumpFile, nil
+	}
+	return snapshots[0].Path, nil
+}
+
+// ListSnapshots returns all snapshots in DumpDir of options, and the latest one is the first.
+// Returns an error if failed.
+func ListSnapshots(options Options) ([]Snapshot, error) {
+
+	fileInfos, err := ioutil.ReadDir(options.DumpDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Snapshot
+	for _, fileInfo := range fileInfos {
+		snapshotTime, ok := parseSnapshotTime(&options, fileInfo.Name())
+		if !ok || fileInfo.IsDir() {
+			continue
+		}
+
+		snapshots = append(snapshots, Snapshot{
+			Path: filepath.Join(options.DumpDir, fileInfo.Name()),
+			Time: snapshotTime,
+			Size: fileInfo.Size(),
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.After(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
+
+// pruneSnapshots removes snapshots which don't match DumpRetainCount or DumpRetainAge at now.
+// The latest snapshot is always kept.
+func pruneSnapshots(options *Options, now time.Time) error {
+	if options.DumpRetainCount <= 0 && options.DumpRetainAge <= 0 {
+		return nil
+	}
+
+	snapshots, err := ListSnapshots(*options)
+	if err != nil {
+		return err
+	}
+
+	maxAge := time.Duration(options.DumpRetainAge) * time.Hour
+	for i, snapshot := range snapshots {
+		if i == 0 || i < options.DumpRetainCount || (maxAge > 0 && now.Sub(snapshot.Time) <= maxAge) {
+			continue
+		}
+
+		if err = os.Remove(snapshot.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewCacheFromSnapshot returns a new Cache holder recovered from snapshotFile with given options.
+// The append log won't be replayed because its records may be newer than the snapshot.
+// Instead, it will be renamed with suffix .before-restore and a time and kept, so writes logged after the snapshot can still be found.
+// A dump will be done at once, so the cache will recover from the state of snapshotFile after restarting.
+// Returns an error if failed.
+func NewCacheFromSnapshot(options Options, snapshotFile string) (*Cache, error) {
+
+	d := newEmptyDump()
+	cache, err := d.from(snapshotFile, options)
+	if err != nil {
+		return nil, fmt.Errorf("recover from snapshot %s failed: %w", snapshotFile, err)
+	}
+
+	if options.AppendOnly {
+		size, maxSeq, err := readRecords(options.AppendFile, func(record *logRecord) {})
+		if err != nil {
+			return nil, err
+		}
+
+		if size > 0 {
+			if err = os.Rename(options.AppendFile, options.AppendFile+".before-restore"+nowSuffix()); err != nil {
+				return nil, err
+			}
+		}
+
+		// Seqs of the new log start after the old one, so they never go backwards.
+		if maxSeq < d.Seq {
+			maxSeq = d.Seq
+		}
+
+		if err = cache.startAppendLog(&options, 0, maxSeq); err != nil {
+			return nil, err
+		}
+	}
+	return cache, cache.dump()
+}
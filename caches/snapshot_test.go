@@ -0,0 +1,172 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/11/28 15:52:07
+
+package caches
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// go test -cover -run=^TestSnapshots$
+func TestSnapshots(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpDir = filepath.Join(os.TempDir(), "TestSnapshots")
+	options.DumpRetainCount = 3
+	os.RemoveAll(options.DumpDir)
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		cache.Set("key"+strconv.Itoa(i), []byte("value"+strconv.Itoa(i)))
+		if err = cache.dump(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	snapshots, err := ListSnapshots(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snapshots) != 3 {
+		t.Fatalf("There should be 3 snapshots left but got %d!", len(snapshots))
+	}
+
+	for i := 1; i < len(snapshots); i++ {
+		if !snapshots[i-1].Time.After(snapshots[i].Time) {
+			t.Fatalf("Snapshots %+v aren't sorted from the latest!", snapshots)
+		}
+	}
+
+	// The latest snapshot is used when recovering.
+	cache, err = NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cache.Status().Count != 5 {
+		t.Fatalf("Recover from the latest snapshot failed! %d entries in cache!", cache.Status().Count)
+	}
+
+	// Restore from the oldest snapshot left, which has 3 entries.
+	cache, err = NewCacheFromSnapshot(options, snapshots[2].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cache.Status().Count != 3 {
+		t.Fatalf("Recover from the oldest snapshot failed! %d entries in cache!", cache.Status().Count)
+	}
+
+	cache, err = NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cache.Status().Count != 3 {
+		t.Fatalf("Restart after restoring from a snapshot failed! %d entries in cache!", cache.Status().Count)
+	}
+}
+
+// go test -cover -run=^TestPruneSnapshotsByAge$
+func TestPruneSnapshotsByAge(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpDir = filepath.Join(os.TempDir(), "TestPruneSnapshotsByAge")
+	options.DumpRetainCount = 0
+	options.DumpRetainAge = 1
+	os.RemoveAll(options.DumpDir)
+
+	now := time.Now()
+	for _, age := range []time.Duration{0, 30 * time.Minute, 2 * time.Hour, 3 * time.Hour} {
+		snapshotFile, err := nextDumpFile(&options, now.Add(-age))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = newDump(NewCache(), 0).to(snapshotFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pruneSnapshots(&options, now); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := ListSnapshots(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snapshots) != 2 {
+		t.Fatalf("There should be 2 snapshots left but got %+v!", snapshots)
+	}
+}
+
+// go test -cover -run=^TestRestoreSnapshotKeepsAppendLog$
+func TestRestoreSnapshotKeepsAppendLog(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpDir = filepath.Join(os.TempDir(), "TestRestoreSnapshotKeepsAppendLog")
+	options.AppendFile = filepath.Join(options.DumpDir, "kafo.aof")
+	options.AppendOnly = true
+	os.RemoveAll(options.DumpDir)
+	os.MkdirAll(options.DumpDir, 0755)
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("dumped", []byte("value"))
+	if err = cache.dump(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("logged", []byte("value"))
+	cache.log.close()
+
+	snapshots, err := ListSnapshots(options)
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("There should be 1 snapshot but got %d and %v!", len(snapshots), err)
+	}
+
+	cache, err = NewCacheFromSnapshot(options, snapshots[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.log.close()
+
+	if _, ok, _ := cache.Get("logged"); ok {
+		t.Fatal("Key logged after the snapshot shouldn't be restored!")
+	}
+
+	// The old append log should be kept with its records.
+	rotated, err := filepath.Glob(options.AppendFile + ".before-restore.*")
+	if err != nil || len(rotated) != 1 {
+		t.Fatalf("There should be 1 rotated append log but got %v and %v!", rotated, err)
+	}
+
+	var keys []string
+	if _, _, err = readRecords(rotated[0], func(record *logRecord) { keys = append(keys, record.key) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1 || keys[0] != "logged" {
+		t.Fatalf("Rotated append log should have the record of key logged but got %v!", keys)
+	}
+}
@@ -19,29 +19,59 @@ type Status struct {
 
 	// ValueSize is the size of value.
 	ValueSize int64 `json:"valueSize"`
+
+	// Evictions is how many entries evicted because of memory exceeded.
+	Evictions int64 `json:"evictions"`
+
+	// Expired is how many entries removed because they are dead.
+	Expired int64 `json:"expired"`
+
+	// Dumping is if cache is dumping now.
+	Dumping bool `json:"dumping"`
+
+	// DumpProgress is the progress of current dump, which is from 0 to 1.
+	DumpProgress float64 `json:"dumpProgress"`
+
+	// LastDumpDuration is the duration of last dump.
+	// The unit is Millisecond.
+	LastDumpDuration int64 `json:"lastDumpDuration"`
+
+	// LastSave is the time of last successful dump.
+	// The unit is second.
+	LastSave int64 `json:"lastSave"`
+
+	// DirtyCount is how many writes happened since last save.
+	DirtyCount int64 `json:"dirtyCount"`
 }
 
 // NewStatus returns a new status holder.
 func NewStatus() *Status {
 	return &Status{
-		Count:     0,
-		KeySize:   0,
-		ValueSize: 0,
+		Count:            0,
+		KeySize:          0,
+		ValueSize:        0,
+		Evictions:        0,
+		Expired:          0,
+		Dumping:          false,
+		DumpProgress:     0,
+		LastDumpDuration: 0,
+		LastSave:         0,
+		DirtyCount:       0,
 	}
 }
 
-// addEntry adds all information to status with key and value.
-func (s *Status) addEntry(key string, value []byte) {
+// addEntry adds all information to status with key and the size of value.
+func (s *Status) addEntry(key string, valueSize int64) {
 	s.Count++
 	s.KeySize += int64(len(key))
-	s.ValueSize += int64(len(value))
+	s.ValueSize += valueSize
 }
 
-// subEntry subs all information to status with key and value.
-func (s *Status) subEntry(key string, value []byte) {
+// subEntry subs all information to status with key and the size of value.
+func (s *Status) subEntry(key string, valueSize int64) {
 	s.Count--
 	s.KeySize -= int64(len(key))
-	s.ValueSize -= int64(len(value))
+	s.ValueSize -= valueSize
 }
 
 // entrySize returns the sum of keySize and valueSize.
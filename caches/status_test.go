@@ -22,7 +22,7 @@ func TestStatus(t *testing.T) {
 			status.Count, status.KeySize, status.ValueSize)
 	}
 
-	status.addEntry("key", []byte("value"))
+	status.addEntry("key", int64(len("value")))
 
 	if status.Count != 1 || status.KeySize != 3 || status.ValueSize != 5 {
 		t.Fatalf("The status is wrong! Count is %d. KeySize is %d. ValueSize is %d.",
@@ -38,7 +38,7 @@ func TestStatus(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if string(statusJson) != `{"count":1,"keySize":3,"valueSize":5}` {
+	if string(statusJson) != `{"count":1,"keySize":3,"valueSize":5,"evictions":0,"expired":0,"dumping":false,"dumpProgress":0,"lastDumpDuration":0,"lastSave":0,"dirtyCount":0}` {
 		t.Fatal(string(statusJson))
 	}
 }
@@ -0,0 +1,218 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/11/15 15:20:36
+
+package caches
+
+import (
+	"container/list"
+	"hash/fnv"
+)
+
+const (
+	// sketchDepth is the number of rows in sketch.
+	sketchDepth = 4
+
+	// sketchWidth is the number of counters in one row of sketch.
+	// This value should be the pow of 2.
+	sketchWidth = 256
+
+	// maxSketchCounter is the max value of one counter in sketch.
+	maxSketchCounter = 15
+
+	// windowPercent is the percent of entries in window.
+	windowPercent = 1
+
+	// protectedPercent is the percent of entries in protected region of main.
+	protectedPercent = 80
+)
+
+// sketch is a count-min sketch estimating the frequency of keys.
+// All counters will be halved after enough samples, so old frequencies fade away.
+type sketch struct {
+
+	// counters stores all counters of rows.
+	counters [sketchDepth][sketchWidth]uint8
+
+	// samples is the count of increments since last resetting.
+	samples int
+}
+
+// indexes returns the positions of key in every row.
+func (s *sketch) indexes(key string) [sketchDepth]uint32 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)
+
+	var indexes [sketchDepth]uint32
+	for i := range indexes {
+		indexes[i] = (h1 + uint32(i)*h2) & (sketchWidth - 1)
+	}
+	return indexes
+}
+
+// increment increases the frequency of key.
+func (s *sketch) increment(key string) {
+	for row, index := range s.indexes(key) {
+		if s.counters[row][index] < maxSketchCounter {
+			s.counters[row][index]++
+		}
+	}
+
+	s.samples++
+	if s.samples >= sketchWidth*10 {
+		s.reset()
+	}
+}
+
+// estimate returns the estimated frequency of key.
+func (s *sketch) estimate(key string) uint8 {
+	min := uint8(maxSketchCounter)
+	for row, index := range s.indexes(key) {
+		if s.counters[row][index] < min {
+			min = s.counters[row][index]
+		}
+	}
+	return min
+}
+
+// reset halves all counters.
+func (s *sketch) reset() {
+	for row := range s.counters {
+		for i := range s.counters[row] {
+			s.counters[row][i] >>= 1
+		}
+	}
+	s.samples = 0
+}
+
+// =======================================================================
+
+// tinyLFUPolicy is a W-TinyLFU policy.
+// New entries are stored in a small LRU window first, and the main region is a segmented LRU.
+// When the window is full, its victim competes with the victim of main by frequency,
+// which means only entries used frequently can be admitted to main.
+type tinyLFUPolicy struct {
+
+	// sketch estimates the frequency of keys.
+	sketch *sketch
+
+	// window stores the new entries.
+	window *list.List
+
+	// probation stores the entries admitted to main but haven't been visited again.
+	probation *list.List
+
+	// protected stores the entries visited in probation.
+	protected *list.List
+
+	// elements maps key to element in one of lists.
+	elements map[string]*list.Element
+
+	// regions maps key to the list it belongs to.
+	regions map[string]*list.List
+}
+
+// NewTinyLFUPolicy returns a W-TinyLFU policy.
+func NewTinyLFUPolicy() EvictionPolicy {
+	return &tinyLFUPolicy{
+		sketch:    &sketch{},
+		window:    list.New(),
+		probation: list.New(),
+		protected: list.New(),
+		elements:  map[string]*list.Element{},
+		regions:   map[string]*list.List{},
+	}
+}
+
+// moveTo moves key to the front of region.
+func (tp *tinyLFUPolicy) moveTo(key string, region *list.List) {
+	if element, ok := tp.elements[key]; ok {
+		tp.regions[key].Remove(element)
+	}
+	tp.elements[key] = region.PushFront(key)
+	tp.regions[key] = region
+}
+
+func (tp *tinyLFUPolicy) OnAccess(key string) {
+	tp.sketch.increment(key)
+	element, ok := tp.elements[key]
+	if !ok {
+		return
+	}
+
+	region := tp.regions[key]
+	if region != tp.probation {
+		region.MoveToFront(element)
+		return
+	}
+
+	tp.moveTo(key, tp.protected)
+	mainLen := tp.probation.Len() + tp.protected.Len()
+	if tp.protected.Len()*100 > mainLen*protectedPercent {
+		demoted := tp.protected.Back().Value.(string)
+		tp.moveTo(demoted, tp.probation)
+	}
+}
+
+func (tp *tinyLFUPolicy) OnInsert(key string) {
+	if _, ok := tp.elements[key]; ok {
+		tp.OnAccess(key)
+		return
+	}
+
+	tp.sketch.increment(key)
+	tp.moveTo(key, tp.window)
+}
+
+func (tp *tinyLFUPolicy) OnDelete(key string) {
+	if element, ok := tp.elements[key]; ok {
+		tp.regions[key].Remove(element)
+		delete(tp.elements, key)
+		delete(tp.regions, key)
+	}
+}
+
+func (tp *tinyLFUPolicy) Victim() (string, bool) {
+	windowCap := len(tp.elements) * windowPercent / 100
+	if windowCap < 1 {
+		windowCap = 1
+	}
+
+	// Entries overflowed from window before segment is full are admitted to main directly,
+	// so only the last one needs to compete with the victim of main.
+	for tp.window.Len() > windowCap+1 {
+		tp.moveTo(tp.window.Back().Value.(string), tp.probation)
+	}
+
+	var mainVictim *list.Element
+	if mainVictim = tp.probation.Back(); mainVictim == nil {
+		mainVictim = tp.protected.Back()
+	}
+
+	candidate := tp.window.Back()
+	if mainVictim == nil {
+		if candidate == nil {
+			return "", false
+		}
+		return candidate.Value.(string), true
+	}
+
+	if tp.window.Len() <= windowCap {
+		return mainVictim.Value.(string), true
+	}
+
+	// The candidate of window is admitted to main only if it's used more frequently than the victim of main.
+	candidateKey := candidate.Value.(string)
+	victimKey := mainVictim.Value.(string)
+	if tp.sketch.estimate(candidateKey) > tp.sketch.estimate(victimKey) {
+		tp.moveTo(candidateKey, tp.probation)
+		return victimKey, true
+	}
+	return candidateKey, true
+}
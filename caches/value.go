@@ -9,6 +9,9 @@
 package caches
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"sync/atomic"
 	"time"
 
@@ -16,10 +19,106 @@ import (
 )
 
 const (
-	// NeverDie means value.alive() returns true forever.
+	// NeverDie means value.alive(now) returns true forever.
 	NeverDie = 0
 )
 
+// ExpirationMode decides how the ttl of value is counted.
+type ExpirationMode uint8
+
+const (
+	// Sliding means the value dies after ttl since it's visited last time.
+	Sliding ExpirationMode = 0
+
+	// Absolute means the value dies after ttl since it's created, and visiting it won't extend its life.
+	Absolute ExpirationMode = 1
+
+	// SlidingWithMax means the value is sliding, but it dies after max ttl since it's created anyway.
+	SlidingWithMax ExpirationMode = 2
+)
+
+var (
+	// unknownExpirationModeErr means the expiration mode is unknown.
+	unknownExpirationModeErr = errors.New("the expiration mode is unknown")
+
+	// expirationModeNames stores the names of all expiration modes.
+	expirationModeNames = map[ExpirationMode]string{
+		Sliding:        "sliding",
+		Absolute:       "absolute",
+		SlidingWithMax: "sliding-max",
+	}
+)
+
+// String returns the name of mode, such as sliding, absolute and sliding-max.
+func (em ExpirationMode) String() string {
+	if name, ok := expirationModeNames[em]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseExpirationMode returns the mode of name and an error if name is unknown.
+func ParseExpirationMode(name string) (ExpirationMode, error) {
+	for mode, modeName := range expirationModeNames {
+		if modeName == name {
+			return mode, nil
+		}
+	}
+	return Sliding, unknownExpirationModeErr
+}
+
+// valueType is the type of value, which decides the operations it supports.
+type valueType uint8
+
+const (
+	// stringType means the value is a plain byte value.
+	stringType valueType = 0
+
+	// hashType means the value is a hash of fields.
+	hashType valueType = 1
+
+	// listType means the value is a list of items.
+	listType valueType = 2
+)
+
+// valueTypeNames stores the names of all value types.
+var valueTypeNames = map[valueType]string{
+	stringType: "string",
+	hashType:   "hash",
+	listType:   "list",
+}
+
+// String returns the name of type, such as string, hash and list.
+func (vt valueType) String() string {
+	if name, ok := valueTypeNames[vt]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// SetOptions is the options of setting an entry.
+type SetOptions struct {
+
+	// Ttl is the life of entry.
+	// The precision is millisecond.
+	Ttl time.Duration
+
+	// Expiration is the mode of counting ttl.
+	Expiration ExpirationMode
+
+	// MaxTtl is the max life of entry since it's created, which is only used in SlidingWithMax mode.
+	// The precision is millisecond.
+	MaxTtl time.Duration
+}
+
+// check returns an error if options are invalid.
+func (so *SetOptions) check() error {
+	if _, ok := expirationModeNames[so.Expiration]; !ok {
+		return unknownExpirationModeErr
+	}
+	return nil
+}
+
 // value is a box of data.
 type value struct {
 
@@ -27,29 +126,169 @@ type value struct {
 	Data []byte
 
 	// ttl is the life of value.
-	// The unit is second.
+	// The unit is millisecond.
 	Ttl int64
 
 	// ctime is the created time of value.
+	// The unit is millisecond.
 	Ctime int64
+
+	// Atime is the last visited time of value.
+	// The unit is millisecond.
+	Atime int64
+
+	// Expiration is the mode of counting ttl.
+	Expiration ExpirationMode
+
+	// MaxTtl is the max life of value since it's created, which is only used in SlidingWithMax mode.
+	// The unit is millisecond.
+	MaxTtl int64
+
+	// Version increases on each write of value, and it's never reused in one segment.
+	Version uint64
+
+	// Hash stores the fields of a hash value, and it's nil if value isn't a hash.
+	// It's changed in place under the write lock, but data of fields is never modified.
+	Hash map[string][]byte
+
+	// List stores the items of a list value, and it's nil if value isn't a list.
+	// It's changed in place under the write lock, but items are never modified.
+	List [][]byte
+
+	// listBuffer is the whole array holding List, which keeps the room before its head for pushing.
+	// It's nil if List isn't created by pushItems.
+	listBuffer [][]byte
+
+	// fieldsSize is the size of all fields in Hash or all items in List,
+	// so changing some of them doesn't need to count them all again.
+	fieldsSize int64
+}
+
+// unixMillis returns the unix time of t in milliseconds.
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
 }
 
-// newValue returns a new value with data and ttl.
-func newValue(data []byte, ttl int64) *value {
+// millisOf returns d in milliseconds.
+// A positive d less than one millisecond is rounded up, so it won't become NeverDie.
+func millisOf(d time.Duration) int64 {
+	millis := int64(d / time.Millisecond)
+	if d%time.Millisecond == 0 {
+		return millis
+	}
+
+	if d > 0 {
+		return millis + 1
+	}
+
+	if millis == 0 {
+		return -1
+	}
+	return millis
+}
+
+// newValue returns a new value with data and options created at now.
+// The unit of now is millisecond.
+func newValue(data []byte, options SetOptions, now int64) *value {
 	return &value{
-		Data:  helpers.Copy(data),
-		Ttl:   ttl,
-		Ctime: time.Now().Unix(),
+		Data:       helpers.Copy(data),
+		Ttl:        millisOf(options.Ttl),
+		Ctime:      now,
+		Atime:      now,
+		Expiration: options.Expiration,
+		MaxTtl:     millisOf(options.MaxTtl),
+	}
+}
+
+// deadline returns the time when this value dies in milliseconds.
+// Returns math.MaxInt64 if this value never dies.
+func (v *value) deadline() int64 {
+	if v.Ttl == NeverDie {
+		return math.MaxInt64
+	}
+
+	switch v.Expiration {
+	case Absolute:
+		return v.Ctime + v.Ttl
+	case SlidingWithMax:
+		deadline := atomic.LoadInt64(&v.Atime) + v.Ttl
+		if v.MaxTtl > 0 && v.Ctime+v.MaxTtl < deadline {
+			return v.Ctime + v.MaxTtl
+		}
+		return deadline
+	default:
+		return atomic.LoadInt64(&v.Atime) + v.Ttl
+	}
+}
+
+// alive returns if this value is alive at now or not.
+// The unit of now is millisecond.
+func (v *value) alive(now int64) bool {
+	return now < v.deadline()
+}
+
+// typ returns the type of value.
+func (v *value) typ() valueType {
+	if v.Hash != nil {
+		return hashType
+	}
+
+	if v.List != nil {
+		return listType
 	}
+	return stringType
 }
 
-// alive returns if this value is alive or not.
-func (v *value) alive() bool {
-	return v.Ttl == NeverDie || time.Now().Unix()-v.Ctime < v.Ttl
+// checkType returns an error wrapping wrongTypeErr if value isn't the expected type.
+func (v *value) checkType(expected valueType) error {
+	if actual := v.typ(); actual != expected {
+		return fmt.Errorf("%w: expected a %s but the key holds a %s", wrongTypeErr, expected, actual)
+	}
+	return nil
+}
+
+// size returns the size of data in value, including all fields of a hash and all items of a list.
+func (v *value) size() int64 {
+	return int64(len(v.Data)) + v.fieldsSize
+}
+
+// countFieldsSize counts the size of all fields in a hash or all items in a list, which is used after decoding a value.
+func (v *value) countFieldsSize() {
+	v.fieldsSize = sizeOfFields(v.Hash) + sizeOfItems(v.List)
+}
+
+// clone returns a copy of value.
+// It loads atime atomically, so it's safe to clone a value visited by readers holding the read lock.
+// A hash or list is copied because it's changed in place, but data of fields and items is shared.
+func (v *value) clone() *value {
+	var hash map[string][]byte
+	if v.Hash != nil {
+		hash = copyHash(v.Hash)
+	}
+
+	var list [][]byte
+	if v.List != nil {
+		list = make([][]byte, len(v.List))
+		copy(list, v.List)
+	}
+
+	return &value{
+		Data:       v.Data,
+		Ttl:        v.Ttl,
+		Ctime:      v.Ctime,
+		Atime:      atomic.LoadInt64(&v.Atime),
+		Expiration: v.Expiration,
+		MaxTtl:     v.MaxTtl,
+		Version:    v.Version,
+		Hash:       hash,
+		List:       list,
+		fieldsSize: v.fieldsSize,
+	}
 }
 
-// visit updates the ctime of value to now.
-func (v *value) visit() []byte {
-	atomic.SwapInt64(&v.Ctime, time.Now().Unix())
+// visit updates the atime of value to now, so a sliding value lives longer.
+// The unit of now is millisecond.
+func (v *value) visit(now int64) []byte {
+	atomic.StoreInt64(&v.Atime, now)
 	return v.Data
 }
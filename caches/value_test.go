@@ -16,20 +16,99 @@ import (
 // go test -cover -run=^TestValue$
 func TestValue(t *testing.T) {
 
-	v := newValue([]byte{}, 1)
-	if !v.alive() {
+	now := unixMillis(time.Now())
+	v := newValue([]byte{}, SetOptions{Ttl: 100 * time.Millisecond}, now)
+	if !v.alive(now) {
 		t.Fatalf("%v should be alive!", v)
 	}
 
-	time.Sleep(2 * time.Second)
-	if v.alive() {
+	if v.alive(now + 200) {
 		t.Fatalf("%v should be dead!", v)
 	}
 
-	v = newValue([]byte{}, 1)
-	time.Sleep(2 * time.Second)
-	v.visit()
-	if !v.alive() {
+	v = newValue([]byte{}, SetOptions{Ttl: 100 * time.Millisecond}, now)
+	v.visit(now + 200)
+	if !v.alive(now + 200) {
 		t.Fatalf("%v should be alive after visiting!", v)
 	}
 }
+
+// go test -cover -run=^TestValueExpiration$
+func TestValueExpiration(t *testing.T) {
+
+	now := unixMillis(time.Now())
+	second := int64(1000)
+	cases := []struct {
+		options SetOptions
+		ctime   int64
+		atime   int64
+		alive   bool
+	}{
+		{options: SetOptions{Ttl: 10 * time.Second, Expiration: Sliding}, ctime: now - 100*second, atime: now - 5*second, alive: true},
+		{options: SetOptions{Ttl: 10 * time.Second, Expiration: Sliding}, ctime: now - 100*second, atime: now - 15*second, alive: false},
+		{options: SetOptions{Ttl: 10 * time.Second, Expiration: Absolute}, ctime: now - 15*second, atime: now, alive: false},
+		{options: SetOptions{Ttl: 10 * time.Second, Expiration: Absolute}, ctime: now - 5*second, atime: now - 5*second, alive: true},
+		{options: SetOptions{Ttl: 10 * time.Second, Expiration: SlidingWithMax, MaxTtl: time.Minute}, ctime: now - 30*second, atime: now - 5*second, alive: true},
+		{options: SetOptions{Ttl: 10 * time.Second, Expiration: SlidingWithMax, MaxTtl: time.Minute}, ctime: now - 70*second, atime: now - 5*second, alive: false},
+		{options: SetOptions{Ttl: NeverDie, Expiration: Absolute}, ctime: now - 1000*second, atime: now - 1000*second, alive: true},
+		{options: SetOptions{Ttl: 1500 * time.Millisecond, Expiration: Absolute}, ctime: now - 1400, atime: now, alive: true},
+		{options: SetOptions{Ttl: 1500 * time.Millisecond, Expiration: Absolute}, ctime: now - 1600, atime: now, alive: false},
+	}
+
+	for _, c := range cases {
+		v := newValue([]byte("value"), c.options, now)
+		v.Ctime, v.Atime = c.ctime, c.atime
+		if v.alive(now) != c.alive {
+			t.Fatalf("Alive of %+v should be %v!", v, c.alive)
+		}
+	}
+
+	v := newValue([]byte("value"), SetOptions{Ttl: 10 * time.Second, Expiration: Absolute}, now)
+	v.Ctime -= 8 * second
+	v.visit(now)
+	if v.deadline() != v.Ctime+10*second {
+		t.Fatalf("Visiting an absolute value shouldn't extend its life! Deadline is %d.", v.deadline())
+	}
+
+	// Options of value should survive both the append log and the dump file.
+	v = newValue([]byte("value"), SetOptions{Ttl: 10 * time.Second, Expiration: SlidingWithMax, MaxTtl: time.Minute}, now)
+	v.Atime += 3
+	v.Version = 7
+	encoded := (&logRecord{op: setOp, seq: 1, key: "key", value: v}).encode()
+	record, err := decodeRecord(encoded[recordHeaderSize:])
+	if err != nil || string(record.value.Data) != "value" {
+		t.Fatalf("Decoded record %+v is wrong! Error is %v.", record.value, err)
+	}
+
+	if record.value.Atime != v.Atime || record.value.Expiration != v.Expiration || record.value.MaxTtl != v.MaxTtl || record.value.Version != v.Version {
+		t.Fatalf("Options of decoded record %+v are wrong!", record.value)
+	}
+
+	_, decoded, ok := decodeEntry(&fieldReader{data: encodeEntry(nil, "key", v)})
+	if !ok || decoded.Atime != v.Atime || decoded.Expiration != v.Expiration || decoded.MaxTtl != v.MaxTtl || decoded.Version != v.Version {
+		t.Fatalf("Decoded entry %+v is wrong!", decoded)
+	}
+
+	if _, err = ParseExpirationMode("sliding-max"); err != nil || SlidingWithMax.String() != "sliding-max" {
+		t.Fatalf("Parse expiration mode failed! Error is %v.", err)
+	}
+}
+
+// go test -cover -run=^TestMillisOf$
+func TestMillisOf(t *testing.T) {
+
+	cases := map[time.Duration]int64{
+		0:                       NeverDie,
+		time.Microsecond:        1,
+		1500 * time.Microsecond: 2,
+		time.Second:             1000,
+		-time.Microsecond:       -1,
+		-time.Second:            -1000,
+	}
+
+	for d, millis := range cases {
+		if millisOf(d) != millis {
+			t.Fatalf("Millis of %v should be %d but got %d!", d, millis, millisOf(d))
+		}
+	}
+}
@@ -9,14 +9,25 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/avino-plan/kafo/caches"
 	"github.com/avino-plan/kafo/servers"
 )
 
+const (
+	// closeTimeout is the max duration of closing the cache when exiting.
+	closeTimeout = time.Minute
+)
+
 func main() {
 
 	// Prepare options
@@ -32,19 +43,50 @@ func main() {
 	flag.IntVar(&cacheOptions.MaxEntrySize, "maxEntrySize", cacheOptions.MaxEntrySize, "The max memory size that entries can use. The unit is GB.")
 	flag.IntVar(&cacheOptions.MaxGcCount, "maxGcCount", cacheOptions.MaxGcCount, "The max count of entries that gc will clean.")
 	flag.IntVar(&cacheOptions.GcDuration, "gcDuration", cacheOptions.GcDuration, "The duration between two gc tasks. The unit is Minute.")
+	flag.IntVar(&cacheOptions.MaxExpireCount, "maxExpireCount", cacheOptions.MaxExpireCount, "The max count of entries checked in one segment by one active expiration task.")
+	flag.IntVar(&cacheOptions.ExpireDuration, "expireDuration", cacheOptions.ExpireDuration, "The duration between two active expiration tasks. The unit is Millisecond.")
 	flag.StringVar(&cacheOptions.DumpFile, "dumpFile", cacheOptions.DumpFile, "The file used to dump the cache.")
+	flag.BoolVar(&cacheOptions.DumpOnClose, "dumpOnClose", cacheOptions.DumpOnClose, "Dump the cache when it's closed by an interrupt or terminate signal.")
 	flag.IntVar(&cacheOptions.DumpDuration, "dumpDuration", cacheOptions.DumpDuration, "The duration between two dump tasks. The unit is Minute.")
+	saveRules := flag.String("saveRules", "", "The rules which trigger a dump by the number of writes, such as 60:1000,300:10 (seconds:changes).")
+	flag.StringVar(&cacheOptions.DumpDir, "dumpDir", cacheOptions.DumpDir, "The directory used to keep timestamped snapshots. The dumpFile is used if it's empty.")
+	flag.IntVar(&cacheOptions.DumpRetainCount, "dumpRetainCount", cacheOptions.DumpRetainCount, "The number of latest snapshots to keep in dumpDir.")
+	flag.IntVar(&cacheOptions.DumpRetainAge, "dumpRetainAge", cacheOptions.DumpRetainAge, "The max age of snapshots to keep in dumpDir. The unit is Hour.")
+	flag.StringVar(&cacheOptions.DumpCompression, "dumpCompression", cacheOptions.DumpCompression, "The compression of dump files (none, gzip, flate).")
+	flag.StringVar(&cacheOptions.DumpKeyFile, "dumpKeyFile", cacheOptions.DumpKeyFile, "The file storing the hex key used to encrypt dump files.")
+	flag.StringVar(&cacheOptions.DumpKeyEnv, "dumpKeyEnv", cacheOptions.DumpKeyEnv, "The environment variable storing the hex key used to encrypt dump files.")
+	restoreSnapshot := flag.String("restoreSnapshot", "", "The snapshot used to start the cache instead of the latest one.")
+	listSnapshots := flag.Bool("listSnapshots", false, "List all snapshots in dumpDir and exit.")
+	flag.BoolVar(&cacheOptions.AppendOnly, "appendOnly", cacheOptions.AppendOnly, "Record every write operation to append file.")
+	flag.StringVar(&cacheOptions.AppendFile, "appendFile", cacheOptions.AppendFile, "The file used to record write operations.")
+	flag.StringVar(&cacheOptions.AppendFsync, "appendFsync", cacheOptions.AppendFsync, "The policy of syncing append file to disk (always, everysec, no).")
+	flag.IntVar(&cacheOptions.AppendRewriteSize, "appendRewriteSize", cacheOptions.AppendRewriteSize, "The size of append file which triggers a rewrite. The unit is MB.")
 	flag.IntVar(&cacheOptions.MapSizeOfSegment, "mapSizeOfSegment", cacheOptions.MapSizeOfSegment, "The map size of segment.")
 	flag.IntVar(&cacheOptions.SegmentSize, "segmentSize", cacheOptions.SegmentSize, "The number of segment in a cache. This value should be the pow of 2 for precision.")
-	flag.IntVar(&cacheOptions.CasSleepTime, "casSleepTime", cacheOptions.CasSleepTime, "The time of sleep in one cas step. The unit is Microsecond.")
+	flag.IntVar(&cacheOptions.CasSleepTime, "casSleepTime", cacheOptions.CasSleepTime, "Deprecated: it's kept for old command lines but not used any more, since operations don't wait for dumping.")
+	flag.StringVar(&cacheOptions.EvictionPolicy, "evictionPolicy", cacheOptions.EvictionPolicy, "The policy used to evict entries when memory is full (lru, lfu, fifo, random, ttl, tinylfu).")
 	flag.Parse()
 
 	serverOptions.Cluster = nodesInCluster(*cluster)
+	rules, err := caches.ParseSaveRules(*saveRules)
+	if err != nil {
+		panic(err)
+	}
+	cacheOptions.SaveRules = rules
+
+	if *listSnapshots {
+		printSnapshots(cacheOptions)
+		return
+	}
 
 	// Initialize
-	cache := caches.NewCacheWith(cacheOptions)
-	cache.AutoGc()
-	cache.AutoDump()
+	cache, err := newCache(cacheOptions, *restoreSnapshot)
+	if err != nil {
+		panic(err)
+	}
+
+	cache.Start(context.Background())
+	closeOnSignal(cache)
 
 	server, err := servers.NewServer(cache, serverOptions)
 	if err != nil {
@@ -67,3 +109,43 @@ func nodesInCluster(cluster string) []string {
 	}
 	return strings.Split(cluster, ",")
 }
+
+// closeOnSignal starts a goroutine which closes cache and exits when an interrupt or terminate signal comes.
+func closeOnSignal(cache *caches.Cache) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received signal %v, closing the cache...\n", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		err := cache.Close(ctx)
+		cancel()
+
+		if err != nil {
+			log.Printf("Close the cache failed: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
+}
+
+// newCache returns a new cache recovered from snapshot if it isn't "", otherwise from the latest dump.
+func newCache(options caches.Options, snapshot string) (*caches.Cache, error) {
+	if snapshot != "" {
+		return caches.NewCacheFromSnapshot(options, snapshot)
+	}
+	return caches.NewCacheWith(options)
+}
+
+// printSnapshots prints all snapshots in dump dir of options.
+func printSnapshots(options caches.Options) {
+	snapshots, err := caches.ListSnapshots(options)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, snapshot := range snapshots {
+		fmt.Printf("%s\t%s\t%d\n", snapshot.Time.Format("2006-01-02 15:04:05.000"), snapshot.Path, snapshot.Size)
+	}
+}
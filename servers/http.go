@@ -10,16 +10,24 @@ package servers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"path"
 	"strconv"
+	"time"
 
 	"github.com/avino-plan/kafo/caches"
 	"github.com/avino-plan/kafo/helpers"
 	"github.com/julienschmidt/httprouter"
 )
 
+var (
+	// expireAtPassedErr means the time in header Expire-At has passed.
+	expireAtPassedErr = errors.New("the time in header Expire-At has passed")
+)
+
 // HTTPServer is a http type server.
 type HTTPServer struct {
 
@@ -33,6 +41,23 @@ type HTTPServer struct {
 	options *Options
 }
 
+// batchBody is the json body of batch requests and responses.
+// Values are encoded in base64 because they're bytes.
+type batchBody struct {
+
+	// Keys are the keys of mget and mdelete requests.
+	Keys []string `json:"keys,omitempty"`
+
+	// Entries are the keys and values of mset requests.
+	Entries map[string][]byte `json:"entries,omitempty"`
+
+	// Values are the values of keys existing in mget responses.
+	Values map[string][]byte `json:"values,omitempty"`
+
+	// Deleted is the count of deleted keys in mdelete responses.
+	Deleted int `json:"deleted"`
+}
+
 // NewHTTPServer returns a http server holder.
 func NewHTTPServer(cache *caches.Cache, options *Options) (*HTTPServer, error) {
 
@@ -42,7 +67,7 @@ func NewHTTPServer(cache *caches.Cache, options *Options) (*HTTPServer, error) {
 	}
 
 	return &HTTPServer{
-		node: n,
+		node:    n,
 		cache:   cache,
 		options: options,
 	}, nil
@@ -67,12 +92,38 @@ func (hs *HTTPServer) routerHandler() http.Handler {
 	router.GET(wrapUriWithVersion("/cache/:key"), hs.getHandler)
 	router.PUT(wrapUriWithVersion("/cache/:key"), hs.setHandler)
 	router.DELETE(wrapUriWithVersion("/cache/:key"), hs.deleteHandler)
+	router.POST(wrapUriWithVersion("/cache/:key/incr"), hs.incrHandler)
+	router.PUT(wrapUriWithVersion("/cache/:key/nx"), hs.setNXHandler)
+	router.PUT(wrapUriWithVersion("/cache/:key/xx"), hs.setXXHandler)
+	router.PUT(wrapUriWithVersion("/cache/:key/getset"), hs.getSetHandler)
+	router.POST(wrapUriWithVersion("/cache/:key/getdel"), hs.getDelHandler)
+	router.GET(wrapUriWithVersion("/cache/:key/ttl"), hs.ttlHandler)
+	router.PUT(wrapUriWithVersion("/cache/:key/ttl"), hs.expireHandler)
+	router.DELETE(wrapUriWithVersion("/cache/:key/ttl"), hs.persistHandler)
+	router.POST(wrapUriWithVersion("/cache/:key/touch"), hs.touchHandler)
+	router.DELETE(wrapUriWithVersion("/cache"), hs.deleteMatchingHandler)
+	router.POST(wrapUriWithVersion("/mget"), hs.mGetHandler)
+	router.PUT(wrapUriWithVersion("/mset"), hs.mSetHandler)
+	router.POST(wrapUriWithVersion("/mdelete"), hs.mDeleteHandler)
+	router.GET(wrapUriWithVersion("/hash/:key"), hs.hGetAllHandler)
+	router.GET(wrapUriWithVersion("/hash/:key/:field"), hs.hGetHandler)
+	router.PUT(wrapUriWithVersion("/hash/:key/:field"), hs.hSetHandler)
+	router.DELETE(wrapUriWithVersion("/hash/:key/:field"), hs.hDelHandler)
+	router.POST(wrapUriWithVersion("/hash/:key/:field/incr"), hs.hIncrHandler)
+	router.GET(wrapUriWithVersion("/list/:key"), hs.lRangeHandler)
+	router.POST(wrapUriWithVersion("/list/:key/lpush"), hs.lPushHandler)
+	router.POST(wrapUriWithVersion("/list/:key/rpush"), hs.rPushHandler)
+	router.POST(wrapUriWithVersion("/list/:key/lpop"), hs.lPopHandler)
+	router.POST(wrapUriWithVersion("/list/:key/rpop"), hs.rPopHandler)
+	router.POST(wrapUriWithVersion("/list/:key/trim"), hs.lTrimHandler)
+	router.GET(wrapUriWithVersion("/keys"), hs.keysHandler)
 	router.GET(wrapUriWithVersion("/status"), hs.statusHandler)
 	router.GET(wrapUriWithVersion("/nodes"), hs.nodesHandler)
 	return router
 }
 
 // getHandler is a handler for getting value of specified key.
+// The entry won't be visited if query peek is true, so a sliding entry won't live longer.
 func (hs *HTTPServer) getHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 
 	key := params.ByName("key")
@@ -83,63 +134,252 @@ func (hs *HTTPServer) getHandler(writer http.ResponseWriter, request *http.Reque
 	}
 
 	if !hs.isCurrentNode(node) {
-		writer.Header().Set("Location", node + request.RequestURI)
+		writer.Header().Set("Location", node+request.RequestURI)
 		writer.WriteHeader(http.StatusTemporaryRedirect)
 		return
 	}
 
-	value, ok := hs.cache.Get(key)
+	if peek, _ := strconv.ParseBool(request.URL.Query().Get("peek")); peek {
+		value, ok, err := hs.cache.Peek(key)
+		if err != nil {
+			hs.writeError(writer, err)
+			return
+		}
+
+		if !ok {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		writer.Write(value)
+		return
+	}
+
+	value, version, ok, err := hs.cache.GetWithVersion(key)
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+
 	if !ok {
 		writer.WriteHeader(http.StatusNotFound)
 		return
 	}
+
+	writer.Header().Set("ETag", etagOf(version))
 	writer.Write(value)
 }
 
+// etagOf returns the entity tag of version.
+func etagOf(version uint64) string {
+	return strconv.Quote(strconv.FormatUint(version, 10))
+}
+
+// versionOf returns the version in entity tag and an error if failed.
+func versionOf(etag string) (uint64, error) {
+	if unquoted, err := strconv.Unquote(etag); err == nil {
+		etag = unquoted
+	}
+	return strconv.ParseUint(etag, 10, 64)
+}
+
 // setHandler is a handler for setting an entry of specified key and value.
 func (hs *HTTPServer) setHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 
+	key, value, options, ok := hs.entryOf(writer, request, params)
+	if !ok {
+		return
+	}
+
+	if ifMatch := request.Header.Get("If-Match"); ifMatch != "" {
+		hs.compareAndSet(writer, key, value, ifMatch, options)
+		return
+	}
+
+	err := hs.cache.SetWithOptions(key, value, options)
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+	writer.WriteHeader(http.StatusCreated)
+}
+
+// entryOf returns the key, value and set options of entry in request.
+// Returns false if the response has been written, such as redirecting to another node or a bad request.
+func (hs *HTTPServer) entryOf(writer http.ResponseWriter, request *http.Request, params httprouter.Params) (string, []byte, caches.SetOptions, bool) {
+
 	key := params.ByName("key")
 	node, err := hs.selectNode(key)
 	if err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
-		return
+		return key, nil, caches.SetOptions{}, false
 	}
 
 	if !hs.isCurrentNode(node) {
 		writer.Header().Set("Location", node+request.RequestURI)
 		writer.WriteHeader(http.StatusTemporaryRedirect)
-		return
+		return key, nil, caches.SetOptions{}, false
 	}
 
 	value, err := ioutil.ReadAll(request.Body)
 	if err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
+		return key, nil, caches.SetOptions{}, false
+	}
+
+	options, err := hs.setOptionsOf(request)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte("Error: " + err.Error()))
+		return key, nil, caches.SetOptions{}, false
+	}
+	return key, value, options, true
+}
+
+// setNXHandler is a handler for setting an entry of specified key and value only if the key doesn't exist.
+// It responds 409 if the key exists.
+func (hs *HTTPServer) setNXHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+
+	key, value, options, ok := hs.entryOf(writer, request, params)
+	if !ok {
 		return
 	}
 
-	ttl, err := ttlOf(request)
+	ok, err := hs.cache.SetNX(key, value, options)
 	if err != nil {
-		writer.WriteHeader(http.StatusInternalServerError)
+		hs.writeError(writer, err)
+		return
+	}
+
+	if !ok {
+		writer.WriteHeader(http.StatusConflict)
+		return
+	}
+	writer.WriteHeader(http.StatusCreated)
+}
+
+// setXXHandler is a handler for setting an entry of specified key and value only if the key exists.
+// It responds 404 if the key doesn't exist.
+func (hs *HTTPServer) setXXHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+
+	key, value, options, ok := hs.entryOf(writer, request, params)
+	if !ok {
 		return
 	}
 
-	err = hs.cache.SetWithTTL(key, value, ttl)
+	ok, err := hs.cache.SetXX(key, value, options)
 	if err != nil {
-		writer.WriteHeader(http.StatusRequestEntityTooLarge)
+		hs.writeError(writer, err)
+		return
+	}
+
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writer.WriteHeader(http.StatusCreated)
+}
+
+// getSetHandler is a handler for setting an entry of specified key and value and getting the old value.
+// It responds 200 with the old value if the key existed, otherwise 201.
+func (hs *HTTPServer) getSetHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+
+	key, value, options, ok := hs.entryOf(writer, request, params)
+	if !ok {
+		return
+	}
+
+	oldValue, existed, err := hs.cache.GetSet(key, value, options)
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+
+	if !existed {
+		writer.WriteHeader(http.StatusCreated)
+		return
+	}
+	writer.Write(oldValue)
+}
+
+// compareAndSet sets an entry of specified key and value only if its version matches the entity tag in ifMatch.
+// The version of a key not existing is 0, and 412 will be responded with the current version if it conflicts.
+func (hs *HTTPServer) compareAndSet(writer http.ResponseWriter, key string, value []byte, ifMatch string, options caches.SetOptions) {
+	expected, err := versionOf(ifMatch)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
 		writer.Write([]byte("Error: " + err.Error()))
 		return
 	}
+
+	version, ok, err := hs.cache.CompareAndSetWithOptions(key, value, expected, options)
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+
+	writer.Header().Set("ETag", etagOf(version))
+	if !ok {
+		writer.WriteHeader(http.StatusPreconditionFailed)
+		writer.Write([]byte("Error: version conflict"))
+		return
+	}
 	writer.WriteHeader(http.StatusCreated)
 }
 
-// ttlOf returns ttl of this value in request and an error.
-func ttlOf(request *http.Request) (int64, error) {
-	ttls, ok := request.Header["Ttl"]
-	if !ok || len(ttls) < 1 {
-		return caches.NeverDie, nil
+// durationOf returns the duration in header name of request and an error.
+// The value of header is in unit, and the header in milliseconds named name-Ms is used first.
+func durationOf(request *http.Request, name string, unit time.Duration) (time.Duration, error) {
+	if millis := request.Header.Get(name + "-Ms"); millis != "" {
+		d, err := strconv.ParseInt(millis, 10, 64)
+		return time.Duration(d) * time.Millisecond, err
+	}
+
+	if value := request.Header.Get(name); value != "" {
+		d, err := strconv.ParseInt(value, 10, 64)
+		return time.Duration(d) * unit, err
+	}
+	return caches.NeverDie, nil
+}
+
+// setOptionsOf returns set options of this value in request and an error.
+// The options are in headers Ttl (or Ttl-Ms), Expiration (sliding, absolute, sliding-max) and Max-Ttl (or Max-Ttl-Ms).
+// If header Expire-At is set in RFC 3339, the value will be absolute and die at that time.
+func (hs *HTTPServer) setOptionsOf(request *http.Request) (caches.SetOptions, error) {
+	ttl, err := durationOf(request, "Ttl", time.Second)
+	if err != nil {
+		return caches.SetOptions{}, err
+	}
+
+	options := caches.SetOptions{
+		Ttl:        ttl,
+		Expiration: caches.Sliding,
+	}
+
+	if expireAt := request.Header.Get("Expire-At"); expireAt != "" {
+		at, err := time.Parse(time.RFC3339Nano, expireAt)
+		if err != nil {
+			return options, err
+		}
+
+		// Use the clock of cache, so the ttl is the same as the cache sees.
+		options.Ttl = at.Sub(hs.cache.Now())
+		options.Expiration = caches.Absolute
+		if options.Ttl <= 0 {
+			return options, expireAtPassedErr
+		}
+		return options, nil
 	}
-	return strconv.ParseInt(ttls[0], 10, 64)
+
+	if expiration := request.Header.Get("Expiration"); expiration != "" {
+		options.Expiration, err = caches.ParseExpirationMode(expiration)
+		if err != nil {
+			return options, err
+		}
+	}
+
+	options.MaxTtl, err = durationOf(request, "Max-Ttl", time.Second)
+	return options, err
 }
 
 // deleteHandler is a handler for deleting the entry of specified key.
@@ -158,10 +398,656 @@ func (hs *HTTPServer) deleteHandler(writer http.ResponseWriter, request *http.Re
 	}
 
 	err = hs.cache.Delete(key)
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+}
+
+// incrHandler is a handler for adding delta to the value of specified key.
+// The delta is in header Delta and it's 1 if missing, and the result will be written in decimal.
+func (hs *HTTPServer) incrHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	node, err := hs.selectNode(key)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !hs.isCurrentNode(node) {
+		writer.Header().Set("Location", node+request.RequestURI)
+		writer.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
+
+	delta, err := deltaOf(request)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte("Error: " + err.Error()))
+		return
+	}
+
+	result, err := hs.cache.IncrBy(key, delta)
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+	writer.Write([]byte(strconv.FormatInt(result, 10)))
+}
+
+// deltaOf returns the delta in header Delta of request, and it's 1 if missing.
+func deltaOf(request *http.Request) (int64, error) {
+	value := request.Header.Get("Delta")
+	if value == "" {
+		return 1, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// getDelHandler is a handler for deleting the entry of specified key and getting its value.
+// It responds 404 if the key doesn't exist.
+func (hs *HTTPServer) getDelHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	node, err := hs.selectNode(key)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !hs.isCurrentNode(node) {
+		writer.Header().Set("Location", node+request.RequestURI)
+		writer.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
+
+	value, ok, err := hs.cache.GetDel(key)
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writer.Write(value)
+}
+
+// ttlHandler is a handler for getting the remaining life of specified key.
+// The remaining life is written in milliseconds, and 0 means it never dies.
+func (hs *HTTPServer) ttlHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key, ok := hs.keyOf(writer, request, params)
+	if !ok {
+		return
+	}
+
+	ttl, ok, err := hs.cache.TTL(key)
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writer.Write([]byte(strconv.FormatInt(int64(ttl/time.Millisecond), 10)))
+}
+
+// expireHandler is a handler for making the entry of specified key die after the ttl in header Ttl (or Ttl-Ms).
+// The entry will be deleted if ttl isn't positive.
+func (hs *HTTPServer) expireHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key, ok := hs.keyOf(writer, request, params)
+	if !ok {
+		return
+	}
+
+	if request.Header.Get("Ttl") == "" && request.Header.Get("Ttl-Ms") == "" {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte("Error: header Ttl or Ttl-Ms is required"))
+		return
+	}
+
+	ttl, err := durationOf(request, "Ttl", time.Second)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte("Error: " + err.Error()))
+		return
+	}
+
+	hs.writeFound(writer, func() (bool, error) {
+		return hs.cache.Expire(key, ttl)
+	})
+}
+
+// persistHandler is a handler for making the entry of specified key never die.
+func (hs *HTTPServer) persistHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key, ok := hs.keyOf(writer, request, params)
+	if !ok {
+		return
+	}
+
+	hs.writeFound(writer, func() (bool, error) {
+		return hs.cache.Persist(key)
+	})
+}
+
+// touchHandler is a handler for visiting the entry of specified key without reading it.
+func (hs *HTTPServer) touchHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key, ok := hs.keyOf(writer, request, params)
+	if !ok {
+		return
+	}
+
+	hs.writeFound(writer, func() (bool, error) {
+		return hs.cache.Touch(key)
+	})
+}
+
+// keyOf returns the key in params.
+// Returns false if the response has been written, such as redirecting to another node.
+func (hs *HTTPServer) keyOf(writer http.ResponseWriter, request *http.Request, params httprouter.Params) (string, bool) {
+	key := params.ByName("key")
+	node, err := hs.selectNode(key)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return key, false
+	}
+
+	if !hs.isCurrentNode(node) {
+		writer.Header().Set("Location", node+request.RequestURI)
+		writer.WriteHeader(http.StatusTemporaryRedirect)
+		return key, false
+	}
+	return key, true
+}
+
+// writeFound runs op and writes 404 if op returns false.
+func (hs *HTTPServer) writeFound(writer http.ResponseWriter, op func() (bool, error)) {
+	ok, err := op()
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// deleteMatchingHandler is a handler for deleting keys matching query match in this node.
+// All keys will be deleted by FlushAll if query flush is true, and the count of deleted keys will be written.
+func (hs *HTTPServer) deleteMatchingHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	query := request.URL.Query()
+	flush, _ := strconv.ParseBool(query.Get("flush"))
+	pattern := query.Get("match")
+	if pattern == "" && !flush {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte("Error: query match or flush is required"))
+		return
+	}
+
+	var count int
+	var err error
+	if flush {
+		count, err = hs.cache.FlushAll()
+	} else {
+		count, err = hs.cache.DeleteMatching(pattern)
+	}
+
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+	writer.Write([]byte(strconv.Itoa(count)))
+}
+
+// mGetHandler is a handler for getting values of keys in json body.
+// The values of keys existing will be written in json, and all keys should belong to this node.
+func (hs *HTTPServer) mGetHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	body, ok := hs.batchBodyOf(writer, request)
+	if !ok || !hs.checkNodes(writer, body.Keys) {
+		return
+	}
+
+	values, err := hs.cache.MGet(body.Keys)
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+	hs.writeJSON(writer, batchBody{Values: values})
+}
+
+// mSetHandler is a handler for setting entries in json body with options in headers.
+// All keys should belong to this node.
+func (hs *HTTPServer) mSetHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	body, ok := hs.batchBodyOf(writer, request)
+	if !ok {
+		return
+	}
+
+	keys := make([]string, 0, len(body.Entries))
+	for key := range body.Entries {
+		keys = append(keys, key)
+	}
+
+	if !hs.checkNodes(writer, keys) {
+		return
+	}
+
+	options, err := hs.setOptionsOf(request)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte("Error: " + err.Error()))
+		return
+	}
+
+	if err = hs.cache.MSet(body.Entries, options); err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+	writer.WriteHeader(http.StatusCreated)
+}
+
+// mDeleteHandler is a handler for deleting keys in json body.
+// The count of deleted keys will be written in json, and all keys should belong to this node.
+func (hs *HTTPServer) mDeleteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	body, ok := hs.batchBodyOf(writer, request)
+	if !ok || !hs.checkNodes(writer, body.Keys) {
+		return
+	}
+
+	deleted, err := hs.cache.MDelete(body.Keys)
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+	hs.writeJSON(writer, batchBody{Deleted: deleted})
+}
+
+// batchBodyOf returns the batch body in request.
+// Returns false if the response has been written because of a bad request.
+func (hs *HTTPServer) batchBodyOf(writer http.ResponseWriter, request *http.Request) (batchBody, bool) {
+	body := batchBody{}
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte("Error: " + err.Error()))
+		return body, false
+	}
+	return body, true
+}
+
+// checkNodes checks if all keys belong to this node.
+// Returns false if the response has been written because any of keys belongs to another node.
+func (hs *HTTPServer) checkNodes(writer http.ResponseWriter, keys []string) bool {
+	for _, key := range keys {
+		node, err := hs.selectNode(key)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return false
+		}
+
+		if !hs.isCurrentNode(node) {
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write([]byte(fmt.Sprintf("Error: key %s belongs to node %s", key, node)))
+			return false
+		}
+	}
+	return true
+}
+
+// writeError writes err from cache with a status code decided by its kind.
+func (hs *HTTPServer) writeError(writer http.ResponseWriter, err error) {
+	switch {
+	case caches.IsInvalidArgument(err):
+		writer.WriteHeader(http.StatusBadRequest)
+	case caches.IsEntrySizeExceeded(err):
+		writer.WriteHeader(http.StatusRequestEntityTooLarge)
+	case caches.IsClosed(err):
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		writer.WriteHeader(http.StatusInternalServerError)
+	}
+	writer.Write([]byte("Error: " + err.Error()))
+}
+
+// writeJSON writes v in json.
+func (hs *HTTPServer) writeJSON(writer http.ResponseWriter, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Write(body)
+}
+
+// hGetAllHandler is a handler for getting all fields in the hash of specified key.
+// The fields will be written in json, or the count of fields will be written if query len is true.
+func (hs *HTTPServer) hGetAllHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key, ok := hs.keyOf(writer, request, params)
+	if !ok {
+		return
+	}
+
+	if length, _ := strconv.ParseBool(request.URL.Query().Get("len")); length {
+		count, err := hs.cache.HLen(key)
+		if err != nil {
+			hs.writeError(writer, err)
+			return
+		}
+
+		writer.Write([]byte(strconv.Itoa(count)))
+		return
+	}
+
+	fields, err := hs.cache.HGetAll(key)
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+	hs.writeJSON(writer, fields)
+}
+
+// hGetHandler is a handler for getting the data of field in the hash of specified key.
+// It responds 404 if the key or field doesn't exist.
+func (hs *HTTPServer) hGetHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key, ok := hs.keyOf(writer, request, params)
+	if !ok {
+		return
+	}
+
+	data, ok, err := hs.cache.HGet(key, params.ByName("field"))
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writer.Write(data)
+}
+
+// hSetHandler is a handler for setting the field in the hash of specified key to the data in body.
+// It responds 201 if the field is new.
+func (hs *HTTPServer) hSetHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key, ok := hs.keyOf(writer, request, params)
+	if !ok {
+		return
+	}
+
+	data, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	count, err := hs.cache.HSet(key, map[string][]byte{params.ByName("field"): data})
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+
+	if count > 0 {
+		writer.WriteHeader(http.StatusCreated)
+	}
+}
+
+// hDelHandler is a handler for deleting the field in the hash of specified key.
+// It responds 404 if the key or field doesn't exist.
+func (hs *HTTPServer) hDelHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key, ok := hs.keyOf(writer, request, params)
+	if !ok {
+		return
+	}
+
+	count, err := hs.cache.HDel(key, params.ByName("field"))
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+
+	if count <= 0 {
+		writer.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// hIncrHandler is a handler for adding delta to the data of field in the hash of specified key.
+// The delta is in header Delta and it's 1 if missing, and the result will be written in decimal.
+func (hs *HTTPServer) hIncrHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key, ok := hs.keyOf(writer, request, params)
+	if !ok {
+		return
+	}
+
+	delta, err := deltaOf(request)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte("Error: " + err.Error()))
+		return
+	}
+
+	result, err := hs.cache.HIncrBy(key, params.ByName("field"), delta)
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+	writer.Write([]byte(strconv.FormatInt(result, 10)))
+}
+
+// lRangeHandler is a handler for getting items between query start and stop in the list of specified key.
+// Start is 0 and stop is -1 if missing, so all items will be written in json by default.
+// The length of list will be written if query len is true.
+func (hs *HTTPServer) lRangeHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key, ok := hs.keyOf(writer, request, params)
+	if !ok {
+		return
+	}
+
+	if length, _ := strconv.ParseBool(request.URL.Query().Get("len")); length {
+		length, err := hs.cache.LLen(key)
+		if err != nil {
+			hs.writeError(writer, err)
+			return
+		}
+
+		writer.Write([]byte(strconv.Itoa(length)))
+		return
+	}
+
+	start, stop, err := rangeOf(request)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte("Error: " + err.Error()))
+		return
+	}
+
+	items, err := hs.cache.LRange(key, start, stop)
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+	hs.writeJSON(writer, items)
+}
+
+// lPushHandler is a handler for pushing the item in body to the head of the list of specified key.
+// The length of list will be written.
+func (hs *HTTPServer) lPushHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	hs.push(writer, request, params, true)
+}
+
+// rPushHandler is a handler for pushing the item in body to the tail of the list of specified key.
+// The length of list will be written.
+func (hs *HTTPServer) rPushHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	hs.push(writer, request, params, false)
+}
+
+// push pushes the item in body to the head of list if left is true, otherwise to the tail.
+func (hs *HTTPServer) push(writer http.ResponseWriter, request *http.Request, params httprouter.Params, left bool) {
+	key, ok := hs.keyOf(writer, request, params)
+	if !ok {
+		return
+	}
+
+	item, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var length int
+	if left {
+		length, err = hs.cache.LPush(key, item)
+	} else {
+		length, err = hs.cache.RPush(key, item)
+	}
+
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+	writer.Write([]byte(strconv.Itoa(length)))
+}
+
+// lPopHandler is a handler for popping the head of the list of specified key.
+// See pop for blocking and responses.
+func (hs *HTTPServer) lPopHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	hs.pop(writer, request, params, true)
+}
+
+// rPopHandler is a handler for popping the tail of the list of specified key.
+// See pop for blocking and responses.
+func (hs *HTTPServer) rPopHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	hs.pop(writer, request, params, false)
+}
+
+// pop pops the head of list if left is true, otherwise the tail.
+// If header Timeout (or Timeout-Ms) is set, the request will be held until an item arrives or it times out,
+// and 0 means it never times out. It responds 404 if the list is empty or it times out.
+// A blocking pop pushes the item back if the client is gone before the item is written, like blocking pops of TCP.
+// However, a response lost after being written can't be found, so the item is delivered at most once then.
+func (hs *HTTPServer) pop(writer http.ResponseWriter, request *http.Request, params httprouter.Params, left bool) {
+	key, ok := hs.keyOf(writer, request, params)
+	if !ok {
+		return
+	}
+
+	var item []byte
+	var err error
+	var undo func()
+	if request.Header.Get("Timeout") == "" && request.Header.Get("Timeout-Ms") == "" {
+		if left {
+			item, ok, err = hs.cache.LPop(key)
+		} else {
+			item, ok, err = hs.cache.RPop(key)
+		}
+	} else {
+		timeout, timeoutErr := durationOf(request, "Timeout", time.Second)
+		if timeoutErr != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write([]byte("Error: " + timeoutErr.Error()))
+			return
+		}
+
+		pop, push := hs.cache.BRPop, hs.cache.RPush
+		if left {
+			pop, push = hs.cache.BLPop, hs.cache.LPush
+		}
+
+		item, ok, err = pop(request.Context(), key, timeout)
+		undo = func() {
+			push(key, item)
+		}
+	}
+
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// The client may be gone while the item is popped, and nobody will read it then.
+	if undo != nil && request.Context().Err() != nil {
+		undo()
+		return
+	}
+
+	if _, err = writer.Write(item); err != nil && undo != nil {
+		undo()
+	}
+}
+
+// lTrimHandler is a handler for keeping items between query start and stop in the list of specified key.
+// Indexes are the same as lRangeHandler.
+func (hs *HTTPServer) lTrimHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key, ok := hs.keyOf(writer, request, params)
+	if !ok {
+		return
+	}
+
+	start, stop, err := rangeOf(request)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.Write([]byte("Error: " + err.Error()))
+		return
+	}
+
+	if err = hs.cache.LTrim(key, start, stop); err != nil {
+		hs.writeError(writer, err)
+	}
+}
+
+// rangeOf returns query start and stop of request, which are 0 and -1 if missing.
+func rangeOf(request *http.Request) (int, int, error) {
+	query := request.URL.Query()
+	start, stop := 0, -1
+	var err error
+	if value := query.Get("start"); value != "" {
+		if start, err = strconv.Atoi(value); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if value := query.Get("stop"); value != "" {
+		if stop, err = strconv.Atoi(value); err != nil {
+			return 0, 0, err
+		}
+	}
+	return start, stop, nil
+}
+
+// keysHandler is a handler for scanning keys in this node.
+// The query is cursor, match and count, and a ScanResult in json will be written.
+func (hs *HTTPServer) keysHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	query := request.URL.Query()
+	count := 0
+	if value := query.Get("count"); value != "" {
+		var err error
+		count, err = strconv.Atoi(value)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			writer.Write([]byte("Error: " + err.Error()))
+			return
+		}
+	}
+
+	keys, cursor, err := hs.cache.Scan(query.Get("cursor"), query.Get("match"), count)
+	if err != nil {
+		hs.writeError(writer, err)
+		return
+	}
+
+	result, err := json.Marshal(ScanResult{Keys: keys, Cursor: cursor})
 	if err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	writer.Write(result)
 }
 
 // statusHandler is handler for fetching the status of cache.
@@ -15,6 +15,16 @@ const (
 	APIVersion = "v1"
 )
 
+// ScanResult is the result of scanning keys in one node.
+type ScanResult struct {
+
+	// Keys are the keys scanned.
+	Keys []string `json:"keys"`
+
+	// Cursor is the cursor for next scan, and it's empty if the scan is finished.
+	Cursor string `json:"cursor"`
+}
+
 // Server is an interface of servers.
 type Server interface {
 
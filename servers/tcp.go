@@ -9,14 +9,15 @@
 package servers
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/avino-plan/kafo/caches"
 	"github.com/avino-plan/kafo/helpers"
-	"github.com/FishGoddess/vex"
 )
 
 const (
@@ -34,14 +35,133 @@ const (
 
 	// nodesCommand is the command of nodes operation.
 	nodesCommand = byte(5)
+
+	// expireAtCommand is the command of expire at operation.
+	expireAtCommand = byte(6)
+
+	// incrByCommand is the command of incr by operation.
+	incrByCommand = byte(7)
+
+	// getWithVersionCommand is the command of get with version operation.
+	getWithVersionCommand = byte(8)
+
+	// compareAndSetCommand is the command of compare and set operation.
+	compareAndSetCommand = byte(9)
+
+	// setNXCommand is the command of set if not exists operation.
+	setNXCommand = byte(10)
+
+	// setXXCommand is the command of set if exists operation.
+	setXXCommand = byte(11)
+
+	// getSetCommand is the command of get and set operation.
+	getSetCommand = byte(12)
+
+	// getDelCommand is the command of get and delete operation.
+	getDelCommand = byte(13)
+
+	// ttlCommand is the command of ttl operation.
+	ttlCommand = byte(14)
+
+	// expireCommand is the command of expire operation.
+	expireCommand = byte(15)
+
+	// persistCommand is the command of persist operation.
+	persistCommand = byte(16)
+
+	// touchCommand is the command of touch operation.
+	touchCommand = byte(17)
+
+	// peekCommand is the command of peek operation.
+	peekCommand = byte(18)
+
+	// scanCommand is the command of scan operation.
+	scanCommand = byte(19)
+
+	// deleteMatchingCommand is the command of delete matching operation.
+	deleteMatchingCommand = byte(20)
+
+	// flushAllCommand is the command of flush all operation.
+	flushAllCommand = byte(21)
+
+	// mGetCommand is the command of multi get operation.
+	mGetCommand = byte(22)
+
+	// mSetCommand is the command of multi set operation.
+	mSetCommand = byte(23)
+
+	// mDeleteCommand is the command of multi delete operation.
+	mDeleteCommand = byte(24)
+
+	// hSetCommand is the command of hash set operation.
+	hSetCommand = byte(25)
+
+	// hGetCommand is the command of hash get operation.
+	hGetCommand = byte(26)
+
+	// hDelCommand is the command of hash delete operation.
+	hDelCommand = byte(27)
+
+	// hGetAllCommand is the command of hash get all operation.
+	hGetAllCommand = byte(28)
+
+	// hIncrByCommand is the command of hash incr by operation.
+	hIncrByCommand = byte(29)
+
+	// hLenCommand is the command of hash length operation.
+	hLenCommand = byte(30)
+
+	// lPushCommand is the command of list left push operation.
+	lPushCommand = byte(31)
+
+	// rPushCommand is the command of list right push operation.
+	rPushCommand = byte(32)
+
+	// lPopCommand is the command of list left pop operation.
+	lPopCommand = byte(33)
+
+	// rPopCommand is the command of list right pop operation.
+	rPopCommand = byte(34)
+
+	// lRangeCommand is the command of list range operation.
+	lRangeCommand = byte(35)
+
+	// lLenCommand is the command of list length operation.
+	lLenCommand = byte(36)
+
+	// lTrimCommand is the command of list trim operation.
+	lTrimCommand = byte(37)
+
+	// bLPopCommand is the command of list blocking left pop operation.
+	bLPopCommand = byte(38)
+
+	// bRPopCommand is the command of list blocking right pop operation.
+	bRPopCommand = byte(39)
+)
+
+const (
+	// secondUnit means ttls in arguments are in seconds.
+	secondUnit = byte(0)
+
+	// millisecondUnit means ttls in arguments are in milliseconds.
+	millisecondUnit = byte(1)
 )
 
 var (
 	// commandNeedsMoreArgumentsErr means command needs more arguments.
 	commandNeedsMoreArgumentsErr = errors.New("command needs more arguments")
 
+	// invalidArgumentsErr means arguments of command are invalid.
+	invalidArgumentsErr = errors.New("arguments of command are invalid")
+
 	// notFoundErr means not found.
 	notFoundErr = errors.New("not found")
+
+	// versionConflictErr means the version of key isn't the expected one.
+	versionConflictErr = errors.New("version conflict")
+
+	// existsErr means the key already exists.
+	existsErr = errors.New("already exists")
 )
 
 // TCPServer is a tcp type server.
@@ -54,7 +174,8 @@ type TCPServer struct {
 	cache *caches.Cache
 
 	// server is the real tcp server used inside.
-	server *vex.Server
+	// It speaks the protocol of vex, and blocking handlers can know if the connection is closed.
+	server *vexServer
 
 	// options stores all settings of server.
 	options *Options
@@ -69,9 +190,9 @@ func NewTCPServer(cache *caches.Cache, options *Options) (*TCPServer, error) {
 	}
 
 	return &TCPServer{
-		node: n,
+		node:    n,
 		cache:   cache,
-		server:  vex.NewServer(),
+		server:  newVexServer(),
 		options: options,
 	}, nil
 }
@@ -83,6 +204,40 @@ func (ts *TCPServer) Run() error {
 	ts.server.RegisterHandler(deleteCommand, ts.deleteHandler)
 	ts.server.RegisterHandler(statusCommand, ts.statusHandler)
 	ts.server.RegisterHandler(nodesCommand, ts.nodesHandler)
+	ts.server.RegisterHandler(expireAtCommand, ts.expireAtHandler)
+	ts.server.RegisterHandler(incrByCommand, ts.incrByHandler)
+	ts.server.RegisterHandler(getWithVersionCommand, ts.getWithVersionHandler)
+	ts.server.RegisterHandler(compareAndSetCommand, ts.compareAndSetHandler)
+	ts.server.RegisterHandler(setNXCommand, ts.setNXHandler)
+	ts.server.RegisterHandler(setXXCommand, ts.setXXHandler)
+	ts.server.RegisterHandler(getSetCommand, ts.getSetHandler)
+	ts.server.RegisterHandler(getDelCommand, ts.getDelHandler)
+	ts.server.RegisterHandler(ttlCommand, ts.ttlHandler)
+	ts.server.RegisterHandler(expireCommand, ts.expireHandler)
+	ts.server.RegisterHandler(persistCommand, ts.persistHandler)
+	ts.server.RegisterHandler(touchCommand, ts.touchHandler)
+	ts.server.RegisterHandler(peekCommand, ts.peekHandler)
+	ts.server.RegisterHandler(scanCommand, ts.scanHandler)
+	ts.server.RegisterHandler(deleteMatchingCommand, ts.deleteMatchingHandler)
+	ts.server.RegisterHandler(flushAllCommand, ts.flushAllHandler)
+	ts.server.RegisterHandler(mGetCommand, ts.mGetHandler)
+	ts.server.RegisterHandler(mSetCommand, ts.mSetHandler)
+	ts.server.RegisterHandler(mDeleteCommand, ts.mDeleteHandler)
+	ts.server.RegisterHandler(hSetCommand, ts.hSetHandler)
+	ts.server.RegisterHandler(hGetCommand, ts.hGetHandler)
+	ts.server.RegisterHandler(hDelCommand, ts.hDelHandler)
+	ts.server.RegisterHandler(hGetAllCommand, ts.hGetAllHandler)
+	ts.server.RegisterHandler(hIncrByCommand, ts.hIncrByHandler)
+	ts.server.RegisterHandler(hLenCommand, ts.hLenHandler)
+	ts.server.RegisterHandler(lPushCommand, ts.lPushHandler)
+	ts.server.RegisterHandler(rPushCommand, ts.rPushHandler)
+	ts.server.RegisterHandler(lPopCommand, ts.lPopHandler)
+	ts.server.RegisterHandler(rPopCommand, ts.rPopHandler)
+	ts.server.RegisterHandler(lRangeCommand, ts.lRangeHandler)
+	ts.server.RegisterHandler(lLenCommand, ts.lLenHandler)
+	ts.server.RegisterHandler(lTrimCommand, ts.lTrimHandler)
+	ts.server.RegisterConnHandler(bLPopCommand, ts.bLPopHandler)
+	ts.server.RegisterConnHandler(bRPopCommand, ts.bRPopHandler)
 	return ts.server.ListenAndServe("tcp", helpers.JoinAddressAndPort(ts.options.Address, ts.options.Port))
 }
 
@@ -95,74 +250,950 @@ func (ts *TCPServer) Close() error {
 
 // getHandler is a handler for getting value of specified key.
 func (ts *TCPServer) getHandler(args [][]byte) (body []byte, err error) {
-	if len(args) < 1 {
-		return nil, commandNeedsMoreArgumentsErr
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
 	}
 
-	key := string(args[0])
-	node, err := ts.selectNode(key)
+	value, ok, err := ts.cache.Get(key)
 	if err != nil {
 		return nil, err
 	}
 
-	if !ts.isCurrentNode(node) {
-		return nil, fmt.Errorf("redirect to node %s", node)
+	if !ok {
+		return value, notFoundErr
+	}
+	return value, nil
+}
+
+// peekHandler is a handler for getting value of specified key without visiting it.
+func (ts *TCPServer) peekHandler(args [][]byte) (body []byte, err error) {
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok, err := ts.cache.Peek(key)
+	if err != nil {
+		return nil, err
 	}
 
-	value, ok := ts.cache.Get(key)
 	if !ok {
-		return value, notFoundErr
+		return nil, notFoundErr
 	}
 	return value, nil
 }
 
+// getWithVersionHandler is a handler for getting value of specified key and its version.
+// The body is the version in uint64 followed by the value.
+func (ts *TCPServer) getWithVersionHandler(args [][]byte) (body []byte, err error) {
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	value, version, ok, err := ts.cache.GetWithVersion(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, notFoundErr
+	}
+
+	body = make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(body, version)
+	return append(body, value...), nil
+}
+
 // setHandler is a handler for setting an entry of specified key and value.
 func (ts *TCPServer) setHandler(args [][]byte) (body []byte, err error) {
-	if len(args) < 3 {
-		return nil, commandNeedsMoreArgumentsErr
+	key, options, err := ts.setArgs(args)
+	if err != nil {
+		return nil, err
 	}
 
-	key := string(args[1])
-	node, err := ts.selectNode(key)
+	err = ts.cache.SetWithOptions(key, args[2], options)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// setNXHandler is a handler for setting an entry of specified key and value only if the key doesn't exist.
+// The args are the same as set command, and existsErr will be returned if the key exists.
+func (ts *TCPServer) setNXHandler(args [][]byte) (body []byte, err error) {
+	key, options, err := ts.setArgs(args)
 	if err != nil {
 		return nil, err
 	}
 
-	if !ts.isCurrentNode(node) {
-		return nil, fmt.Errorf("redirect to node %s", node)
+	ok, err := ts.cache.SetNX(key, args[2], options)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, existsErr
+	}
+	return nil, nil
+}
+
+// setXXHandler is a handler for setting an entry of specified key and value only if the key exists.
+// The args are the same as set command, and notFoundErr will be returned if the key doesn't exist.
+func (ts *TCPServer) setXXHandler(args [][]byte) (body []byte, err error) {
+	key, options, err := ts.setArgs(args)
+	if err != nil {
+		return nil, err
 	}
 
-	ttl := int64(binary.BigEndian.Uint64(args[0]))
-	err = ts.cache.SetWithTTL(key, args[2], ttl)
+	ok, err := ts.cache.SetXX(key, args[2], options)
 	if err != nil {
 		return nil, err
 	}
+
+	if !ok {
+		return nil, notFoundErr
+	}
 	return nil, nil
 }
 
+// getSetHandler is a handler for setting an entry of specified key and value and getting the old value.
+// The args are the same as set command, and the body is a byte of existence (1 means existed) followed by the old value.
+func (ts *TCPServer) getSetHandler(args [][]byte) (body []byte, err error) {
+	key, options, err := ts.setArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	oldValue, existed, err := ts.cache.GetSet(key, args[2], options)
+	if err != nil {
+		return nil, err
+	}
+
+	if !existed {
+		return []byte{0}, nil
+	}
+	return append([]byte{1}, oldValue...), nil
+}
+
+// setArgs returns the key and set options in args of set command.
+// Returns an error if args are invalid or the key belongs to another node.
+func (ts *TCPServer) setArgs(args [][]byte) (string, caches.SetOptions, error) {
+	if len(args) < 3 {
+		return "", caches.SetOptions{}, commandNeedsMoreArgumentsErr
+	}
+
+	key, err := ts.keyInArgs(args[1:])
+	if err != nil {
+		return "", caches.SetOptions{}, err
+	}
+
+	options, err := setOptionsInArgs(args)
+	return key, options, err
+}
+
+// setOptionsInArgs returns the set options in args of set command.
+// The args are ttl, key, value and optional flags handled by setOptionsOf.
+func setOptionsInArgs(args [][]byte) (caches.SetOptions, error) {
+	return setOptionsOf(args[0], args[3:])
+}
+
+// setOptionsOf returns the set options of ttl and flags.
+// The flags are optional expiration mode, max ttl and unit of ttls in order.
+// The unit is secondUnit if it's missing.
+func setOptionsOf(ttl []byte, flags [][]byte) (caches.SetOptions, error) {
+	unit := time.Second
+	if len(flags) > 2 {
+		if len(flags[2]) < 1 || (flags[2][0] != secondUnit && flags[2][0] != millisecondUnit) {
+			return caches.SetOptions{}, invalidArgumentsErr
+		}
+
+		if flags[2][0] == millisecondUnit {
+			unit = time.Millisecond
+		}
+	}
+
+	if len(ttl) < 8 {
+		return caches.SetOptions{}, invalidArgumentsErr
+	}
+
+	options := caches.SetOptions{
+		Ttl:        time.Duration(binary.BigEndian.Uint64(ttl)) * unit,
+		Expiration: caches.Sliding,
+	}
+
+	if len(flags) > 0 {
+		if len(flags[0]) < 1 {
+			return options, invalidArgumentsErr
+		}
+		options.Expiration = caches.ExpirationMode(flags[0][0])
+	}
+
+	if len(flags) > 1 {
+		if len(flags[1]) < 8 {
+			return options, invalidArgumentsErr
+		}
+		options.MaxTtl = time.Duration(binary.BigEndian.Uint64(flags[1])) * unit
+	}
+	return options, nil
+}
+
+// compareAndSetHandler is a handler for setting an entry of specified key and value only if its version is expected.
+// The args are expected version in uint64 followed by the args of set command, and the body is the new version in uint64.
+func (ts *TCPServer) compareAndSetHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 4 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	if len(args[0]) < 8 {
+		return nil, invalidArgumentsErr
+	}
+
+	key, options, err := ts.setArgs(args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	version, ok, err := ts.cache.CompareAndSetWithOptions(key, args[3], binary.BigEndian.Uint64(args[0]), options)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, versionConflictErr
+	}
+
+	body = make([]byte, 8)
+	binary.BigEndian.PutUint64(body, version)
+	return body, nil
+}
+
 // deleteHandler is a handler for deleting the entry of specified key.
 func (ts *TCPServer) deleteHandler(args [][]byte) (body []byte, err error) {
-	if len(args) < 1 {
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ts.cache.Delete(key)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// getDelHandler is a handler for deleting the entry of specified key and getting its value.
+// Returns notFoundErr if the key doesn't exist.
+func (ts *TCPServer) getDelHandler(args [][]byte) (body []byte, err error) {
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok, err := ts.cache.GetDel(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, notFoundErr
+	}
+	return value, nil
+}
+
+// expireAtHandler is a handler for making the entry of specified key die at the given time.
+// The args are key and the unix time in milliseconds.
+func (ts *TCPServer) expireAtHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
 		return nil, commandNeedsMoreArgumentsErr
 	}
 
-	key := string(args[0])
-	node, err := ts.selectNode(key)
+	if len(args[1]) < 8 {
+		return nil, invalidArgumentsErr
+	}
+
+	key, err := ts.keyInArgs(args)
 	if err != nil {
 		return nil, err
 	}
 
-	if !ts.isCurrentNode(node) {
-		return nil, fmt.Errorf("redirect to node %s", node)
+	at := int64(binary.BigEndian.Uint64(args[1]))
+	ok, err := ts.cache.ExpireAt(key, time.Unix(0, at*int64(time.Millisecond)))
+	if err != nil {
+		return nil, err
 	}
 
-	err = ts.cache.Delete(key)
+	if !ok {
+		return nil, notFoundErr
+	}
+	return nil, nil
+}
+
+// incrByHandler is a handler for adding delta to the value of specified key.
+// The args are key and delta in int64, and the body is the result in int64.
+func (ts *TCPServer) incrByHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	if len(args[1]) < 8 {
+		return nil, invalidArgumentsErr
+	}
+
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := ts.cache.IncrBy(key, int64(binary.BigEndian.Uint64(args[1])))
+	if err != nil {
+		return nil, err
+	}
+
+	body = make([]byte, 8)
+	binary.BigEndian.PutUint64(body, uint64(result))
+	return body, nil
+}
+
+// ttlHandler is a handler for getting the remaining life of specified key.
+// The body is the remaining life in milliseconds (uint64), and 0 means it never dies.
+func (ts *TCPServer) ttlHandler(args [][]byte) (body []byte, err error) {
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, ok, err := ts.cache.TTL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, notFoundErr
+	}
+
+	body = make([]byte, 8)
+	binary.BigEndian.PutUint64(body, uint64(ttl/time.Millisecond))
+	return body, nil
+}
+
+// expireHandler is a handler for making the entry of specified key die after the given ttl.
+// The args are key and ttl in milliseconds (int64), and the entry will be deleted if ttl isn't positive.
+func (ts *TCPServer) expireHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	if len(args[1]) < 8 {
+		return nil, invalidArgumentsErr
+	}
+
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := time.Duration(int64(binary.BigEndian.Uint64(args[1]))) * time.Millisecond
+	ok, err := ts.cache.Expire(key, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, notFoundErr
+	}
+	return nil, nil
+}
+
+// persistHandler is a handler for making the entry of specified key never die.
+func (ts *TCPServer) persistHandler(args [][]byte) (body []byte, err error) {
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := ts.cache.Persist(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, notFoundErr
+	}
+	return nil, nil
+}
+
+// touchHandler is a handler for visiting the entry of specified key without reading it.
+func (ts *TCPServer) touchHandler(args [][]byte) (body []byte, err error) {
+	key, err := ts.keyInArgs(args)
 	if err != nil {
 		return nil, err
 	}
+
+	ok, err := ts.cache.Touch(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, notFoundErr
+	}
 	return nil, nil
 }
 
+// keyInArgs returns the key which is the first one in args.
+// Returns an error if args are empty or the key belongs to another node.
+func (ts *TCPServer) keyInArgs(args [][]byte) (string, error) {
+	if len(args) < 1 {
+		return "", commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	node, err := ts.selectNode(key)
+	if err != nil {
+		return "", err
+	}
+
+	if !ts.isCurrentNode(node) {
+		return "", fmt.Errorf("redirect to node %s", node)
+	}
+	return key, nil
+}
+
+// scanHandler is a handler for scanning keys in this node.
+// The args are cursor, pattern and optional count in uint64, and the body is a ScanResult in json.
+func (ts *TCPServer) scanHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	count := 0
+	if len(args) > 2 {
+		if len(args[2]) < 8 {
+			return nil, invalidArgumentsErr
+		}
+		count = int(binary.BigEndian.Uint64(args[2]))
+	}
+
+	keys, cursor, err := ts.cache.Scan(string(args[0]), string(args[1]), count)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ScanResult{Keys: keys, Cursor: cursor})
+}
+
+// deleteMatchingHandler is a handler for deleting keys matching pattern in this node.
+// The args are pattern, and the body is the count of deleted keys in uint64.
+func (ts *TCPServer) deleteMatchingHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	count, err := ts.cache.DeleteMatching(string(args[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	body = make([]byte, 8)
+	binary.BigEndian.PutUint64(body, uint64(count))
+	return body, nil
+}
+
+// flushAllHandler is a handler for deleting all keys in this node.
+// The body is the count of deleted keys in uint64.
+func (ts *TCPServer) flushAllHandler(args [][]byte) (body []byte, err error) {
+	count, err := ts.cache.FlushAll()
+	if err != nil {
+		return nil, err
+	}
+
+	body = make([]byte, 8)
+	binary.BigEndian.PutUint64(body, uint64(count))
+	return body, nil
+}
+
+// mGetHandler is a handler for getting values of specified keys.
+// The args are keys, and the body is the values encoded by encodeValues in the order of keys.
+func (ts *TCPServer) mGetHandler(args [][]byte) (body []byte, err error) {
+	keys := make([]string, 0, len(args))
+	for _, arg := range args {
+		keys = append(keys, string(arg))
+	}
+
+	if err = ts.checkNodes(keys); err != nil {
+		return nil, err
+	}
+	values, err := ts.cache.MGet(keys)
+	if err != nil {
+		return nil, err
+	}
+	return encodeValues(keys, values), nil
+}
+
+// mSetHandler is a handler for setting entries of specified keys and values.
+// The args are ttl, expiration mode, max ttl and unit of ttls followed by keys and values in turn.
+// Options are handled as set command, so the unit can be secondUnit or millisecondUnit.
+func (ts *TCPServer) mSetHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 4 || len(args)%2 != 0 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	options, err := setOptionsOf(args[0], args[1:4])
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(args)/2)
+	entries := make(map[string][]byte, len(args)/2)
+	for i := 4; i < len(args); i += 2 {
+		keys = append(keys, string(args[i]))
+		entries[string(args[i])] = args[i+1]
+	}
+
+	if err = ts.checkNodes(keys); err != nil {
+		return nil, err
+	}
+	return nil, ts.cache.MSet(entries, options)
+}
+
+// mDeleteHandler is a handler for deleting specified keys.
+// The args are keys, and the body is the count of deleted keys in uint64.
+func (ts *TCPServer) mDeleteHandler(args [][]byte) (body []byte, err error) {
+	keys := make([]string, 0, len(args))
+	for _, arg := range args {
+		keys = append(keys, string(arg))
+	}
+
+	if err = ts.checkNodes(keys); err != nil {
+		return nil, err
+	}
+
+	count, err := ts.cache.MDelete(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	body = make([]byte, 8)
+	binary.BigEndian.PutUint64(body, uint64(count))
+	return body, nil
+}
+
+// checkNodes returns an error if any of keys belongs to another node.
+func (ts *TCPServer) checkNodes(keys []string) error {
+	for _, key := range keys {
+		node, err := ts.selectNode(key)
+		if err != nil {
+			return err
+		}
+
+		if !ts.isCurrentNode(node) {
+			return fmt.Errorf("redirect to node %s", node)
+		}
+	}
+	return nil
+}
+
+// encodeValues encodes values of keys in the order of keys.
+// Each value is a byte of existence (1 means existed), the size of data in uint32 and the data.
+func encodeValues(keys []string, values map[string][]byte) []byte {
+	var body []byte
+	for _, key := range keys {
+		value, ok := values[key]
+		if !ok {
+			body = append(body, 0, 0, 0, 0, 0)
+			continue
+		}
+
+		size := make([]byte, 4)
+		binary.BigEndian.PutUint32(size, uint32(len(value)))
+		body = append(body, 1)
+		body = append(body, size...)
+		body = append(body, value...)
+	}
+	return body
+}
+
+// decodeValues decodes values of keys encoded by encodeValues.
+// Keys not existing are missing in the result, and an error will be returned if body is invalid.
+func decodeValues(keys []string, body []byte) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		if len(body) < 5 {
+			return nil, invalidArgumentsErr
+		}
+
+		existed := body[0] == 1
+		size := int(binary.BigEndian.Uint32(body[1:]))
+		body = body[5:]
+		if len(body) < size {
+			return nil, invalidArgumentsErr
+		}
+
+		if existed {
+			values[key] = body[:size]
+		}
+		body = body[size:]
+	}
+	return values, nil
+}
+
+// hSetHandler is a handler for setting fields of the hash of specified key.
+// The args are key followed by fields and data in turn, and the body is the count of new fields in uint64.
+func (ts *TCPServer) hSetHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string][]byte, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		fields[string(args[i])] = args[i+1]
+	}
+
+	count, err := ts.cache.HSet(key, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	body = make([]byte, 8)
+	binary.BigEndian.PutUint64(body, uint64(count))
+	return body, nil
+}
+
+// hGetHandler is a handler for getting the data of field in the hash of specified key.
+// The args are key and field.
+func (ts *TCPServer) hGetHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	data, ok, err := ts.cache.HGet(key, string(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, notFoundErr
+	}
+	return data, nil
+}
+
+// hDelHandler is a handler for deleting fields of the hash of specified key.
+// The args are key followed by fields, and the body is the count of deleted fields in uint64.
+func (ts *TCPServer) hDelHandler(args [][]byte) (body []byte, err error) {
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]string, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		fields = append(fields, string(arg))
+	}
+
+	count, err := ts.cache.HDel(key, fields...)
+	if err != nil {
+		return nil, err
+	}
+
+	body = make([]byte, 8)
+	binary.BigEndian.PutUint64(body, uint64(count))
+	return body, nil
+}
+
+// hGetAllHandler is a handler for getting all fields in the hash of specified key.
+// The body is fields and data in turn encoded by encodeItems.
+func (ts *TCPServer) hGetAllHandler(args [][]byte) (body []byte, err error) {
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := ts.cache.HGetAll(key)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([][]byte, 0, 2*len(fields))
+	for field, data := range fields {
+		items = append(items, []byte(field), data)
+	}
+	return encodeItems(items), nil
+}
+
+// hIncrByHandler is a handler for adding delta to the data of field in the hash of specified key.
+// The args are key, field and delta in int64, and the body is the result in int64.
+func (ts *TCPServer) hIncrByHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 3 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	if len(args[2]) < 8 {
+		return nil, invalidArgumentsErr
+	}
+
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := ts.cache.HIncrBy(key, string(args[1]), int64(binary.BigEndian.Uint64(args[2])))
+	if err != nil {
+		return nil, err
+	}
+
+	body = make([]byte, 8)
+	binary.BigEndian.PutUint64(body, uint64(result))
+	return body, nil
+}
+
+// hLenHandler is a handler for getting the count of fields in the hash of specified key.
+// The body is the count in uint64.
+func (ts *TCPServer) hLenHandler(args [][]byte) (body []byte, err error) {
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := ts.cache.HLen(key)
+	if err != nil {
+		return nil, err
+	}
+
+	body = make([]byte, 8)
+	binary.BigEndian.PutUint64(body, uint64(count))
+	return body, nil
+}
+
+// lPushHandler is a handler for pushing items to the head of the list of specified key.
+// The args are key followed by items, and the body is the length of list in uint64.
+func (ts *TCPServer) lPushHandler(args [][]byte) (body []byte, err error) {
+	return ts.push(args, true)
+}
+
+// rPushHandler is a handler for pushing items to the tail of the list of specified key.
+// The args are key followed by items, and the body is the length of list in uint64.
+func (ts *TCPServer) rPushHandler(args [][]byte) (body []byte, err error) {
+	return ts.push(args, false)
+}
+
+// push pushes items in args to the head of list if left is true, otherwise to the tail.
+func (ts *TCPServer) push(args [][]byte, left bool) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var length int
+	if left {
+		length, err = ts.cache.LPush(key, args[1:]...)
+	} else {
+		length, err = ts.cache.RPush(key, args[1:]...)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	body = make([]byte, 8)
+	binary.BigEndian.PutUint64(body, uint64(length))
+	return body, nil
+}
+
+// lPopHandler is a handler for popping the head of the list of specified key.
+// It returns notFoundErr if the list is empty.
+func (ts *TCPServer) lPopHandler(args [][]byte) (body []byte, err error) {
+	return ts.pop(args, true)
+}
+
+// rPopHandler is a handler for popping the tail of the list of specified key.
+// It returns notFoundErr if the list is empty.
+func (ts *TCPServer) rPopHandler(args [][]byte) (body []byte, err error) {
+	return ts.pop(args, false)
+}
+
+// pop pops the head of list in args if left is true, otherwise the tail.
+func (ts *TCPServer) pop(args [][]byte, left bool) (body []byte, err error) {
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	pop := ts.cache.RPop
+	if left {
+		pop = ts.cache.LPop
+	}
+
+	item, ok, err := pop(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, notFoundErr
+	}
+	return item, nil
+}
+
+// bLPopHandler is a handler for popping the head of the list of specified key and waiting if it's empty.
+// The args are key and timeout in milliseconds in uint64, and it returns notFoundErr if timed out.
+func (ts *TCPServer) bLPopHandler(ctx context.Context, args [][]byte) (body []byte, undo func(), err error) {
+	return ts.blockingPop(ctx, args, true)
+}
+
+// bRPopHandler is a handler for popping the tail of the list of specified key and waiting if it's empty.
+// The args are key and timeout in milliseconds in uint64, and it returns notFoundErr if timed out.
+func (ts *TCPServer) bRPopHandler(ctx context.Context, args [][]byte) (body []byte, undo func(), err error) {
+	return ts.blockingPop(ctx, args, false)
+}
+
+// blockingPop pops the head of list in args if left is true, otherwise the tail, and waits for the timeout in args.
+// It stops waiting when the connection is closed, and the returned undo pushes the item back to where it was,
+// so the item won't be lost if it can't be written to the client.
+func (ts *TCPServer) blockingPop(ctx context.Context, args [][]byte, left bool) (body []byte, undo func(), err error) {
+	if len(args) < 2 {
+		return nil, nil, commandNeedsMoreArgumentsErr
+	}
+
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(args[1]) < 8 {
+		return nil, nil, invalidArgumentsErr
+	}
+
+	pop, push := ts.cache.BRPop, ts.cache.RPush
+	if left {
+		pop, push = ts.cache.BLPop, ts.cache.LPush
+	}
+
+	timeout := time.Duration(binary.BigEndian.Uint64(args[1])) * time.Millisecond
+	item, ok, err := pop(ctx, key, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !ok {
+		return nil, nil, notFoundErr
+	}
+
+	return item, func() {
+		push(key, item)
+	}, nil
+}
+
+// lRangeHandler is a handler for getting items in the list of specified key.
+// The args are key, start and stop in int64, and the body is items encoded by encodeItems.
+func (ts *TCPServer) lRangeHandler(args [][]byte) (body []byte, err error) {
+	key, start, stop, err := ts.rangeInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := ts.cache.LRange(key, start, stop)
+	if err != nil {
+		return nil, err
+	}
+	return encodeItems(items), nil
+}
+
+// lLenHandler is a handler for getting the length of the list of specified key.
+// The body is the length in uint64.
+func (ts *TCPServer) lLenHandler(args [][]byte) (body []byte, err error) {
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := ts.cache.LLen(key)
+	if err != nil {
+		return nil, err
+	}
+
+	body = make([]byte, 8)
+	binary.BigEndian.PutUint64(body, uint64(length))
+	return body, nil
+}
+
+// lTrimHandler is a handler for keeping items between start and stop in the list of specified key.
+// The args are key, start and stop in int64.
+func (ts *TCPServer) lTrimHandler(args [][]byte) (body []byte, err error) {
+	key, start, stop, err := ts.rangeInArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return nil, ts.cache.LTrim(key, start, stop)
+}
+
+// rangeInArgs returns the key, start and stop in args.
+func (ts *TCPServer) rangeInArgs(args [][]byte) (string, int, int, error) {
+	if len(args) < 3 {
+		return "", 0, 0, commandNeedsMoreArgumentsErr
+	}
+
+	if len(args[1]) < 8 || len(args[2]) < 8 {
+		return "", 0, 0, invalidArgumentsErr
+	}
+
+	key, err := ts.keyInArgs(args)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	start := int(int64(binary.BigEndian.Uint64(args[1])))
+	stop := int(int64(binary.BigEndian.Uint64(args[2])))
+	return key, start, stop, nil
+}
+
+// encodeItems encodes items, and each item is the size in uint32 followed by the data.
+func encodeItems(items [][]byte) []byte {
+	var body []byte
+	size := make([]byte, 4)
+	for _, item := range items {
+		binary.BigEndian.PutUint32(size, uint32(len(item)))
+		body = append(body, size...)
+		body = append(body, item...)
+	}
+	return body
+}
+
+// decodeItems decodes items encoded by encodeItems.
+// Returns an error if body is invalid.
+func decodeItems(body []byte) ([][]byte, error) {
+	var items [][]byte
+	for len(body) > 0 {
+		if len(body) < 4 {
+			return nil, invalidArgumentsErr
+		}
+
+		size := int(binary.BigEndian.Uint32(body))
+		body = body[4:]
+		if len(body) < size {
+			return nil, invalidArgumentsErr
+		}
+
+		items = append(items, body[:size])
+		body = body[size:]
+	}
+	return items, nil
+}
+
 // statusHandler is handler for fetching the status of cache.
 func (ts *TCPServer) statusHandler(args [][]byte) (body []byte, err error) {
 	return json.Marshal(ts.cache.Status())
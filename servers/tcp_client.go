@@ -13,6 +13,8 @@ import (
 	"encoding/json"
 	"errors"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/FishGoddess/cachego"
@@ -41,6 +43,9 @@ var (
 
 	// reachMaxRetriedTimesErr means one operation has reached max redirect times.
 	reachedMaxRetriedTimesErr = errors.New("reached max redirect times")
+
+	// invalidResponseErr means the response of server is invalid.
+	invalidResponseErr = errors.New("response of server is invalid")
 )
 
 // TCPClient is a tcp client for tcp server.
@@ -158,7 +163,7 @@ func (tc *TCPClient) doCommand(client *vex.Client, command byte, args [][]byte)
 
 	for i := 0; i < maxRedirectTimes; i++ {
 		body, err := client.Do(command, args)
-		if err != nil && strings.HasPrefix(err.Error(), redirectPrefix) {
+		if isRedirect(err) {
 			node := strings.TrimPrefix(err.Error(), redirectPrefix)
 			rightClient, err := tc.getOrCreateClient(node)
 			if err != nil {
@@ -189,7 +194,36 @@ func (tc *TCPClient) Get(key string) ([]byte, error) {
 	return tc.doCommand(client, getCommand, [][]byte{[]byte(key)})
 }
 
-// Set adds the key and value with given ttl to cache.
+// Peek returns the value of key without visiting it, so a sliding entry won't live longer.
+// Returns an error if failed or the key doesn't exist.
+func (tc *TCPClient) Peek(key string) ([]byte, error) {
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, err
+	}
+	return tc.doCommand(client, peekCommand, [][]byte{[]byte(key)})
+}
+
+// GetWithVersion returns the value of key, its version and an error if failed.
+// The version can be used in CompareAndSet.
+func (tc *TCPClient) GetWithVersion(key string) ([]byte, uint64, error) {
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	body, err := tc.doCommand(client, getWithVersionCommand, [][]byte{[]byte(key)})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(body) < 8 {
+		return nil, 0, invalidResponseErr
+	}
+	return body[8:], binary.BigEndian.Uint64(body), nil
+}
+
+// Set adds the key and value with given sliding ttl to cache.
 // Returns an error if failed.
 func (tc *TCPClient) Set(key string, value []byte, ttl int64) error {
 
@@ -206,6 +240,238 @@ func (tc *TCPClient) Set(key string, value []byte, ttl int64) error {
 	return err
 }
 
+// SetWithOptions adds the key and value with given options to cache.
+// Returns an error if failed.
+func (tc *TCPClient) SetWithOptions(key string, value []byte, options caches.SetOptions) error {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = tc.doCommand(client, setCommand, setArgsOf(key, value, options))
+	return err
+}
+
+// setArgsOf returns the args of set command with key, value and options.
+// Ttls in args are in milliseconds.
+func setArgsOf(key string, value []byte, options caches.SetOptions) [][]byte {
+	ttl, flags := setOptionArgsOf(options)
+	return append([][]byte{ttl, []byte(key), value}, flags...)
+}
+
+// setOptionArgsOf returns the ttl and flags in args of set command with options.
+// The flags are expiration mode, max ttl and unit, and ttls are in milliseconds.
+func setOptionArgsOf(options caches.SetOptions) ([]byte, [][]byte) {
+	ttlBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(ttlBytes, uint64(millisOf(options.Ttl)))
+	maxTtlBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(maxTtlBytes, uint64(millisOf(options.MaxTtl)))
+	return ttlBytes, [][]byte{{byte(options.Expiration)}, maxTtlBytes, {millisecondUnit}}
+}
+
+// millisOf returns d in milliseconds like the cache does.
+// A positive d less than one millisecond is rounded up, so it won't become caches.NeverDie.
+func millisOf(d time.Duration) int64 {
+	millis := int64(d / time.Millisecond)
+	if d > 0 && d%time.Millisecond != 0 {
+		return millis + 1
+	}
+	return millis
+}
+
+// SetNX adds the key and value with given options to cache only if the key doesn't exist.
+// Returns an error if failed or the key exists.
+func (tc *TCPClient) SetNX(key string, value []byte, options caches.SetOptions) error {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = tc.doCommand(client, setNXCommand, setArgsOf(key, value, options))
+	return err
+}
+
+// SetXX adds the key and value with given options to cache only if the key exists.
+// Returns an error if failed or the key doesn't exist.
+func (tc *TCPClient) SetXX(key string, value []byte, options caches.SetOptions) error {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = tc.doCommand(client, setXXCommand, setArgsOf(key, value, options))
+	return err
+}
+
+// GetSet adds the key and value with given options to cache and returns the old value.
+// Returns false if the key didn't exist, and an error if failed.
+func (tc *TCPClient) GetSet(key string, value []byte, options caches.SetOptions) ([]byte, bool, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	body, err := tc.doCommand(client, getSetCommand, setArgsOf(key, value, options))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(body) < 1 {
+		return nil, false, invalidResponseErr
+	}
+	return body[1:], body[0] == 1, nil
+}
+
+// GetDel deletes the value of key and returns it.
+// Returns an error if failed or the key doesn't exist.
+func (tc *TCPClient) GetDel(key string) ([]byte, error) {
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, err
+	}
+	return tc.doCommand(client, getDelCommand, [][]byte{[]byte(key)})
+}
+
+// CompareAndSet adds the key and value with given sliding ttl to cache only if the version of key is expectedVersion.
+// The version of a key not existing is 0. Returns the new version and an error if failed or the version conflicts.
+func (tc *TCPClient) CompareAndSet(key string, value []byte, expectedVersion uint64, ttl int64) (uint64, error) {
+	return tc.CompareAndSetWithOptions(key, value, expectedVersion, caches.SetOptions{Ttl: time.Duration(ttl) * time.Second, Expiration: caches.Sliding})
+}
+
+// CompareAndSetWithOptions adds the key and value with given options to cache only if the version of key is expectedVersion.
+// Returns the new version and an error if failed or the version conflicts.
+func (tc *TCPClient) CompareAndSetWithOptions(key string, value []byte, expectedVersion uint64, options caches.SetOptions) (uint64, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	versionBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(versionBytes, expectedVersion)
+	body, err := tc.doCommand(client, compareAndSetCommand, append([][]byte{versionBytes}, setArgsOf(key, value, options)...))
+	if err != nil {
+		return 0, err
+	}
+
+	if len(body) < 8 {
+		return 0, invalidResponseErr
+	}
+	return binary.BigEndian.Uint64(body), nil
+}
+
+// ExpireAt makes the entry of key die at the given time.
+// Returns an error if failed or the key doesn't exist.
+func (tc *TCPClient) ExpireAt(key string, at time.Time) error {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return err
+	}
+
+	atBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(atBytes, uint64(at.UnixNano()/int64(time.Millisecond)))
+	_, err = tc.doCommand(client, expireAtCommand, [][]byte{[]byte(key), atBytes})
+	return err
+}
+
+// IncrBy adds delta to the value of key atomically and returns the result.
+// Returns an error if failed or the value isn't an integer.
+func (tc *TCPClient) IncrBy(key string, delta int64) (int64, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	deltaBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(deltaBytes, uint64(delta))
+	body, err := tc.doCommand(client, incrByCommand, [][]byte{[]byte(key), deltaBytes})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(body) < 8 {
+		return 0, invalidResponseErr
+	}
+	return int64(binary.BigEndian.Uint64(body)), nil
+}
+
+// Incr adds 1 to the value of key atomically and returns the result.
+func (tc *TCPClient) Incr(key string) (int64, error) {
+	return tc.IncrBy(key, 1)
+}
+
+// Decr subtracts 1 from the value of key atomically and returns the result.
+func (tc *TCPClient) Decr(key string) (int64, error) {
+	return tc.IncrBy(key, -1)
+}
+
+// TTL returns the remaining life of key and an error if failed or the key doesn't exist.
+// Returns caches.NeverDie if it never dies.
+func (tc *TCPClient) TTL(key string) (time.Duration, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	body, err := tc.doCommand(client, ttlCommand, [][]byte{[]byte(key)})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(body) < 8 {
+		return 0, invalidResponseErr
+	}
+	return time.Duration(binary.BigEndian.Uint64(body)) * time.Millisecond, nil
+}
+
+// Expire makes the entry of key die after ttl, and it will be deleted if ttl isn't positive.
+// Returns an error if failed or the key doesn't exist.
+func (tc *TCPClient) Expire(key string, ttl time.Duration) error {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return err
+	}
+
+	ttlBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(ttlBytes, uint64(millisOf(ttl)))
+	_, err = tc.doCommand(client, expireCommand, [][]byte{[]byte(key), ttlBytes})
+	return err
+}
+
+// Persist makes the entry of key never die.
+// Returns an error if failed or the key doesn't exist.
+func (tc *TCPClient) Persist(key string) error {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = tc.doCommand(client, persistCommand, [][]byte{[]byte(key)})
+	return err
+}
+
+// Touch visits the entry of key without reading it, so a sliding entry lives longer.
+// Returns an error if failed or the key doesn't exist.
+func (tc *TCPClient) Touch(key string) error {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = tc.doCommand(client, touchCommand, [][]byte{[]byte(key)})
+	return err
+}
+
 // Delete deletes the value of key and returns an error if failed.
 func (tc *TCPClient) Delete(key string) error {
 
@@ -218,6 +484,498 @@ func (tc *TCPClient) Delete(key string) error {
 	return err
 }
 
+// DeleteMatching deletes keys matching pattern in all nodes and returns the count of deleted keys.
+// The syntax of pattern is the same as path.Match.
+func (tc *TCPClient) DeleteMatching(pattern string) (int, error) {
+	return tc.deleteInAllNodes(deleteMatchingCommand, [][]byte{[]byte(pattern)})
+}
+
+// FlushAll deletes all keys in all nodes and returns the count of deleted keys.
+func (tc *TCPClient) FlushAll() (int, error) {
+	return tc.deleteInAllNodes(flushAllCommand, nil)
+}
+
+// deleteInAllNodes executes the deleting command with args in all nodes and returns the total count of deleted keys.
+func (tc *TCPClient) deleteInAllNodes(command byte, args [][]byte) (int, error) {
+
+	total := 0
+	nodes := tc.circle.Members()
+	for _, node := range nodes {
+		client, err := tc.getOrCreateClient(node)
+		if err != nil {
+			return total, err
+		}
+
+		body, err := client.Do(command, args)
+		if err != nil {
+			return total, err
+		}
+
+		if len(body) < 8 {
+			return total, invalidResponseErr
+		}
+		total += int(binary.BigEndian.Uint64(body))
+	}
+	return total, nil
+}
+
+// MGet returns the values of keys and the errors of keys failed.
+// Keys are split by nodes, and each node gets its keys in one request in parallel.
+// Keys not existing have notFoundErr in errors.
+func (tc *TCPClient) MGet(keys []string) (map[string][]byte, map[string]error) {
+
+	values := make(map[string][]byte, len(keys))
+	lock := &sync.Mutex{}
+	errs := tc.doInNodes(keys, func(client *vex.Client, keys []string) error {
+		args := make([][]byte, 0, len(keys))
+		for _, key := range keys {
+			args = append(args, []byte(key))
+		}
+
+		body, err := client.Do(mGetCommand, args)
+		if err != nil {
+			return err
+		}
+
+		nodeValues, err := decodeValues(keys, body)
+		if err != nil {
+			return invalidResponseErr
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		for key, value := range nodeValues {
+			values[key] = value
+		}
+		return nil
+	})
+
+	for _, key := range keys {
+		if _, ok := values[key]; !ok && errs[key] == nil {
+			errs[key] = notFoundErr
+		}
+	}
+	return values, errs
+}
+
+// MSet adds all keys and values in entries with given options to cache and returns the errors of keys failed.
+// Keys are split by nodes, and each node sets its keys in one request in parallel.
+func (tc *TCPClient) MSet(entries map[string][]byte, options caches.SetOptions) map[string]error {
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+
+	ttl, flags := setOptionArgsOf(options)
+	return tc.doInNodes(keys, func(client *vex.Client, keys []string) error {
+		args := make([][]byte, 0, 1+len(flags)+2*len(keys))
+		args = append(args, ttl)
+		args = append(args, flags...)
+		for _, key := range keys {
+			args = append(args, []byte(key), entries[key])
+		}
+
+		_, err := client.Do(mSetCommand, args)
+		return err
+	})
+}
+
+// MDelete deletes keys and returns the count of deleted keys and the errors of keys failed.
+// Keys are split by nodes, and each node deletes its keys in one request in parallel.
+func (tc *TCPClient) MDelete(keys []string) (int, map[string]error) {
+
+	total := int64(0)
+	errs := tc.doInNodes(keys, func(client *vex.Client, keys []string) error {
+		args := make([][]byte, 0, len(keys))
+		for _, key := range keys {
+			args = append(args, []byte(key))
+		}
+
+		body, err := client.Do(mDeleteCommand, args)
+		if err != nil {
+			return err
+		}
+
+		if len(body) < 8 {
+			return invalidResponseErr
+		}
+		atomic.AddInt64(&total, int64(binary.BigEndian.Uint64(body)))
+		return nil
+	})
+	return int(total), errs
+}
+
+// doInNodes splits keys by nodes and runs do with the client and keys of each node in parallel.
+// Returns the errors of keys, and the error of do is the error of all keys in its node.
+// If a node redirects, the circle is updated and its keys are split by their owners again,
+// so a batch is never sent to one node as a whole when its keys belong to several nodes.
+func (tc *TCPClient) doInNodes(keys []string, do func(client *vex.Client, keys []string) error) map[string]error {
+	return tc.doInNodesWithRedirects(keys, do, maxRedirectTimes)
+}
+
+// doInNodesWithRedirects is doInNodes which splits keys redirected again at most redirects times.
+func (tc *TCPClient) doInNodesWithRedirects(keys []string, do func(client *vex.Client, keys []string) error, redirects int) map[string]error {
+
+	errs := make(map[string]error)
+	groups := make(map[string][]string)
+	for _, key := range keys {
+		node, err := tc.circle.Get(key)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		groups[node] = append(groups[node], key)
+	}
+
+	var redirected []string
+	lock := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for node, nodeKeys := range groups {
+		wg.Add(1)
+		go func(node string, keys []string) {
+			defer wg.Done()
+			client, err := tc.getOrCreateClient(node)
+			if err == nil {
+				err = do(client, keys)
+			}
+
+			if err == nil {
+				return
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			if isRedirect(err) && redirects > 0 {
+				redirected = append(redirected, keys...)
+				return
+			}
+
+			if isRedirect(err) {
+				err = reachedMaxRetriedTimesErr
+			}
+
+			for _, key := range keys {
+				errs[key] = err
+			}
+		}(node, nodeKeys)
+	}
+	wg.Wait()
+
+	if len(redirected) <= 0 {
+		return errs
+	}
+
+	// Keys are split again after all requests are done, so no clients will be used by two goroutines.
+	if nodes, err := tc.nodes(); err == nil {
+		tc.circle.Set(nodes)
+	}
+
+	for key, err := range tc.doInNodesWithRedirects(redirected, do, redirects-1) {
+		errs[key] = err
+	}
+	return errs
+}
+
+// isRedirect returns if err is a redirect error.
+func isRedirect(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), redirectPrefix)
+}
+
+// HSet sets fields of the hash of key and returns the count of new fields.
+// Returns an error if failed or the key isn't a hash.
+func (tc *TCPClient) HSet(key string, fields map[string][]byte) (int, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	args := make([][]byte, 0, 1+2*len(fields))
+	args = append(args, []byte(key))
+	for field, data := range fields {
+		args = append(args, []byte(field), data)
+	}
+
+	body, err := tc.doCommand(client, hSetCommand, args)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(body) < 8 {
+		return 0, invalidResponseErr
+	}
+	return int(binary.BigEndian.Uint64(body)), nil
+}
+
+// HGet returns the data of field in the hash of key.
+// Returns an error if failed or the field doesn't exist.
+func (tc *TCPClient) HGet(key string, field string) ([]byte, error) {
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, err
+	}
+	return tc.doCommand(client, hGetCommand, [][]byte{[]byte(key), []byte(field)})
+}
+
+// HDel deletes fields of the hash of key and returns the count of deleted fields.
+// Returns an error if failed or the key isn't a hash.
+func (tc *TCPClient) HDel(key string, fields ...string) (int, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	args := make([][]byte, 0, 1+len(fields))
+	args = append(args, []byte(key))
+	for _, field := range fields {
+		args = append(args, []byte(field))
+	}
+
+	body, err := tc.doCommand(client, hDelCommand, args)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(body) < 8 {
+		return 0, invalidResponseErr
+	}
+	return int(binary.BigEndian.Uint64(body)), nil
+}
+
+// HGetAll returns all fields in the hash of key.
+// Returns an error if failed or the key isn't a hash.
+func (tc *TCPClient) HGetAll(key string) (map[string][]byte, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := tc.doCommand(client, hGetAllCommand, [][]byte{[]byte(key)})
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := decodeItems(body)
+	if err != nil || len(items)%2 != 0 {
+		return nil, invalidResponseErr
+	}
+
+	fields := make(map[string][]byte, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		fields[string(items[i])] = items[i+1]
+	}
+	return fields, nil
+}
+
+// HIncrBy adds delta to the data of field in the hash of key atomically and returns the result.
+// Returns an error if failed or the data isn't an integer.
+func (tc *TCPClient) HIncrBy(key string, field string, delta int64) (int64, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	deltaBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(deltaBytes, uint64(delta))
+	body, err := tc.doCommand(client, hIncrByCommand, [][]byte{[]byte(key), []byte(field), deltaBytes})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(body) < 8 {
+		return 0, invalidResponseErr
+	}
+	return int64(binary.BigEndian.Uint64(body)), nil
+}
+
+// HLen returns the count of fields in the hash of key.
+// Returns an error if failed or the key isn't a hash.
+func (tc *TCPClient) HLen(key string) (int, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	body, err := tc.doCommand(client, hLenCommand, [][]byte{[]byte(key)})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(body) < 8 {
+		return 0, invalidResponseErr
+	}
+	return int(binary.BigEndian.Uint64(body)), nil
+}
+
+// LPush pushes items to the head of the list of key and returns the length of list.
+// Returns an error if failed or the key isn't a list.
+func (tc *TCPClient) LPush(key string, items ...[]byte) (int, error) {
+	return tc.push(lPushCommand, key, items)
+}
+
+// RPush pushes items to the tail of the list of key and returns the length of list.
+// Returns an error if failed or the key isn't a list.
+func (tc *TCPClient) RPush(key string, items ...[]byte) (int, error) {
+	return tc.push(rPushCommand, key, items)
+}
+
+// push pushes items to the list of key by command.
+func (tc *TCPClient) push(command byte, key string, items [][]byte) (int, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	args := make([][]byte, 0, 1+len(items))
+	args = append(args, []byte(key))
+	args = append(args, items...)
+	body, err := tc.doCommand(client, command, args)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(body) < 8 {
+		return 0, invalidResponseErr
+	}
+	return int(binary.BigEndian.Uint64(body)), nil
+}
+
+// LPop removes and returns the head of the list of key.
+// Returns an error if failed or the list is empty.
+func (tc *TCPClient) LPop(key string) ([]byte, error) {
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, err
+	}
+	return tc.doCommand(client, lPopCommand, [][]byte{[]byte(key)})
+}
+
+// RPop removes and returns the tail of the list of key.
+// Returns an error if failed or the list is empty.
+func (tc *TCPClient) RPop(key string) ([]byte, error) {
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, err
+	}
+	return tc.doCommand(client, rPopCommand, [][]byte{[]byte(key)})
+}
+
+// BLPop is LPop but it waits until an item is pushed if the list is empty.
+// It never times out if timeout isn't positive, and returns an error if failed or timed out.
+func (tc *TCPClient) BLPop(key string, timeout time.Duration) ([]byte, error) {
+	return tc.blockingPop(bLPopCommand, key, timeout)
+}
+
+// BRPop is RPop but it waits until an item is pushed if the list is empty.
+// It never times out if timeout isn't positive, and returns an error if failed or timed out.
+func (tc *TCPClient) BRPop(key string, timeout time.Duration) ([]byte, error) {
+	return tc.blockingPop(bRPopCommand, key, timeout)
+}
+
+// blockingPop pops the list of key by command and waits for timeout.
+// It uses a new connection even after redirecting, so the shared one of node won't be held while waiting.
+func (tc *TCPClient) blockingPop(command byte, key string, timeout time.Duration) ([]byte, error) {
+
+	node, err := tc.circle.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// A timeout less than one millisecond should be rounded up, because 0 means waiting forever.
+	timeoutBytes := make([]byte, 8)
+	if timeout > 0 {
+		binary.BigEndian.PutUint64(timeoutBytes, uint64(millisOf(timeout)))
+	}
+
+	args := [][]byte{[]byte(key), timeoutBytes}
+	for i := 0; i < maxRedirectTimes; i++ {
+		body, err := doInNewClient(node, command, args)
+		if !isRedirect(err) {
+			return body, err
+		}
+		node = strings.TrimPrefix(err.Error(), redirectPrefix)
+	}
+	return nil, reachedMaxRetriedTimesErr
+}
+
+// doInNewClient executes command with args in a new client of node, and the client is closed after that.
+func doInNewClient(node string, command byte, args [][]byte) ([]byte, error) {
+	client, err := vex.NewClient("tcp", node)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return client.Do(command, args)
+}
+
+// LRange returns items between start and stop in the list of key.
+// Negative indexes count from the tail, so LRange(key, 0, -1) returns all items.
+func (tc *TCPClient) LRange(key string, start int, stop int) ([][]byte, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := tc.doCommand(client, lRangeCommand, rangeArgsOf(key, start, stop))
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := decodeItems(body)
+	if err != nil {
+		return nil, invalidResponseErr
+	}
+	return items, nil
+}
+
+// LLen returns the length of the list of key.
+// Returns an error if failed or the key isn't a list.
+func (tc *TCPClient) LLen(key string) (int, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	body, err := tc.doCommand(client, lLenCommand, [][]byte{[]byte(key)})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(body) < 8 {
+		return 0, invalidResponseErr
+	}
+	return int(binary.BigEndian.Uint64(body)), nil
+}
+
+// LTrim keeps items between start and stop in the list of key, and other items will be removed.
+// Returns an error if failed or the key isn't a list.
+func (tc *TCPClient) LTrim(key string, start int, stop int) error {
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = tc.doCommand(client, lTrimCommand, rangeArgsOf(key, start, stop))
+	return err
+}
+
+// rangeArgsOf returns the args of key, start and stop.
+func rangeArgsOf(key string, start int, stop int) [][]byte {
+	startBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(startBytes, uint64(int64(start)))
+	stopBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(stopBytes, uint64(int64(stop)))
+	return [][]byte{[]byte(key), startBytes, stopBytes}
+}
+
 // Status returns the status of cache and an error if failed.
 func (tc *TCPClient) Status() (*caches.Status, error) {
 
@@ -240,6 +998,12 @@ func (tc *TCPClient) Status() (*caches.Status, error) {
 		totalStatus.Count += status.Count
 		totalStatus.KeySize += status.KeySize
 		totalStatus.ValueSize += status.ValueSize
+		totalStatus.Evictions += status.Evictions
+		totalStatus.Expired += status.Expired
+		totalStatus.DirtyCount += status.DirtyCount
+		if totalStatus.LastSave == 0 || status.LastSave < totalStatus.LastSave {
+			totalStatus.LastSave = status.LastSave
+		}
 	}
 	return totalStatus, nil
 }
@@ -262,3 +1026,104 @@ func (tc *TCPClient) Close() (err error) {
 	tc.clients.RemoveAll()
 	return err
 }
+
+// =======================================================================
+
+// KeyIterator iterates keys in all nodes of cluster, and it's created by TCPClient.Scan.
+// Keys are fetched from nodes one by one in batches, so it's not a snapshot of cluster.
+type KeyIterator struct {
+
+	// client is the client used to fetch keys.
+	client *TCPClient
+
+	// nodes are the nodes to scan.
+	nodes []string
+
+	// pattern is the pattern which keys should match.
+	pattern string
+
+	// count is the count of keys fetched in one batch.
+	count int
+
+	// cursor is the cursor of current node, and it's empty if current node isn't scanned or finished.
+	cursor string
+
+	// started means if current node has been scanned.
+	started bool
+
+	// keys are the keys fetched but not iterated.
+	keys []string
+
+	// key is the current key.
+	key string
+
+	// err is the error happened in iterating.
+	err error
+}
+
+// Scan returns an iterator of keys matching pattern in all nodes of cluster.
+// The syntax of pattern is the same as path.Match, and count is the count of keys fetched in one batch.
+func (tc *TCPClient) Scan(pattern string, count int) *KeyIterator {
+	return &KeyIterator{
+		client:  tc,
+		nodes:   tc.circle.Members(),
+		pattern: pattern,
+		count:   count,
+	}
+}
+
+// Next moves to next key and returns false if there are no more keys or an error happened.
+func (ki *KeyIterator) Next() bool {
+	for len(ki.keys) <= 0 {
+		if ki.err != nil || len(ki.nodes) <= 0 {
+			return false
+		}
+
+		if ki.started && ki.cursor == "" {
+			ki.nodes = ki.nodes[1:]
+			ki.started = false
+			continue
+		}
+
+		ki.err = ki.fetch()
+	}
+
+	ki.key = ki.keys[0]
+	ki.keys = ki.keys[1:]
+	return true
+}
+
+// fetch fetches a batch of keys from current node.
+func (ki *KeyIterator) fetch() error {
+	client, err := ki.client.getOrCreateClient(ki.nodes[0])
+	if err != nil {
+		return err
+	}
+
+	countBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(countBytes, uint64(ki.count))
+	body, err := client.Do(scanCommand, [][]byte{[]byte(ki.cursor), []byte(ki.pattern), countBytes})
+	if err != nil {
+		return err
+	}
+
+	result := ScanResult{}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return err
+	}
+
+	ki.keys = result.Keys
+	ki.cursor = result.Cursor
+	ki.started = true
+	return nil
+}
+
+// Key returns the current key.
+func (ki *KeyIterator) Key() string {
+	return ki.key
+}
+
+// Err returns the error happened in iterating.
+func (ki *KeyIterator) Err() error {
+	return ki.err
+}
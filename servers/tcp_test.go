@@ -9,11 +9,15 @@
 package servers
 
 import (
+	"bytes"
+	"encoding/binary"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/FishGoddess/vex"
 	"github.com/avino-plan/kafo/caches"
 )
 
@@ -25,7 +29,7 @@ func TestTCPServer(t *testing.T) {
 	go func() {
 		err := server.Run()
 		if err != nil {
-			t.Fatal(err)
+			t.Error(err)
 		}
 	}()
 	defer server.Close()
@@ -69,6 +73,346 @@ func TestTCPServer(t *testing.T) {
 	}
 	wg.Wait()
 
+	t.Log("Start setting with options...")
+	err = client.SetWithOptions("absolute", []byte("value"), caches.SetOptions{Ttl: 1500 * time.Millisecond, Expiration: caches.Absolute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := client.Get("absolute"); err != nil || string(value) != "value" {
+		t.Fatalf("Get key absolute returns wrong value %s and error %v!", string(value), err)
+	}
+
+	err = client.SetWithOptions("unknown", []byte("value"), caches.SetOptions{Ttl: time.Minute, Expiration: 99})
+	if err == nil {
+		t.Fatal("Set with an unknown expiration mode should fail!")
+	}
+
+	if err = client.ExpireAt("absolute", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = client.Get("absolute"); err == nil {
+		t.Fatal("Key absolute should be deleted after expiring at a passed time!")
+	}
+
+	if err = client.ExpireAt("absolute", time.Now().Add(time.Second)); err == nil {
+		t.Fatal("ExpireAt a key not existing should fail!")
+	}
+
+	t.Log("Start comparing and setting...")
+	version, err := client.CompareAndSet("versioned", []byte("value"), 0, caches.NeverDie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, current, err := client.GetWithVersion("versioned"); err != nil || current != version || string(value) != "value" {
+		t.Fatalf("GetWithVersion returns wrong value %s, version %d and error %v!", string(value), current, err)
+	}
+
+	if _, err = client.CompareAndSet("versioned", []byte("new"), version+1, caches.NeverDie); err == nil || err.Error() != versionConflictErr.Error() {
+		t.Fatalf("CompareAndSet with a wrong version should return version conflict but got %v!", err)
+	}
+
+	if _, err = client.CompareAndSet("versioned", []byte("new"), version, caches.NeverDie); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = client.Delete("versioned"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Start conditional writing...")
+	lockOptions := caches.SetOptions{Ttl: time.Minute, Expiration: caches.Absolute}
+	if err = client.SetXX("lock", []byte("owner"), lockOptions); err == nil || err.Error() != notFoundErr.Error() {
+		t.Fatalf("SetXX a key not existing should return not found but got %v!", err)
+	}
+
+	if err = client.SetNX("lock", []byte("owner"), lockOptions); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = client.SetNX("lock", []byte("other"), lockOptions); err == nil || err.Error() != existsErr.Error() {
+		t.Fatalf("SetNX an existing key should return already exists but got %v!", err)
+	}
+
+	if oldValue, existed, err := client.GetSet("lock", []byte("other"), lockOptions); err != nil || !existed || string(oldValue) != "owner" {
+		t.Fatalf("GetSet returns wrong old value %s, existence %v and error %v!", string(oldValue), existed, err)
+	}
+
+	if value, err := client.GetDel("lock"); err != nil || string(value) != "other" {
+		t.Fatalf("GetDel returns wrong value %s and error %v!", string(value), err)
+	}
+
+	if _, err = client.GetDel("lock"); err == nil {
+		t.Fatal("GetDel a key not existing should fail!")
+	}
+
+	t.Log("Start scanning...")
+	scanned := map[string]bool{}
+	iterator := client.Scan("", 7)
+	for iterator.Next() {
+		scanned[iterator.Key()] = true
+	}
+
+	if err = iterator.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(scanned) != 100 || !scanned["99"] {
+		t.Fatalf("Scan returns %d keys but it should be 100!", len(scanned))
+	}
+
+	iterator = client.Scan("9*", 100)
+	count := 0
+	for iterator.Next() {
+		count++
+	}
+
+	if count != 11 {
+		t.Fatalf("Scan with pattern returns %d keys but it should be 11!", count)
+	}
+
+	t.Log("Start batch operations...")
+	entries := map[string][]byte{}
+	for i := 0; i < 20; i++ {
+		entries["batch"+strconv.Itoa(i)] = []byte("value" + strconv.Itoa(i))
+	}
+
+	if errs := client.MSet(entries, caches.SetOptions{Ttl: time.Minute}); len(errs) != 0 {
+		t.Fatalf("MSet returns errors %v!", errs)
+	}
+
+	if ttl, err := client.TTL("batch0"); err != nil || ttl <= 59*time.Second || ttl > time.Minute {
+		t.Fatalf("TTL of batch0 should be about 1 minute but got %v and %v!", ttl, err)
+	}
+
+	values, errs := client.MGet([]string{"batch0", "batch19", "notExist"})
+	if len(values) != 2 || string(values["batch19"]) != "value19" {
+		t.Fatalf("MGet returns wrong values %v!", values)
+	}
+
+	if len(errs) != 1 || errs["notExist"] == nil {
+		t.Fatalf("MGet should return an error of notExist but got %v!", errs)
+	}
+
+	keys := []string{"notExist"}
+	for key := range entries {
+		keys = append(keys, key)
+	}
+
+	if count, errs := client.MDelete(keys); count != 20 || len(errs) != 0 {
+		t.Fatalf("MDelete returns wrong count %d and errors %v!", count, errs)
+	}
+
+	t.Log("Start deleting matching keys...")
+	for i := 0; i < 10; i++ {
+		if err = client.Set("tenant:"+strconv.Itoa(i), []byte("value"), caches.NeverDie); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if count, err := client.DeleteMatching("tenant:*"); err != nil || count != 10 {
+		t.Fatalf("DeleteMatching returns wrong count %d and error %v!", count, err)
+	}
+
+	t.Log("Start operating hash...")
+	if count, err := client.HSet("hash", map[string][]byte{"name": []byte("fish"), "age": []byte("18")}); err != nil || count != 2 {
+		t.Fatalf("HSet returns wrong count %d and error %v!", count, err)
+	}
+
+	if data, err := client.HGet("hash", "name"); err != nil || string(data) != "fish" {
+		t.Fatalf("HGet returns wrong data %s and error %v!", string(data), err)
+	}
+
+	if _, err = client.HGet("hash", "notExist"); err == nil {
+		t.Fatal("HGet a field not existing should fail!")
+	}
+
+	if result, err := client.HIncrBy("hash", "age", 2); err != nil || result != 20 {
+		t.Fatalf("HIncrBy returns wrong result %d and error %v!", result, err)
+	}
+
+	if fields, err := client.HGetAll("hash"); err != nil || len(fields) != 2 || string(fields["age"]) != "20" {
+		t.Fatalf("HGetAll returns wrong fields %v and error %v!", fields, err)
+	}
+
+	if length, err := client.HLen("hash"); err != nil || length != 2 {
+		t.Fatalf("HLen returns wrong length %d and error %v!", length, err)
+	}
+
+	if _, err := client.Get("hash"); err == nil || !strings.Contains(err.Error(), "expected a string but the key holds a hash") {
+		t.Fatalf("Get a hash should return a type error but got %v!", err)
+	}
+
+	if count, err := client.HDel("hash", "name", "age"); err != nil || count != 2 {
+		t.Fatalf("HDel returns wrong count %d and error %v!", count, err)
+	}
+
+	if length, err := client.HLen("hash"); err != nil || length != 0 {
+		t.Fatalf("HLen of an empty hash returns wrong length %d and error %v!", length, err)
+	}
+
+	t.Log("Start operating list...")
+	if length, err := client.RPush("list", []byte("b"), []byte("c")); err != nil || length != 2 {
+		t.Fatalf("RPush returns wrong length %d and error %v!", length, err)
+	}
+
+	if length, err := client.LPush("list", []byte("a")); err != nil || length != 3 {
+		t.Fatalf("LPush returns wrong length %d and error %v!", length, err)
+	}
+
+	if items, err := client.LRange("list", 0, -1); err != nil || len(items) != 3 || string(items[0]) != "a" || string(items[2]) != "c" {
+		t.Fatalf("LRange returns wrong items %q and error %v!", items, err)
+	}
+
+	if err = client.LTrim("list", 0, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if length, err := client.LLen("list"); err != nil || length != 2 {
+		t.Fatalf("LLen returns wrong length %d and error %v!", length, err)
+	}
+
+	if item, err := client.RPop("list"); err != nil || string(item) != "b" {
+		t.Fatalf("RPop returns wrong item %s and error %v!", string(item), err)
+	}
+
+	if item, err := client.LPop("list"); err != nil || string(item) != "a" {
+		t.Fatalf("LPop returns wrong item %s and error %v!", string(item), err)
+	}
+
+	if _, err = client.LPop("list"); err == nil {
+		t.Fatal("LPop an empty list should fail!")
+	}
+
+	if _, err = client.BRPop("list", 10*time.Millisecond); err == nil {
+		t.Fatal("BRPop an empty list should time out!")
+	}
+
+	// A timeout less than one millisecond shouldn't become 0 which means waiting forever.
+	if _, err = client.BLPop("list", time.Microsecond); err == nil {
+		t.Fatal("BLPop an empty list with a tiny timeout should time out!")
+	}
+
+	popped := make(chan []byte, 1)
+	go func() {
+		item, _ := client.BLPop("list", time.Second)
+		popped <- item
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	if _, err = client.RPush("list", []byte("job")); err != nil {
+		t.Fatal(err)
+	}
+
+	if item := <-popped; string(item) != "job" {
+		t.Fatalf("BLPop returns wrong item %s!", string(item))
+	}
+
+	// A blocking pop of a closed connection shouldn't take items pushed later.
+	conn, err := vex.NewClient("tcp", "127.0.0.1:5837")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go conn.Do(bLPopCommand, [][]byte{[]byte("list"), make([]byte, 8)})
+	time.Sleep(10 * time.Millisecond)
+	conn.Close()
+	time.Sleep(10 * time.Millisecond)
+	if _, err = client.RPush("list", []byte("kept")); err != nil {
+		t.Fatal(err)
+	}
+
+	if item, err := client.LPop("list"); err != nil || string(item) != "kept" {
+		t.Fatalf("Item pushed after closing the connection should be kept but got %s and %v!", string(item), err)
+	}
+
+	if _, err = client.LPush("0", []byte("item")); err == nil || !strings.Contains(err.Error(), "holds a string") {
+		t.Fatalf("LPush a string should return a type error but got %v!", err)
+	}
+
+	t.Log("Start peeking...")
+	if value, err := client.Peek("0"); err != nil || string(value) != "0" {
+		t.Fatalf("Peek key 0 returns wrong value %s and error %v!", string(value), err)
+	}
+
+	if _, err = client.Peek("notExist"); err == nil {
+		t.Fatal("Peek a key not existing should fail!")
+	}
+
+	t.Log("Start changing ttl...")
+	if err = client.SetWithOptions("ttl", []byte("value"), caches.SetOptions{Ttl: time.Minute, Expiration: caches.Absolute}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl, err := client.TTL("ttl"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("TTL returns wrong ttl %v and error %v!", ttl, err)
+	}
+
+	if err = client.Expire("ttl", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl, err := client.TTL("ttl"); err != nil || ttl <= time.Minute {
+		t.Fatalf("TTL after expiring returns wrong ttl %v and error %v!", ttl, err)
+	}
+
+	if err = client.Persist("ttl"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl, err := client.TTL("ttl"); err != nil || ttl != caches.NeverDie {
+		t.Fatalf("TTL after persisting returns wrong ttl %v and error %v!", ttl, err)
+	}
+
+	if err = client.Touch("ttl"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = client.Expire("ttl", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = client.Touch("ttl"); err == nil {
+		t.Fatal("Touch a deleted key should fail!")
+	}
+
+	t.Log("Start incrementing...")
+	if result, err := client.IncrBy("counter", 5); err != nil || result != 5 {
+		t.Fatalf("IncrBy counter returns wrong result %d and error %v!", result, err)
+	}
+
+	if result, err := client.Decr("counter"); err != nil || result != 4 {
+		t.Fatalf("Decr counter returns wrong result %d and error %v!", result, err)
+	}
+
+	if _, err = client.Incr("absolute"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = client.Incr("0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := client.Get("0"); err != nil || string(value) != "1" {
+		t.Fatalf("Get key 0 returns wrong value %s and error %v!", string(value), err)
+	}
+
+	if err = client.Set("text", []byte("value"), caches.NeverDie); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = client.Incr("text"); err == nil {
+		t.Fatal("Incr a text should fail!")
+	}
+
+	for _, key := range []string{"counter", "absolute", "text"} {
+		if err = client.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
 	t.Log("Start getting status...")
 	status, err := client.Status()
 	if err != nil {
@@ -110,3 +454,70 @@ func TestTCPServer(t *testing.T) {
 		t.Fatalf("KeySize %d or valueSize %d is wrong!", status.KeySize, status.ValueSize)
 	}
 }
+
+// go test -cover -run=^TestMillisOf$
+func TestMillisOf(t *testing.T) {
+
+	cases := map[time.Duration]int64{
+		0:                              0,
+		time.Nanosecond:                1,
+		500 * time.Microsecond:         1,
+		time.Millisecond:               1,
+		1500 * time.Microsecond:        2,
+		-500 * time.Microsecond:        0,
+		-2 * time.Millisecond:          -2,
+		time.Minute + time.Microsecond: 60001,
+	}
+
+	for d, expected := range cases {
+		if millis := millisOf(d); millis != expected {
+			t.Fatalf("millisOf(%v) should be %d but got %d!", d, expected, millis)
+		}
+	}
+}
+
+// go test -cover -run=^TestReadVexRequest$
+func TestReadVexRequest(t *testing.T) {
+
+	requestOf := func(version byte, count uint32, args ...[]byte) []byte {
+		request := []byte{version, 1, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(request[2:], count)
+		for _, arg := range args {
+			length := make([]byte, vexLengthSize)
+			binary.BigEndian.PutUint32(length, uint32(len(arg)))
+			request = append(append(request, length...), arg...)
+		}
+		return request
+	}
+
+	large := bytes.Repeat([]byte("a"), 3*vexChunkSize+1)
+	request, err := readVexRequest(bytes.NewReader(requestOf(vex.ProtocolVersion, 2, []byte("key"), large)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if request.command != 1 || len(request.args) != 2 || string(request.args[0]) != "key" || !bytes.Equal(request.args[1], large) {
+		t.Fatalf("Read wrong request with command %d and %d args!", request.command, len(request.args))
+	}
+
+	if _, err = readVexRequest(bytes.NewReader(requestOf(vex.ProtocolVersion+1, 0))); err != vex.ProtocolVersionMismatchErr {
+		t.Fatalf("Read a request in another version should return vex.ProtocolVersionMismatchErr but got %v!", err)
+	}
+
+	if _, err = readVexRequest(bytes.NewReader(requestOf(vex.ProtocolVersion, 1<<31))); err != vexRequestTooLargeErr {
+		t.Fatalf("Read a request with too many args should return vexRequestTooLargeErr but got %v!", err)
+	}
+
+	tooLarge := requestOf(vex.ProtocolVersion, 1)
+	tooLarge = append(tooLarge, 0xFF, 0xFF, 0xFF, 0xFF)
+	if _, err = readVexRequest(bytes.NewReader(tooLarge)); err != vexRequestTooLargeErr {
+		t.Fatalf("Read a request with a too large arg should return vexRequestTooLargeErr but got %v!", err)
+	}
+
+	// A large arg claimed but not sent shouldn't be allocated at once.
+	truncated := requestOf(vex.ProtocolVersion, 1)
+	truncated = append(truncated, 0x10, 0, 0, 0)
+	if _, err = readVexRequest(bytes.NewReader(truncated)); err == nil {
+		t.Fatal("Read a truncated request should fail!")
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2020 Ye Zi Jie.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+//
+// Author: FishGoddess
+// Email: fishgoddess@qq.com
+// Created at 2020/12/24 22:16:08
+
+package servers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/FishGoddess/vex"
+)
+
+const (
+	// vexHeaderSize is the size of header in requests and responses of vex protocol.
+	vexHeaderSize = 6
+
+	// vexLengthSize is the size of length before each arg in requests.
+	vexLengthSize = 4
+
+	// maxVexRequestSize is the max size of all args and their lengths in one request.
+	// The count and lengths of args are sent by the client, so they're checked before allocating anything.
+	maxVexRequestSize = 512 * 1024 * 1024 // 512 MB
+
+	// vexArgsCapacity is the max capacity of args allocated before reading them.
+	vexArgsCapacity = 64
+
+	// vexChunkSize is the max size of an arg allocated before reading it.
+	// A larger arg grows while it's being read, so memory is used by bytes received instead of lengths claimed.
+	vexChunkSize = 64 * 1024 // 64 KB
+)
+
+var (
+	// commandHandlerNotFoundErr means there is no handler of command.
+	commandHandlerNotFoundErr = errors.New("failed to find a handler of command")
+
+	// vexRequestTooLargeErr means the request is larger than maxVexRequestSize.
+	vexRequestTooLargeErr = errors.New("the request is too large")
+)
+
+// connHandler is a handler knowing the connection of request.
+// The ctx is done when the connection or the server is closed, so a handler waiting for something can stop.
+// The undo returned will be called if the response can't be written, so a handler can put things back.
+type connHandler func(ctx context.Context, args [][]byte) (body []byte, undo func(), err error)
+
+// vexServer is a server speaking the protocol of vex, which is the same as vex.Server.
+// Unlike vex.Server, its handlers can know if the client is gone and if the response is written,
+// which blocking pops need to stop waiting and to put items back. vex.Server handles requests in
+// the goroutine reading them and its protocol helpers aren't exported, so the server side is kept here,
+// and vex is still used for clients and constants of the protocol.
+// It also limits the size of requests, so a client can't make it allocate a lot by claiming large lengths.
+type vexServer struct {
+
+	// listener is the listener of server, which is nil if server isn't running.
+	listener net.Listener
+
+	// handlers are handlers of commands.
+	handlers map[byte]connHandler
+
+	// ctx is done when server is closed.
+	ctx context.Context
+
+	// cancel cancels ctx.
+	cancel context.CancelFunc
+
+	// lock guards listener.
+	lock *sync.Mutex
+}
+
+// newVexServer returns a vex server holder.
+func newVexServer() *vexServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &vexServer{
+		handlers: map[byte]connHandler{},
+		ctx:      ctx,
+		cancel:   cancel,
+		lock:     &sync.Mutex{},
+	}
+}
+
+// RegisterHandler registers handler of command, which doesn't need to know the connection.
+func (vs *vexServer) RegisterHandler(command byte, handler func(args [][]byte) (body []byte, err error)) {
+	vs.handlers[command] = func(ctx context.Context, args [][]byte) ([]byte, func(), error) {
+		body, err := handler(args)
+		return body, nil, err
+	}
+}
+
+// RegisterConnHandler registers handler of command, which knows the connection of request.
+func (vs *vexServer) RegisterConnHandler(command byte, handler connHandler) {
+	vs.handlers[command] = handler
+}
+
+// ListenAndServe listens to address in network and serves connections until server is closed.
+// Returns an error if failed to listen.
+func (vs *vexServer) ListenAndServe(network string, address string) error {
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+
+	vs.lock.Lock()
+	vs.listener = listener
+	vs.lock.Unlock()
+
+	wg := &sync.WaitGroup{}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// This error means listener has been closed
+			// See src/internal/poll/fd.go@ErrNetClosing
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				break
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vs.handleConn(conn)
+		}()
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// handleConn handles requests of conn one by one until conn is closed.
+// Requests are read in another goroutine, so a handler can know the client is gone while it's running.
+func (vs *vexServer) handleConn(conn net.Conn) {
+
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(vs.ctx)
+	defer cancel()
+
+	requests := make(chan vexRequest)
+	go func() {
+		defer close(requests)
+		defer cancel()
+		reader := bufio.NewReader(conn)
+		for {
+			request, err := readVexRequest(reader)
+			if err == vex.ProtocolVersionMismatchErr {
+				// Skip requests in another version like vex.Server.
+				continue
+			}
+
+			if err != nil {
+				return
+			}
+
+			select {
+			case requests <- request:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for request := range requests {
+		reply, body, undo := vs.handleRequest(ctx, request)
+		if _, err := writeVexResponse(conn, reply, body); err != nil {
+			if undo != nil {
+				undo()
+			}
+			return
+		}
+	}
+}
+
+// handleRequest runs the handler of request and returns the reply, body and undo of response.
+func (vs *vexServer) handleRequest(ctx context.Context, request vexRequest) (byte, []byte, func()) {
+	handle, ok := vs.handlers[request.command]
+	if !ok {
+		return vex.ErrorReply, []byte(commandHandlerNotFoundErr.Error()), nil
+	}
+
+	body, undo, err := handle(ctx, request.args)
+	if err != nil {
+		return vex.ErrorReply, []byte(err.Error()), undo
+	}
+	return vex.SuccessReply, body, undo
+}
+
+// Close closes the listener of server, and contexts of all connections will be done.
+func (vs *vexServer) Close() error {
+	vs.cancel()
+	vs.lock.Lock()
+	defer vs.lock.Unlock()
+	if vs.listener == nil {
+		return nil
+	}
+	return vs.listener.Close()
+}
+
+// =======================================================================
+
+// vexRequest is a request of vex protocol.
+type vexRequest struct {
+
+	// command is the command of request.
+	command byte
+
+	// args are the args of request.
+	args [][]byte
+}
+
+// readVexRequest reads a request from reader and returns an error if failed.
+// The header of request is version, command and the count of args in uint32,
+// and each arg has its length in uint32 before it.
+// Returns vex.ProtocolVersionMismatchErr after reading the header if the version is different,
+// and vexRequestTooLargeErr if the request is larger than maxVexRequestSize.
+func readVexRequest(reader io.Reader) (vexRequest, error) {
+
+	header := make([]byte, vexHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return vexRequest{}, err
+	}
+
+	if header[0] != vex.ProtocolVersion {
+		return vexRequest{}, vex.ProtocolVersionMismatchErr
+	}
+
+	count := uint64(binary.BigEndian.Uint32(header[2:]))
+	size := count * vexLengthSize
+	if size > maxVexRequestSize {
+		return vexRequest{}, vexRequestTooLargeErr
+	}
+
+	capacity := count
+	if capacity > vexArgsCapacity {
+		capacity = vexArgsCapacity
+	}
+
+	args := make([][]byte, 0, capacity)
+	length := make([]byte, vexLengthSize)
+	for i := uint64(0); i < count; i++ {
+		if _, err := io.ReadFull(reader, length); err != nil {
+			return vexRequest{}, err
+		}
+
+		argSize := uint64(binary.BigEndian.Uint32(length))
+		size += argSize
+		if size > maxVexRequestSize {
+			return vexRequest{}, vexRequestTooLargeErr
+		}
+
+		arg, err := readVexArg(reader, argSize)
+		if err != nil {
+			return vexRequest{}, err
+		}
+		args = append(args, arg)
+	}
+	return vexRequest{command: header[1], args: args}, nil
+}
+
+// readVexArg reads an arg of size from reader and returns an error if failed.
+// An arg larger than vexChunkSize grows while it's being read instead of being allocated at once.
+func readVexArg(reader io.Reader, size uint64) ([]byte, error) {
+	if size <= vexChunkSize {
+		arg := make([]byte, size)
+		_, err := io.ReadFull(reader, arg)
+		return arg, err
+	}
+
+	buffer := bytes.NewBuffer(make([]byte, 0, vexChunkSize))
+	if _, err := io.CopyN(buffer, reader, int64(size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// writeVexResponse writes a response of reply and body to writer and returns an error if failed.
+// The header of response is version, reply and the length of body in uint32.
+func writeVexResponse(writer io.Writer, reply byte, body []byte) (int, error) {
+	response := make([]byte, vexHeaderSize, vexHeaderSize+len(body))
+	response[0] = vex.ProtocolVersion
+	response[1] = reply
+	binary.BigEndian.PutUint32(response[2:], uint32(len(body)))
+	return writer.Write(append(response, body...))
+}